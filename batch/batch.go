@@ -0,0 +1,211 @@
+package batch
+
+import (
+	"encoding/json"
+	"fmt"
+	"kernelscope/cli"
+	"kernelscope/daemon"
+	"kernelscope/reporter"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of one manifest entry
+type Result struct {
+	Name     string               `json:"name"`
+	Expected string               `json:"expected"`
+	Outcome  string               `json:"outcome"`
+	Passed   bool                 `json:"passed"`
+	Mismatch string               `json:"mismatch,omitempty"` // Why the outcome did not match the expectation
+	Report   *reporter.JSONReport `json:"report"`
+}
+
+// Totals aggregates the results of every entry
+type Totals struct {
+	Jobs         int     `json:"jobs"`
+	Passed       int     `json:"passed"`
+	Failed       int     `json:"failed"`
+	CpuSeconds   float64 `json:"cpu_seconds"`
+	JobSeconds   float64 `json:"job_seconds"` // Sum of the jobs' wall-clock durations
+	PeakMemoryKB uint64  `json:"peak_memory_kb"`
+	ReadBytes    uint64  `json:"read_bytes"`
+	WriteBytes   uint64  `json:"write_bytes"`
+}
+
+// Report is the aggregate report of a batch
+type Report struct {
+	Manifest        string    `json:"manifest"`
+	Parallel        int       `json:"parallel"`
+	StartTime       time.Time `json:"start_time"`
+	EndTime         time.Time `json:"end_time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Totals          Totals    `json:"totals"`
+	Jobs            []Result  `json:"jobs"`
+}
+
+// Run executes every entry of the manifest, at most config.Parallel at a time, and reports the results
+func Run(config *cli.BatchConfig) (*Report, error) {
+	manifest, err := LoadManifest(config.ManifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.LogDir != "" {
+		if err := os.MkdirAll(config.LogDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create log directory: %v", err)
+		}
+	}
+
+	report := &Report{
+		Manifest:  config.ManifestPath,
+		Parallel:  config.Parallel,
+		StartTime: time.Now(),
+		Jobs:      make([]Result, len(manifest.Jobs)),
+	}
+
+	// Hand out entries in manifest order to a fixed number of workers
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < config.Parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				report.Jobs[i] = runEntry(&manifest.Jobs[i], config)
+			}
+		}()
+	}
+	for i := range manifest.Jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	report.EndTime = time.Now()
+	report.DurationSeconds = report.EndTime.Sub(report.StartTime).Seconds()
+	report.Totals = summarize(report.Jobs)
+	return report, nil
+}
+
+// runEntry runs a single entry and checks its outcome
+func runEntry(entry *Entry, config *cli.BatchConfig) Result {
+	result := Result{Name: entry.Name, Expected: entry.Expect.describe()}
+
+	job, err := daemon.NewJob(entry.JobSpec)
+	if err != nil {
+		result.Outcome = "invalid"
+		result.Mismatch = err.Error()
+		return result
+	}
+	job.Config.AllowRoot = config.AllowRoot
+
+	fmt.Printf("Batch: starting %s\n", entry.Name)
+	job.Run()
+
+	result.Report = job.Report()
+	result.Outcome = describeOutcome(result.Report.ExitCode, result.Report.TermReason)
+	result.Mismatch = entry.Expect.check(result.Report.ExitCode, result.Report.TermReason,
+		result.Report.SuccessCount > 0, result.Report.SuccessReason)
+	result.Passed = result.Mismatch == ""
+
+	if config.LogDir != "" {
+		if err := writeLogs(job, filepath.Join(config.LogDir, strings.ReplaceAll(entry.Name, "/", "_"))); err != nil {
+			fmt.Printf("Warning: Failed to save output of %s: %v\n", entry.Name, err)
+		}
+	}
+	return result
+}
+
+// writeLogs saves a job's captured stdout and stderr next to each other
+func writeLogs(job *daemon.Job, prefix string) error {
+	for _, stream := range []string{"stdout", "stderr"} {
+		var data []byte
+		for _, chunk := range job.Logs.Chunks(stream) {
+			data = append(data, chunk.Data...)
+		}
+		if err := os.WriteFile(prefix+"."+stream, data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// summarize adds up the results of every entry
+func summarize(results []Result) Totals {
+	totals := Totals{Jobs: len(results)}
+	for _, result := range results {
+		if result.Passed {
+			totals.Passed++
+		} else {
+			totals.Failed++
+		}
+		if result.Report == nil {
+			continue
+		}
+		totals.CpuSeconds += result.Report.CpuSeconds
+		totals.JobSeconds += result.Report.DurationSeconds
+		totals.ReadBytes += result.Report.IO.ReadBytes
+		totals.WriteBytes += result.Report.IO.WriteBytes
+		if result.Report.PeakMemoryKB > totals.PeakMemoryKB {
+			totals.PeakMemoryKB = result.Report.PeakMemoryKB
+		}
+	}
+	return totals
+}
+
+// Print prints the per-job table followed by the totals
+func (report *Report) Print() {
+	fmt.Println("\n=========== KernelScope Batch Report ===========")
+	fmt.Printf("%-24s %-6s %-24s %-24s %9s %9s %12s\n", "JOB", "RESULT", "EXPECTED", "OUTCOME", "WALL(s)", "CPU(s)", "PEAK MEM(KB)")
+	for _, result := range report.Jobs {
+		status := "PASS"
+		if !result.Passed {
+			status = "FAIL"
+		}
+		var wall, cpu float64
+		var mem uint64
+		if result.Report != nil {
+			wall, cpu, mem = result.Report.DurationSeconds, result.Report.CpuSeconds, result.Report.PeakMemoryKB
+		}
+		fmt.Printf("%-24s %-6s %-24s %-24s %9.2f %9.2f %12d\n",
+			truncate(result.Name, 24), status, truncate(result.Expected, 24), truncate(result.Outcome, 24), wall, cpu, mem)
+	}
+
+	totals := report.Totals
+	fmt.Println("------------------------------------------------")
+	fmt.Printf("Jobs: %d | Passed: %d | Failed: %d\n", totals.Jobs, totals.Passed, totals.Failed)
+	fmt.Printf("Batch Duration: %.2f seconds (%.2f job-seconds, parallel %d)\n", report.DurationSeconds, totals.JobSeconds, report.Parallel)
+	fmt.Printf("Total CPU Time: %.2f seconds\n", totals.CpuSeconds)
+	fmt.Printf("Largest Peak Memory: %d KB\n", totals.PeakMemoryKB)
+	fmt.Printf("Storage I/O: %d bytes read, %d bytes written\n", totals.ReadBytes, totals.WriteBytes)
+
+	for _, result := range report.Jobs {
+		if result.Mismatch != "" {
+			fmt.Printf("FAIL %s: %s\n", result.Name, result.Mismatch)
+		}
+	}
+	fmt.Println("================================================")
+}
+
+// WriteJSON writes the aggregate report, including every job's full report, to path
+func (report *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode batch report: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write batch report: %v", err)
+	}
+	return nil
+}
+
+// truncate shortens s to at most n characters for the table
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-1] + "~"
+}
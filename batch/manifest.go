@@ -0,0 +1,227 @@
+package batch
+
+import (
+	"fmt"
+	"kernelscope/daemon"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest is a batch of jobs read from a YAML file
+type Manifest struct {
+	Defaults daemon.JobSpec `yaml:"defaults"` // Applied to every entry that leaves a field unset
+	Jobs     []Entry        `yaml:"jobs"`
+}
+
+// Entry is a single job in the manifest
+type Entry struct {
+	Name           string `yaml:"name"`
+	daemon.JobSpec `yaml:",inline"`
+	Expect         Expect `yaml:"expect"`
+}
+
+// Expect is the outcome an entry must have to pass
+type Expect struct {
+	ExitCode    *int   `yaml:"exit_code"`   // Expected exit code, 0 unless a termination is expected
+	Termination string `yaml:"termination"` // Expected termination reason, e.g. "Timeout"
+}
+
+// LoadManifest reads a manifest and fills in the defaults of every entry.
+// Relative binary paths are resolved against the manifest's directory.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+
+	manifest := &Manifest{}
+	if err := yaml.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %v", err)
+	}
+	if len(manifest.Jobs) == 0 {
+		return nil, fmt.Errorf("manifest %s has no jobs", path)
+	}
+
+	dir := filepath.Dir(path)
+	for i := range manifest.Jobs {
+		entry := &manifest.Jobs[i]
+		applyDefaults(&entry.JobSpec, &manifest.Defaults)
+
+		if entry.Binary == "" {
+			return nil, fmt.Errorf("job %d has no binary", i+1)
+		}
+		if strings.Contains(entry.Binary, "/") && !filepath.IsAbs(entry.Binary) {
+			entry.Binary = filepath.Join(dir, entry.Binary)
+		}
+		if ws := entry.Workspace; ws != nil && ws.Template != "" && !filepath.IsAbs(ws.Template) {
+			copied := *ws
+			copied.Template = filepath.Join(dir, ws.Template)
+			entry.Workspace = &copied
+		}
+		if strings.HasSuffix(entry.Seccomp, ".json") && !filepath.IsAbs(entry.Seccomp) {
+			entry.Seccomp = filepath.Join(dir, entry.Seccomp)
+		}
+		if entry.Name == "" {
+			entry.Name = fmt.Sprintf("%d-%s", i+1, filepath.Base(entry.Binary))
+		}
+		// The expected exit code is the one the success criteria accept
+		if entry.Expect.ExitCode != nil && entry.Expect.Termination == "" && len(entry.Success.ExitCodes) == 0 {
+			entry.Success.ExitCodes = []int{*entry.Expect.ExitCode}
+		}
+		if _, err := entry.JobSpec.Config(); err != nil {
+			return nil, fmt.Errorf("job %s: %v", entry.Name, err)
+		}
+	}
+	return manifest, nil
+}
+
+// applyDefaults copies every field left unset in spec from defaults
+func applyDefaults(spec *daemon.JobSpec, defaults *daemon.JobSpec) {
+	if spec.Binary == "" {
+		spec.Binary = defaults.Binary
+	}
+	if spec.Args == nil {
+		spec.Args = defaults.Args
+	}
+	if spec.Account == "" {
+		spec.Account = defaults.Account
+	}
+	if spec.Prepaid == nil {
+		spec.Prepaid = defaults.Prepaid
+	}
+	if spec.Credit == 0 {
+		spec.Credit = defaults.Credit
+	}
+	if spec.Sandbox == nil {
+		spec.Sandbox = defaults.Sandbox
+	}
+	if spec.Seccomp == "" {
+		spec.Seccomp = defaults.Seccomp
+	}
+	if spec.Landlock == nil {
+		spec.Landlock = defaults.Landlock
+	}
+	if spec.User == "" {
+		spec.User = defaults.User
+	}
+	if spec.Group == "" {
+		spec.Group = defaults.Group
+	}
+	if spec.Groups == nil {
+		spec.Groups = defaults.Groups
+	}
+	if spec.Workspace == nil {
+		spec.Workspace = defaults.Workspace
+	}
+	if spec.Audit == nil {
+		spec.Audit = defaults.Audit
+	}
+	if spec.Watch == nil {
+		spec.Watch = defaults.Watch
+	}
+	if spec.Network == nil {
+		spec.Network = defaults.Network
+	}
+	if spec.Trace == nil {
+		spec.Trace = defaults.Trace
+	}
+	if reflect.ValueOf(spec.Success).IsZero() {
+		spec.Success = defaults.Success
+	}
+
+	// Entry variables override the default ones
+	if len(defaults.Env) > 0 {
+		env := make(map[string]string, len(defaults.Env)+len(spec.Env))
+		for key, value := range defaults.Env {
+			env[key] = value
+		}
+		for key, value := range spec.Env {
+			env[key] = value
+		}
+		spec.Env = env
+	}
+
+	limits, base := &spec.Limits, defaults.Limits
+	if limits.CpuSeconds == 0 {
+		limits.CpuSeconds = base.CpuSeconds
+	}
+	if limits.MemoryKB == 0 {
+		limits.MemoryKB = base.MemoryKB
+	}
+	if limits.TimeoutSeconds == 0 {
+		limits.TimeoutSeconds = base.TimeoutSeconds
+	}
+	if limits.IdleTimeout == 0 {
+		limits.IdleTimeout = base.IdleTimeout
+	}
+	if limits.OutputTimeout == 0 {
+		limits.OutputTimeout = base.OutputTimeout
+	}
+	if limits.MaxWriteBytes == 0 {
+		limits.MaxWriteBytes = base.MaxWriteBytes
+	}
+	if limits.MaxReadBytes == 0 {
+		limits.MaxReadBytes = base.MaxReadBytes
+	}
+	if limits.MaxThreads == 0 {
+		limits.MaxThreads = base.MaxThreads
+	}
+	if limits.MaxFDs == 0 {
+		limits.MaxFDs = base.MaxFDs
+	}
+	if limits.MaxProcs == 0 {
+		limits.MaxProcs = base.MaxProcs
+	}
+}
+
+// check compares a job's outcome with the expectation and describes any mismatch.
+// Unless a termination is expected, the job must also meet its success criteria.
+func (expect Expect) check(exitCode int, termReason string, succeeded bool, successReason string) string {
+	if expect.Termination != "" {
+		if !strings.EqualFold(termReason, expect.Termination) {
+			return fmt.Sprintf("expected termination %q, got %s", expect.Termination, describeOutcome(exitCode, termReason))
+		}
+		if expect.ExitCode != nil && exitCode != *expect.ExitCode {
+			return fmt.Sprintf("expected exit code %d, got %d", *expect.ExitCode, exitCode)
+		}
+		return ""
+	}
+
+	if termReason != "" {
+		return fmt.Sprintf("unexpected termination: %s", termReason)
+	}
+	expected := 0
+	if expect.ExitCode != nil {
+		expected = *expect.ExitCode
+	}
+	if exitCode != expected {
+		return fmt.Sprintf("expected exit code %d, got %d", expected, exitCode)
+	}
+	if !succeeded {
+		return fmt.Sprintf("success criteria not met: %s", successReason)
+	}
+	return ""
+}
+
+// describe returns the expectation in a short human-readable form
+func (expect Expect) describe() string {
+	if expect.Termination != "" {
+		return expect.Termination
+	}
+	if expect.ExitCode != nil {
+		return fmt.Sprintf("exit %d", *expect.ExitCode)
+	}
+	return "exit 0"
+}
+
+// describeOutcome returns a job's outcome in a short human-readable form
+func describeOutcome(exitCode int, termReason string) string {
+	if termReason != "" {
+		return termReason
+	}
+	return fmt.Sprintf("exit %d", exitCode)
+}
@@ -0,0 +1,97 @@
+package batch
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpectCheck(t *testing.T) {
+	code := func(c int) *int { return &c }
+
+	tests := []struct {
+		name          string
+		expect        Expect
+		exitCode      int
+		termReason    string
+		succeeded     bool
+		successReason string
+		want          string
+	}{
+		{name: "success", succeeded: true},
+		{name: "expected exit code", expect: Expect{ExitCode: code(2)}, exitCode: 2, succeeded: true},
+		{name: "wrong exit code", exitCode: 1, want: "expected exit code 0, got 1"},
+		{
+			name:          "output criteria not met",
+			succeeded:     false,
+			successReason: `stdout does not match "^ok"`,
+			want:          `success criteria not met: stdout does not match "^ok"`,
+		},
+		{
+			name:          "expected exit code with failed criteria",
+			expect:        Expect{ExitCode: code(3)},
+			exitCode:      3,
+			successReason: "stderr matches \"panic\"",
+			want:          "success criteria not met: stderr matches \"panic\"",
+		},
+		{name: "unexpected termination", termReason: "Timeout", want: "unexpected termination: Timeout"},
+		{name: "expected termination", expect: Expect{Termination: "timeout"}, termReason: "Timeout"},
+		{name: "missing termination", expect: Expect{Termination: "Timeout"}, succeeded: true, want: `expected termination "Timeout", got exit 0`},
+		{name: "termination with exit code", expect: Expect{Termination: "Timeout", ExitCode: code(-1)}, exitCode: 9, termReason: "Timeout", want: "expected exit code -1, got 9"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.expect.check(test.exitCode, test.termReason, test.succeeded, test.successReason)
+			if got != test.want {
+				t.Errorf("check = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "jobs.yaml")
+	manifest := `
+defaults:
+  env: {LANG: C}
+  success: {stdout_match: "^ok"}
+jobs:
+  - binary: ./bin/parser
+    env: {LOG_LEVEL: debug}
+  - name: bad-input
+    binary: /bin/false
+    expect: {exit_code: 1}
+  - name: chosen-codes
+    binary: /bin/false
+    success: {exit_codes: [0, 1]}
+    expect: {exit_code: 1}
+`
+	if err := os.WriteFile(path, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadManifest(path)
+	if err != nil {
+		t.Fatalf("LoadManifest: %v", err)
+	}
+
+	parser, bad, chosen := loaded.Jobs[0], loaded.Jobs[1], loaded.Jobs[2]
+	if parser.Name != "1-parser" || parser.Binary != filepath.Join(dir, "bin/parser") {
+		t.Errorf("first job = %q running %q", parser.Name, parser.Binary)
+	}
+	if !reflect.DeepEqual(parser.Env, map[string]string{"LANG": "C", "LOG_LEVEL": "debug"}) {
+		t.Errorf("env = %v, want the defaults merged in", parser.Env)
+	}
+	if parser.Success.StdoutMatch != "^ok" || parser.Success.ExitCodes != nil {
+		t.Errorf("success = %+v, want the default criteria", parser.Success)
+	}
+	if !reflect.DeepEqual(bad.Success.ExitCodes, []int{1}) || bad.Success.StdoutMatch != "^ok" {
+		t.Errorf("success = %+v, want the expected exit code accepted", bad.Success)
+	}
+	if !reflect.DeepEqual(chosen.Success.ExitCodes, []int{0, 1}) || chosen.Success.StdoutMatch != "" {
+		t.Errorf("success = %+v, want the entry's own criteria", chosen.Success)
+	}
+}
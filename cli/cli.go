@@ -1,55 +1,393 @@
-package cli
-
-import (
-	"flag"
-	"fmt"
-	"os"
-)
-
-// Config holds all the command-line parameters
-type Config struct {
-	BinaryPath    string  // Path to the binary to execute
-	CpuLimit      int     // CPU time limit in seconds
-	MemoryLimit   int     // Memory limit in KB
-	Timeout       int     // Timeout in seconds
-	PrePaidMode   bool    // Run in prepaid mode (true) or postpaid mode (false)
-	CpuCredit     float64 // CPU credits in seconds for prepaid mode
-}
-
-// ParseArgs parses command-line arguments and returns a Config
-func ParseArgs() *Config {
-	config := &Config{}
-
-	flag.StringVar(&config.BinaryPath, "binary", "", "Path to the binary to execute (required)")
-	flag.IntVar(&config.CpuLimit, "cpu", 10, "CPU time limit in seconds")
-	flag.IntVar(&config.MemoryLimit, "mem", 1024*1024, "Memory limit in KB")
-	flag.IntVar(&config.Timeout, "timeout", 30, "Timeout in seconds")
-	flag.BoolVar(&config.PrePaidMode, "prepaid", true, "Run in prepaid mode (true) or postpaid mode (false)")
-	flag.Float64Var(&config.CpuCredit, "credit", 5.0, "CPU credits in seconds for prepaid mode")
-
-	flag.Parse()
-
-	// Validate that binary path is provided
-	if config.BinaryPath == "" {
-		fmt.Println("Error: Binary path is required")
-		flag.Usage()
-		os.Exit(1)
-	}
-
-	return config
-}
-
-// DisplayConfig prints the current configuration
-func DisplayConfig(config *Config) {
-	fmt.Println("=== KernelScope Configuration ===")
-	fmt.Printf("Binary:       %s\n", config.BinaryPath)
-	fmt.Printf("CPU Limit:    %d seconds\n", config.CpuLimit)
-	fmt.Printf("Memory Limit: %d KB\n", config.MemoryLimit)
-	fmt.Printf("Timeout:      %d seconds\n", config.Timeout)
-	if config.PrePaidMode {
-		fmt.Printf("Mode:         Prepaid with %.2f CPU credits\n", config.CpuCredit)
-	} else {
-		fmt.Println("Mode:         Postpaid")
-	}
-	fmt.Println("===============================")
-} 
\ No newline at end of file
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds all the command-line parameters
+type Config struct {
+	BinaryPath       string        // Path to the binary to execute
+	Args             []string      // Arguments passed to the binary
+	CpuLimit         int           // CPU time limit in seconds
+	CpuRate          float64       // Cap on the tree's CPU use in cores (0 = unlimited)
+	MemoryLimit      int           // Memory limit in KB
+	Timeout          int           // Timeout in seconds
+	IdleTimeout      int           // Terminate after this many seconds without CPU progress (0 = disabled)
+	OutputTimeout    int           // Terminate after this many seconds without output (0 = disabled)
+	PrePaidMode      bool          // Run in prepaid mode (true) or postpaid mode (false)
+	CpuCredit        float64       // CPU credits in seconds for prepaid mode
+	MaxWriteBytes    int64         // Maximum bytes written to storage by the tree (0 = unlimited)
+	MaxReadBytes     int64         // Maximum bytes read from storage by the tree (0 = unlimited)
+	MaxThreads       int           // Maximum threads across the tree (0 = unlimited)
+	MaxFDs           int           // Maximum open file descriptors across the tree, and of each process (0 = unlimited)
+	MaxProcs         int           // Maximum descendant processes (0 = unlimited)
+	SampleInterval   time.Duration // Interval between resource usage samples
+	AdaptiveSampling bool          // Sample faster near limits and slower when idle
+	TimeSeriesPath   string        // Write every sample to this .csv or .json file
+	JSONReportPath   string        // Write the final report as JSON to this file
+	MetricsAddr      string        // Serve Prometheus metrics on this address
+	OTLPEndpoint     string        // Export traces and metrics to this OTLP/HTTP collector
+	OTLPServiceName  string        // service.name reported to the OTLP collector
+	Env              []string      // Extra KEY=VALUE environment variables for the binary
+	Account          string        // Account the CPU usage is billed to
+
+	SuccessExitCodes   []int  // Exit codes that count as success (default 0)
+	StdoutMustMatch    string // Regex stdout must match for success
+	StdoutMustNotMatch string // Regex stdout must not match for success
+	StderrMustMatch    string // Regex stderr must match for success
+	StderrMustNotMatch string // Regex stderr must not match for success
+	SuccessFile        string // File that must exist after the run for success
+	SuccessCommand     string // Shell command that must exit with 0 for success
+
+	Sandbox          bool     // Run the binary in new user, PID, mount, UTS, IPC and network namespaces
+	SandboxNetwork   bool     // Keep the host network inside the sandbox
+	SandboxReadOnly  []string // Host paths bind-mounted read-only into the sandbox (path or src:dst)
+	SandboxReadWrite []string // Host paths bind-mounted read-write into the sandbox (path or src:dst)
+	SeccompProfile   string   // Built-in seccomp profile name or path to a JSON profile
+	AllowRead        []string // Paths the binary may read when Landlock is used
+	AllowWrite       []string // Paths the binary may read and write when Landlock is used
+	AllowExec        []string // Paths the binary may read and execute when Landlock is used
+
+	User                string   // Run the binary as this user (name or uid)
+	Group               string   // Run the binary with this primary group (name or gid)
+	SupplementaryGroups []string // Supplementary groups of the binary (names or gids)
+	AllowRoot           bool     // Allow the binary to run as root
+
+	Workspace         bool     // Run in a fresh temporary directory that is removed afterwards
+	WorkspaceTemplate string   // Directory copied into the workspace before the run
+	WorkspaceSizeKB   int64    // Size of the workspace tmpfs in sandbox mode (0 = plain directory)
+	Collect           []string // Glob patterns of workspace files copied out after the run
+	ArtifactsDir      string   // Directory collected files are copied into
+	Audit             bool     // Report the files the run created, modified and deleted in its workspace
+	Watch             []string // Further paths audited for changes
+	AuditNetwork      bool     // Report the sockets the tree opened and, in its own network namespace, its traffic
+
+	TraceSyscalls bool   // Run the binary under ptrace and count its system calls
+	TraceLog      string // Write every traced system call to this file
+}
+
+// UsesWorkspace reports whether the run gets its own temporary working directory
+func (config *Config) UsesWorkspace() bool {
+	return config.Workspace || config.WorkspaceTemplate != "" || config.WorkspaceSizeKB > 0 || len(config.Collect) > 0
+}
+
+// AuditsFiles reports whether the run's filesystem changes are reported
+func (config *Config) AuditsFiles() bool {
+	return config.Audit || len(config.Watch) > 0
+}
+
+// DropsPrivileges reports whether the binary runs under a different identity
+func (config *Config) DropsPrivileges() bool {
+	return config.User != "" || config.Group != "" || len(config.SupplementaryGroups) > 0
+}
+
+// UsesLandlock reports whether filesystem access is restricted with Landlock
+func (config *Config) UsesLandlock() bool {
+	return len(config.AllowRead) > 0 || len(config.AllowWrite) > 0 || len(config.AllowExec) > 0
+}
+
+// SeccompProfiles are the built-in seccomp profiles
+var SeccompProfiles = []string{"strict", "no-network", "no-exec"}
+
+// stringList is a flag that can be repeated, collecting every value
+type stringList struct {
+	values *[]string
+}
+
+func (l stringList) String() string {
+	if l.values == nil {
+		return ""
+	}
+	return strings.Join(*l.values, ",")
+}
+
+func (l stringList) Set(value string) error {
+	*l.values = append(*l.values, value)
+	return nil
+}
+
+// DefaultConfig returns a Config with the default value of every parameter
+func DefaultConfig() *Config {
+	return &Config{
+		CpuLimit:        10,
+		MemoryLimit:     1024 * 1024,
+		Timeout:         30,
+		PrePaidMode:     true,
+		CpuCredit:       5.0,
+		SampleInterval:  time.Second,
+		OTLPServiceName: "kernelscope",
+		ArtifactsDir:    "artifacts",
+	}
+}
+
+// ParseArgs parses command-line arguments and returns a Config
+func ParseArgs() *Config {
+	config := DefaultConfig()
+
+	flag.StringVar(&config.BinaryPath, "binary", "", "Path to the binary to execute (required)")
+	flag.IntVar(&config.CpuLimit, "cpu", config.CpuLimit, "CPU time limit in seconds")
+	flag.Float64Var(&config.CpuRate, "cpu-rate", 0, "Cap the process tree at this many cores, e.g. 0.5 (0 = unlimited)")
+	flag.IntVar(&config.MemoryLimit, "mem", config.MemoryLimit, "Memory limit in KB")
+	flag.IntVar(&config.Timeout, "timeout", config.Timeout, "Timeout in seconds")
+	flag.IntVar(&config.IdleTimeout, "idle-timeout", 0, "Terminate the tree after this many seconds without CPU progress (0 = disabled)")
+	flag.IntVar(&config.OutputTimeout, "output-timeout", 0, "Terminate the tree after this many seconds without writing to stdout or stderr (0 = disabled)")
+	flag.BoolVar(&config.PrePaidMode, "prepaid", config.PrePaidMode, "Run in prepaid mode (true) or postpaid mode (false)")
+	flag.Float64Var(&config.CpuCredit, "credit", config.CpuCredit, "CPU credits in seconds for prepaid mode")
+	flag.Int64Var(&config.MaxWriteBytes, "max-write-bytes", 0, "Maximum bytes the process tree may write to storage (0 = unlimited)")
+	flag.Int64Var(&config.MaxReadBytes, "max-read-bytes", 0, "Maximum bytes the process tree may read from storage (0 = unlimited)")
+	flag.IntVar(&config.MaxThreads, "max-threads", 0, "Maximum number of threads across the process tree (0 = unlimited)")
+	flag.IntVar(&config.MaxFDs, "max-fds", 0, "Maximum number of open file descriptors across the process tree; each process is also capped at this many (0 = unlimited)")
+	flag.IntVar(&config.MaxProcs, "max-procs", 0, "Maximum number of descendant processes (0 = unlimited)")
+	flag.DurationVar(&config.SampleInterval, "sample-interval", config.SampleInterval, "Interval between resource usage samples (e.g. 250ms, 5s)")
+	flag.BoolVar(&config.AdaptiveSampling, "adaptive-sampling", false, "Sample faster as usage nears a limit and slower when idle")
+	flag.StringVar(&config.TimeSeriesPath, "timeseries", "", "Write the full resource time series to a .csv or .json file")
+	flag.StringVar(&config.MetricsAddr, "metrics-addr", "", "Serve Prometheus/OpenMetrics metrics on this address (e.g. :9100)")
+	flag.StringVar(&config.OTLPEndpoint, "otlp-endpoint", "", "Export traces and metrics to this OTLP/HTTP collector (e.g. http://localhost:4318)")
+	flag.StringVar(&config.OTLPServiceName, "otlp-service-name", config.OTLPServiceName, "service.name reported to the OTLP collector")
+	flag.StringVar(&config.Account, "account", "", "Account the CPU usage is billed to")
+	flag.StringVar(&config.JSONReportPath, "json-report", "", "Write the final report, including the process tree, as JSON to this file")
+	flag.Func("success-exit-codes", "Comma-separated exit codes that count as success (default 0)", func(value string) error {
+		codes, err := ParseExitCodes(value)
+		config.SuccessExitCodes = codes
+		return err
+	})
+	flag.StringVar(&config.StdoutMustMatch, "stdout-match", "", "Count the run as a success only if stdout matches this regex")
+	flag.StringVar(&config.StdoutMustNotMatch, "stdout-reject", "", "Count the run as a failure if stdout matches this regex")
+	flag.StringVar(&config.StderrMustMatch, "stderr-match", "", "Count the run as a success only if stderr matches this regex")
+	flag.StringVar(&config.StderrMustNotMatch, "stderr-reject", "", "Count the run as a failure if stderr matches this regex")
+	flag.StringVar(&config.SuccessFile, "success-file", "", "Count the run as a success only if this file exists afterwards")
+	flag.StringVar(&config.SuccessCommand, "success-command", "", "Shell command that must exit with 0 for the run to count as a success")
+	flag.BoolVar(&config.Sandbox, "sandbox", false, "Run the binary in new user, PID, mount, UTS, IPC and network namespaces with a private /tmp")
+	flag.BoolVar(&config.SandboxNetwork, "sandbox-network", false, "Keep the host network inside the sandbox")
+	flag.Var(stringList{&config.SandboxReadOnly}, "ro-bind", "Bind a host path read-only into the sandbox, as path or src:dst (repeatable)")
+	flag.Var(stringList{&config.SandboxReadWrite}, "bind", "Bind a host path read-write into the sandbox, as path or src:dst (repeatable)")
+	flag.Var(stringList{&config.AllowRead}, "allow-read", "Restrict filesystem access with Landlock, allowing reads beneath this path (repeatable)")
+	flag.Var(stringList{&config.AllowWrite}, "allow-write", "Restrict filesystem access with Landlock, allowing reads and writes beneath this path (repeatable)")
+	flag.Var(stringList{&config.AllowExec}, "allow-exec", "Restrict filesystem access with Landlock, allowing reads and execution beneath this path (repeatable)")
+	flag.StringVar(&config.User, "user", "", "Run the binary as this user (name or uid), dropping all capabilities")
+	flag.StringVar(&config.Group, "group", "", "Run the binary with this primary group (name or gid), default the user's group")
+	flag.Func("supplementary-groups", "Comma-separated supplementary groups of the binary (names or gids)", func(value string) error {
+		config.SupplementaryGroups = splitList(value)
+		return nil
+	})
+	flag.BoolVar(&config.AllowRoot, "allow-root", false, "Allow the binary to run as root")
+	flag.BoolVar(&config.Workspace, "workspace", false, "Run in a fresh temporary working directory that is removed afterwards")
+	flag.StringVar(&config.WorkspaceTemplate, "workspace-template", "", "Seed the workspace with a copy of this directory")
+	flag.Int64Var(&config.WorkspaceSizeKB, "workspace-size", 0, "Make the workspace a tmpfs of this many KB (requires --sandbox)")
+	flag.Var(stringList{&config.Collect}, "collect", "Copy workspace files matching this glob into the artifacts directory after the run (repeatable)")
+	flag.StringVar(&config.ArtifactsDir, "artifacts-dir", config.ArtifactsDir, "Directory collected files are copied into, one subdirectory per run")
+	flag.BoolVar(&config.Audit, "audit", false, "Report the files the run created, modified and deleted in its workspace")
+	flag.Var(stringList{&config.Watch}, "watch", "Also report changes beneath this path (repeatable)")
+	flag.BoolVar(&config.AuditNetwork, "audit-network", false, "Report the sockets the process tree opens, and its traffic when it has its own network namespace")
+	flag.BoolVar(&config.TraceSyscalls, "trace-syscalls", false, "Count the system calls of every process in the tree and the time spent in them, using ptrace")
+	flag.StringVar(&config.TraceLog, "trace-log", "", "Write every traced system call to this file (requires --trace-syscalls)")
+	flag.StringVar(&config.SeccompProfile, "seccomp", "", "Seccomp profile: strict, no-network, no-exec or a JSON file with allow/deny lists")
+
+	flag.Parse()
+	config.Args = flag.Args()
+
+	if err := config.Validate(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	return config
+}
+
+// Validate checks that the configuration is usable
+func (config *Config) Validate() error {
+	// Validate that binary path is provided
+	if config.BinaryPath == "" {
+		return fmt.Errorf("binary path is required")
+	}
+
+	if config.SampleInterval <= 0 {
+		return fmt.Errorf("sample interval must be positive")
+	}
+
+	if config.CpuRate < 0 {
+		return fmt.Errorf("--cpu-rate must not be negative")
+	}
+
+	if ext := strings.ToLower(filepath.Ext(config.TimeSeriesPath)); config.TimeSeriesPath != "" && ext != ".csv" && ext != ".json" {
+		return fmt.Errorf("time series file must end in .csv or .json")
+	}
+
+	if !config.Sandbox && (config.SandboxNetwork || len(config.SandboxReadOnly) > 0 || len(config.SandboxReadWrite) > 0) {
+		return fmt.Errorf("--sandbox-network, --ro-bind and --bind require --sandbox")
+	}
+
+	if config.WorkspaceSizeKB > 0 && !config.Sandbox {
+		return fmt.Errorf("--workspace-size requires --sandbox")
+	}
+	if config.WorkspaceTemplate != "" {
+		if info, err := os.Stat(config.WorkspaceTemplate); err != nil || !info.IsDir() {
+			return fmt.Errorf("workspace template %s must be a directory", config.WorkspaceTemplate)
+		}
+	}
+
+	if config.Audit && !config.UsesWorkspace() {
+		return fmt.Errorf("--audit requires a workspace, use --watch to audit other paths")
+	}
+
+	if config.TraceLog != "" && !config.TraceSyscalls {
+		return fmt.Errorf("--trace-log requires --trace-syscalls")
+	}
+
+	if config.Sandbox && len(config.SupplementaryGroups) > 0 {
+		return fmt.Errorf("--supplementary-groups cannot be used with --sandbox")
+	}
+
+	if config.SeccompProfile != "" && !isSeccompProfile(config.SeccompProfile) {
+		if _, err := os.Stat(config.SeccompProfile); err != nil {
+			return fmt.Errorf("seccomp profile must be one of %s or a JSON file: %v", strings.Join(SeccompProfiles, ", "), err)
+		}
+	}
+
+	for _, pattern := range []string{config.StdoutMustMatch, config.StdoutMustNotMatch, config.StderrMustMatch, config.StderrMustNotMatch} {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid output pattern %q: %v", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// ParseExitCodes parses a comma-separated list of exit codes
+func ParseExitCodes(value string) ([]int, error) {
+	var codes []int
+	for _, field := range strings.Split(value, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid exit code %q", field)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// DisplayConfig prints the current configuration
+func DisplayConfig(config *Config) {
+	fmt.Println("=== KernelScope Configuration ===")
+	fmt.Printf("Binary:       %s\n", config.BinaryPath)
+	if len(config.Args) > 0 {
+		fmt.Printf("Arguments:    %s\n", strings.Join(config.Args, " "))
+	}
+	fmt.Printf("CPU Limit:    %d seconds\n", config.CpuLimit)
+	if config.CpuRate > 0 {
+		fmt.Printf("CPU Rate:     %.2f cores\n", config.CpuRate)
+	}
+	fmt.Printf("Memory Limit: %d KB\n", config.MemoryLimit)
+	fmt.Printf("Timeout:      %d seconds\n", config.Timeout)
+	if config.IdleTimeout > 0 {
+		fmt.Printf("Idle Timeout: %d seconds\n", config.IdleTimeout)
+	}
+	if config.OutputTimeout > 0 {
+		fmt.Printf("Output Timeout: %d seconds\n", config.OutputTimeout)
+	}
+	if config.MaxWriteBytes > 0 {
+		fmt.Printf("Write Limit:  %d bytes\n", config.MaxWriteBytes)
+	}
+	if config.MaxReadBytes > 0 {
+		fmt.Printf("Read Limit:   %d bytes\n", config.MaxReadBytes)
+	}
+	if config.MaxThreads > 0 {
+		fmt.Printf("Thread Limit: %d\n", config.MaxThreads)
+	}
+	if config.MaxFDs > 0 {
+		fmt.Printf("FD Limit:     %d\n", config.MaxFDs)
+	}
+	if config.MaxProcs > 0 {
+		fmt.Printf("Proc Limit:   %d\n", config.MaxProcs)
+	}
+	if config.PrePaidMode {
+		fmt.Printf("Mode:         Prepaid with %.2f CPU credits\n", config.CpuCredit)
+	} else {
+		fmt.Println("Mode:         Postpaid")
+	}
+	if config.Account != "" {
+		fmt.Printf("Account:      %s\n", config.Account)
+	}
+	if config.AdaptiveSampling {
+		fmt.Printf("Sampling:     adaptive around %v\n", config.SampleInterval)
+	} else {
+		fmt.Printf("Sampling:     every %v\n", config.SampleInterval)
+	}
+	if config.TimeSeriesPath != "" {
+		fmt.Printf("Time Series:  %s\n", config.TimeSeriesPath)
+	}
+	if config.JSONReportPath != "" {
+		fmt.Printf("JSON Report:  %s\n", config.JSONReportPath)
+	}
+	if config.MetricsAddr != "" {
+		fmt.Printf("Metrics:      %s\n", config.MetricsAddr)
+	}
+	if config.OTLPEndpoint != "" {
+		fmt.Printf("OTLP:         %s\n", config.OTLPEndpoint)
+	}
+	if config.Sandbox {
+		network := "none"
+		if config.SandboxNetwork {
+			network = "host"
+		}
+		fmt.Printf("Sandbox:      namespaces, network %s\n", network)
+	}
+	if config.SeccompProfile != "" {
+		fmt.Printf("Seccomp:      %s\n", config.SeccompProfile)
+	}
+	if config.DropsPrivileges() {
+		fmt.Printf("Run As:       user %q, group %q, groups %v\n", config.User, config.Group, config.SupplementaryGroups)
+	}
+	if config.UsesWorkspace() {
+		fmt.Printf("Workspace:    template %q, collect %v\n", config.WorkspaceTemplate, config.Collect)
+	}
+	if config.AuditsFiles() {
+		fmt.Printf("Audit:        workspace %v, watch %v\n", config.Audit, config.Watch)
+	}
+	if config.AuditNetwork {
+		fmt.Println("Network:      sockets audited")
+	}
+	if config.TraceSyscalls {
+		fmt.Printf("Tracing:      system calls, log %q\n", config.TraceLog)
+	}
+	if config.UsesLandlock() {
+		fmt.Printf("Landlock:     read %v, write %v, exec %v\n", config.AllowRead, config.AllowWrite, config.AllowExec)
+	}
+	if len(config.SuccessExitCodes) > 0 {
+		fmt.Printf("Success Exit: %v\n", config.SuccessExitCodes)
+	}
+	if config.StdoutMustMatch != "" || config.StdoutMustNotMatch != "" || config.StderrMustMatch != "" ||
+		config.StderrMustNotMatch != "" || config.SuccessFile != "" || config.SuccessCommand != "" {
+		fmt.Println("Success:      custom criteria")
+	}
+	fmt.Println("===============================")
+}
+
+// isSeccompProfile reports whether name is a built-in seccomp profile
+func isSeccompProfile(name string) bool {
+	for _, profile := range SeccompProfiles {
+		if profile == name {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
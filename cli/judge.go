@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// JudgeConfig holds the parameters of "kernelscope judge"
+type JudgeConfig struct {
+	Run            *Config // Binary and limits applied to every test case
+	TestsDir       string  // Directory of NAME.in and NAME.out (or NAME.ans) pairs
+	Compare        string  // Comparison mode: exact, whitespace, float or checker
+	Tolerance      float64 // Absolute or relative error allowed in float mode
+	Checker        string  // Checker binary for checker mode
+	MaxOutputBytes int64   // Maximum bytes written to stdout per case (0 = unlimited)
+	JSONReportPath string  // Write the per-case results as JSON to this file
+}
+
+// ParseJudgeArgs parses the arguments of "kernelscope judge"
+func ParseJudgeArgs(args []string) *JudgeConfig {
+	config := &JudgeConfig{Run: DefaultConfig()}
+	config.Run.PrePaidMode = false
+	config.Run.SampleInterval = 50 * time.Millisecond
+
+	fs := flag.NewFlagSet("judge", flag.ExitOnError)
+	fs.StringVar(&config.Run.BinaryPath, "binary", "", "Path to the binary to judge (required)")
+	fs.IntVar(&config.Run.CpuLimit, "cpu", config.Run.CpuLimit, "CPU time limit per case in seconds")
+	fs.IntVar(&config.Run.MemoryLimit, "mem", config.Run.MemoryLimit, "Memory limit per case in KB")
+	fs.IntVar(&config.Run.Timeout, "timeout", config.Run.Timeout, "Wall-clock limit per case in seconds")
+	fs.DurationVar(&config.Run.SampleInterval, "sample-interval", config.Run.SampleInterval, "Interval between resource usage samples")
+	fs.StringVar(&config.Run.User, "user", "", "Run the binary as this user (name or uid), dropping all capabilities")
+	fs.StringVar(&config.Run.Group, "group", "", "Run the binary with this primary group (name or gid)")
+	fs.BoolVar(&config.Run.AllowRoot, "allow-root", false, "Allow the binary to run as root")
+	fs.StringVar(&config.TestsDir, "tests", "", "Directory of NAME.in and NAME.out (or NAME.ans) test cases (required)")
+	fs.StringVar(&config.Compare, "compare", "whitespace", "How outputs are compared: exact, whitespace, float or checker")
+	fs.Float64Var(&config.Tolerance, "tolerance", 1e-6, "Absolute or relative error allowed in float mode")
+	fs.StringVar(&config.Checker, "checker", "", "Checker binary run as: checker <input> <expected> <actual>; exit 0 accepts")
+	fs.Int64Var(&config.MaxOutputBytes, "max-output", 64*1024*1024, "Maximum bytes a case may write to stdout (0 = unlimited)")
+	fs.StringVar(&config.JSONReportPath, "json-report", "", "Write the per-case verdicts as JSON to this file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: kernelscope judge --binary <path> --tests <dir> [options] [-- args]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	config.Run.Args = fs.Args()
+
+	if config.Checker != "" && config.Compare == "whitespace" {
+		config.Compare = "checker"
+	}
+
+	if err := config.Validate(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	return config
+}
+
+// Validate checks that the judge configuration is usable
+func (config *JudgeConfig) Validate() error {
+	if err := config.Run.Validate(); err != nil {
+		return err
+	}
+
+	if config.TestsDir == "" {
+		return fmt.Errorf("test case directory is required")
+	}
+
+	switch config.Compare {
+	case "exact", "whitespace", "float":
+	case "checker":
+		if config.Checker == "" {
+			return fmt.Errorf("checker mode requires --checker")
+		}
+	default:
+		return fmt.Errorf("compare must be exact, whitespace, float or checker")
+	}
+
+	if config.Tolerance < 0 {
+		return fmt.Errorf("tolerance must not be negative")
+	}
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// TokenEnv names the environment variable holding the daemon's API token
+const TokenEnv = "KERNELSCOPE_TOKEN"
+
+// ServeConfig holds the parameters of the "serve" daemon
+type ServeConfig struct {
+	Listen      string  // TCP address, or unix:/path/to/socket
+	MaxJobs     int     // Maximum concurrently running jobs (0 = unlimited)
+	MaxMemoryKB int64   // Maximum memory reserved by running jobs' limits in KB (0 = unlimited)
+	MaxCpus     float64 // Maximum CPU cores reserved by running jobs (0 = unlimited)
+	Policy      string  // Queue ordering: fifo or fair-share
+	AllowRoot   bool    // Allow jobs to run as root
+
+	AllowSuccessCommand bool   // Allow jobs to set a success command
+	WorkspaceRoot       string // Directory job workspace templates must be under, empty to refuse them
+	ArtifactsRoot       string // Directory job artifacts directories must be under, empty to refuse them
+	WatchRoot           string // Directory job watch paths must be under, empty to refuse them
+
+	Token         string        // Bearer token required on every request, empty only on a Unix socket
+	AllowedUsers  []string      // Users jobs may run as, as written in the job spec
+	AllowedGroups []string      // Groups jobs may run with, as written in the job spec
+	Retention     time.Duration // How long finished jobs are kept
+	MaxFinished   int           // Maximum number of finished jobs kept (0 = unlimited)
+}
+
+// ParseServeArgs parses the arguments of "kernelscope serve"
+func ParseServeArgs(args []string) *ServeConfig {
+	config := &ServeConfig{}
+
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.StringVar(&config.Listen, "listen", "127.0.0.1:8080", "Address to serve the job API on: host:port or unix:/path/to/socket")
+	fs.IntVar(&config.MaxJobs, "max-jobs", 0, "Maximum number of concurrently running jobs (0 = unlimited)")
+	fs.Int64Var(&config.MaxMemoryKB, "max-memory", 0, "Maximum total memory in KB reserved by running jobs' memory limits (0 = unlimited)")
+	fs.Float64Var(&config.MaxCpus, "max-cpus", float64(runtime.NumCPU()), "Maximum total CPU cores reserved by running jobs (0 = unlimited)")
+	fs.StringVar(&config.Policy, "policy", "fifo", "Queue ordering within a priority: fifo or fair-share")
+	fs.BoolVar(&config.AllowRoot, "allow-root", false, "Allow jobs without a user to run as root")
+	fs.BoolVar(&config.AllowSuccessCommand, "allow-success-command", false, "Allow jobs to set a success command, run confined like the job")
+	fs.StringVar(&config.WorkspaceRoot, "workspace-root", "", "Directory job workspace templates must be under (default: none, templates are refused)")
+	fs.StringVar(&config.ArtifactsRoot, "artifacts-root", "", "Directory a job's artifacts_dir must be under (default: none, artifacts_dir is refused)")
+	fs.StringVar(&config.WatchRoot, "watch-root", "", "Directory a job's watch paths must be under (default: none, watch is refused)")
+	tokenFile := fs.String("token-file", "", "File holding the token clients must send as a bearer token (default: $"+TokenEnv+")")
+	allowedUsers := fs.String("allow-users", "", "Comma-separated users jobs may run as (default: none)")
+	allowedGroups := fs.String("allow-groups", "", "Comma-separated groups jobs may run with (default: none)")
+	fs.DurationVar(&config.Retention, "retain", time.Hour, "How long finished jobs, their logs and metrics are kept")
+	fs.IntVar(&config.MaxFinished, "max-finished", 1000, "Maximum number of finished jobs kept (0 = unlimited)")
+	fs.Parse(args)
+
+	if config.Listen == "" {
+		fmt.Println("Error: listen address is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	token, err := ReadToken(*tokenFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	config.Token = token
+	if config.Token == "" && !strings.HasPrefix(config.Listen, "unix:") {
+		fmt.Printf("Error: a token is required on a TCP listener: pass --token-file or set %s\n", TokenEnv)
+		fs.Usage()
+		os.Exit(1)
+	}
+	config.AllowedUsers = splitList(*allowedUsers)
+	config.AllowedGroups = splitList(*allowedGroups)
+
+	if config.Policy != "fifo" && config.Policy != "fair-share" {
+		fmt.Println("Error: policy must be fifo or fair-share")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	return config
+}
+
+// WatchConfig holds the parameters of "kernelscope watch"
+type WatchConfig struct {
+	Server      string // Daemon address: host:port or unix:/path/to/socket
+	JobID       string // Job to follow
+	ShowSamples bool   // Print live resource samples
+	Token       string // Bearer token sent to the daemon
+}
+
+// ParseWatchArgs parses the arguments of "kernelscope watch <job>"
+func ParseWatchArgs(args []string) *WatchConfig {
+	config := &WatchConfig{}
+
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	fs.StringVar(&config.Server, "server", "127.0.0.1:8080", "Daemon address: host:port or unix:/path/to/socket")
+	fs.BoolVar(&config.ShowSamples, "samples", true, "Print live resource samples")
+	tokenFile := fs.String("token-file", "", "File holding the daemon's token (default: $"+TokenEnv+")")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: kernelscope watch [options] <job-id>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	token, err := ReadToken(*tokenFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	config.Token = token
+
+	if fs.NArg() != 1 {
+		fmt.Println("Error: exactly one job ID is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	config.JobID = fs.Arg(0)
+
+	return config
+}
+
+// BatchConfig holds the parameters of "kernelscope batch"
+type BatchConfig struct {
+	ManifestPath   string // YAML manifest listing the jobs
+	Parallel       int    // Number of jobs run at the same time
+	JSONReportPath string // Write the aggregate report as JSON to this file
+	LogDir         string // Save every job's stdout and stderr in this directory
+	AllowRoot      bool   // Allow jobs to run as root
+}
+
+// ParseBatchArgs parses the arguments of "kernelscope batch <manifest>"
+func ParseBatchArgs(args []string) *BatchConfig {
+	config := &BatchConfig{}
+
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	fs.IntVar(&config.Parallel, "parallel", 1, "Number of jobs to run at the same time")
+	fs.StringVar(&config.JSONReportPath, "json-report", "", "Write the aggregate report, including every job's report, as JSON to this file")
+	fs.StringVar(&config.LogDir, "log-dir", "", "Save every job's stdout and stderr in this directory")
+	fs.BoolVar(&config.AllowRoot, "allow-root", false, "Allow jobs without a user to run as root")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: kernelscope batch [options] <jobs.yaml>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Println("Error: exactly one manifest is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	config.ManifestPath = fs.Arg(0)
+
+	if config.Parallel < 1 {
+		fmt.Println("Error: parallel must be at least 1")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	return config
+}
+
+// ReadToken reads the daemon's API token from a file, or from $KERNELSCOPE_TOKEN without one
+func ReadToken(path string) (string, error) {
+	if path == "" {
+		return os.Getenv(TokenEnv), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
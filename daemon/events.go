@@ -0,0 +1,95 @@
+package daemon
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types streamed to clients
+const (
+	EventStatus = "status" // Job status, sent on connect and on state changes
+	EventSample = "sample" // A resource usage sample
+	EventLog    = "log"    // A chunk of stdout or stderr
+	EventNotice = "event"  // Something happened, e.g. a limit was hit
+	EventEnd    = "end"    // The job finished; carries the final status
+)
+
+// Event is a single item in a job's live stream
+type Event struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// SampleEvent is the payload of a sample event
+type SampleEvent struct {
+	CpuSeconds float64 `json:"cpu_seconds"`
+	MemoryKB   uint64  `json:"memory_kb"`
+	Threads    int     `json:"threads"`
+	FDs        int     `json:"fds"`
+	Processes  int     `json:"processes"`
+	ReadBytes  uint64  `json:"read_bytes"`
+	WriteBytes uint64  `json:"write_bytes"`
+}
+
+// Broadcaster fans events out to any number of subscribers.
+// Slow subscribers lose events rather than blocking the job.
+type Broadcaster struct {
+	mu     sync.Mutex
+	subs   map[chan Event]struct{}
+	closed bool
+}
+
+// NewBroadcaster creates an event broadcaster
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subs: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel receiving future events and a function to unsubscribe.
+// The channel is closed when the broadcaster is closed.
+func (b *Broadcaster) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subs[ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Publish sends an event to every subscriber without blocking
+func (b *Broadcaster) Publish(eventType string, data interface{}) {
+	event := Event{Type: eventType, Time: time.Now(), Data: data}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- event:
+		default:
+			// Subscriber is not keeping up, drop the event
+		}
+	}
+}
+
+// Close closes every subscriber channel; later subscriptions receive a closed channel
+func (b *Broadcaster) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		close(ch)
+	}
+	b.subs = make(map[chan Event]struct{})
+	b.closed = true
+}
@@ -0,0 +1,426 @@
+package daemon
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"kernelscope/cli"
+	"kernelscope/executor"
+	"kernelscope/loopcontrol"
+	"kernelscope/monitor"
+	"kernelscope/reporter"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job states
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+)
+
+// JobSpec describes a job submitted to the daemon
+type JobSpec struct {
+	Binary    string            `json:"binary" yaml:"binary"`
+	Args      []string          `json:"args,omitempty" yaml:"args,omitempty"`
+	Env       map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	Account   string            `json:"account,omitempty" yaml:"account,omitempty"`
+	Priority  int               `json:"priority,omitempty" yaml:"priority,omitempty"` // Higher priorities are scheduled first
+	Cpus      float64           `json:"cpus,omitempty" yaml:"cpus,omitempty"`         // CPU cores reserved from the host budget, default 1
+	Prepaid   *bool             `json:"prepaid,omitempty" yaml:"prepaid,omitempty"`   // Defaults to prepaid mode
+	Credit    float64           `json:"credit,omitempty" yaml:"credit,omitempty"`     // CPU credits in seconds, 0 uses the default
+	Limits    JobLimits         `json:"limits" yaml:"limits"`
+	Success   JobSuccess        `json:"success,omitempty" yaml:"success,omitempty"`
+	Sandbox   *JobSandbox       `json:"sandbox,omitempty" yaml:"sandbox,omitempty"`               // Run in new namespaces when set
+	Seccomp   string            `json:"seccomp,omitempty" yaml:"seccomp,omitempty"`               // Seccomp profile name or JSON file
+	Landlock  *JobLandlock      `json:"landlock,omitempty" yaml:"landlock,omitempty"`             // Restrict filesystem access when set
+	User      string            `json:"user,omitempty" yaml:"user,omitempty"`                     // Run as this user (name or uid)
+	Group     string            `json:"group,omitempty" yaml:"group,omitempty"`                   // Primary group (name or gid)
+	Groups    []string          `json:"groups,omitempty" yaml:"groups,omitempty"`                 // Supplementary groups (names or gids)
+	Workspace *JobWorkspace     `json:"workspace,omitempty" yaml:"workspace,omitempty"`           // Run in a fresh working directory when set
+	Audit     *bool             `json:"audit,omitempty" yaml:"audit,omitempty"`                   // Report file changes in the workspace
+	Watch     []string          `json:"watch,omitempty" yaml:"watch,omitempty"`                   // Further paths audited for changes
+	Network   *bool             `json:"audit_network,omitempty" yaml:"audit_network,omitempty"`   // Report sockets and network traffic
+	Trace     *bool             `json:"trace_syscalls,omitempty" yaml:"trace_syscalls,omitempty"` // Count system calls with ptrace
+}
+
+// JobWorkspace configures the per-run working directory of a job
+type JobWorkspace struct {
+	Template     string   `json:"template,omitempty" yaml:"template,omitempty"`           // Directory copied into the workspace
+	SizeKB       int64    `json:"size_kb,omitempty" yaml:"size_kb,omitempty"`             // tmpfs size, sandboxed jobs only
+	Collect      []string `json:"collect,omitempty" yaml:"collect,omitempty"`             // Globs of files kept after the run
+	ArtifactsDir string   `json:"artifacts_dir,omitempty" yaml:"artifacts_dir,omitempty"` // Where kept files are copied
+}
+
+// JobLandlock lists the paths a job may access when Landlock restricts it
+type JobLandlock struct {
+	Read  []string `json:"read,omitempty" yaml:"read,omitempty"`
+	Write []string `json:"write,omitempty" yaml:"write,omitempty"`
+	Exec  []string `json:"exec,omitempty" yaml:"exec,omitempty"`
+}
+
+// JobSandbox configures the namespace sandbox of a job
+type JobSandbox struct {
+	Network   bool     `json:"network,omitempty" yaml:"network,omitempty"`   // Keep the host network
+	ReadOnly  []string `json:"ro_binds,omitempty" yaml:"ro_binds,omitempty"` // Host paths bound read-only, as path or src:dst
+	ReadWrite []string `json:"binds,omitempty" yaml:"binds,omitempty"`       // Host paths bound read-write, as path or src:dst
+}
+
+// JobSuccess holds the criteria a job must meet to succeed. By default only exit code 0 succeeds.
+type JobSuccess struct {
+	ExitCodes    []int  `json:"exit_codes,omitempty" yaml:"exit_codes,omitempty"`
+	StdoutMatch  string `json:"stdout_match,omitempty" yaml:"stdout_match,omitempty"`
+	StdoutReject string `json:"stdout_reject,omitempty" yaml:"stdout_reject,omitempty"`
+	StderrMatch  string `json:"stderr_match,omitempty" yaml:"stderr_match,omitempty"`
+	StderrReject string `json:"stderr_reject,omitempty" yaml:"stderr_reject,omitempty"`
+	File         string `json:"file,omitempty" yaml:"file,omitempty"`
+	Command      string `json:"command,omitempty" yaml:"command,omitempty"`
+}
+
+// JobLimits holds the resource limits of a job. Zero values use the CLI defaults.
+type JobLimits struct {
+	CpuSeconds     int     `json:"cpu_seconds,omitempty" yaml:"cpu_seconds,omitempty"`
+	MemoryKB       int     `json:"memory_kb,omitempty" yaml:"memory_kb,omitempty"`
+	TimeoutSeconds int     `json:"timeout_seconds,omitempty" yaml:"timeout_seconds,omitempty"`
+	IdleTimeout    int     `json:"idle_timeout_seconds,omitempty" yaml:"idle_timeout_seconds,omitempty"`
+	OutputTimeout  int     `json:"output_timeout_seconds,omitempty" yaml:"output_timeout_seconds,omitempty"`
+	MaxWriteBytes  int64   `json:"max_write_bytes,omitempty" yaml:"max_write_bytes,omitempty"`
+	MaxReadBytes   int64   `json:"max_read_bytes,omitempty" yaml:"max_read_bytes,omitempty"`
+	MaxThreads     int     `json:"max_threads,omitempty" yaml:"max_threads,omitempty"`
+	MaxFDs         int     `json:"max_fds,omitempty" yaml:"max_fds,omitempty"`
+	MaxProcs       int     `json:"max_procs,omitempty" yaml:"max_procs,omitempty"`
+	CpuRate        float64 `json:"cpu_rate,omitempty" yaml:"cpu_rate,omitempty"` // Cores the tree may use at once, 0 for no cap
+}
+
+// Config converts the job spec into an execution configuration
+func (spec *JobSpec) Config() (*cli.Config, error) {
+	config := cli.DefaultConfig()
+	config.BinaryPath = spec.Binary
+	config.Args = spec.Args
+	config.Account = spec.Account
+
+	// Sort the environment so runs are reproducible
+	keys := make([]string, 0, len(spec.Env))
+	for key := range spec.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		config.Env = append(config.Env, key+"="+spec.Env[key])
+	}
+
+	if spec.Prepaid != nil {
+		config.PrePaidMode = *spec.Prepaid
+	}
+	if spec.Credit > 0 {
+		config.CpuCredit = spec.Credit
+	}
+	if spec.Limits.CpuSeconds > 0 {
+		config.CpuLimit = spec.Limits.CpuSeconds
+	}
+	if spec.Limits.MemoryKB > 0 {
+		config.MemoryLimit = spec.Limits.MemoryKB
+	}
+	if spec.Limits.TimeoutSeconds > 0 {
+		config.Timeout = spec.Limits.TimeoutSeconds
+	}
+	config.IdleTimeout = spec.Limits.IdleTimeout
+	config.OutputTimeout = spec.Limits.OutputTimeout
+	config.MaxWriteBytes = spec.Limits.MaxWriteBytes
+	config.MaxReadBytes = spec.Limits.MaxReadBytes
+	config.MaxThreads = spec.Limits.MaxThreads
+	config.MaxFDs = spec.Limits.MaxFDs
+	config.MaxProcs = spec.Limits.MaxProcs
+	config.CpuRate = spec.Limits.CpuRate
+
+	config.SuccessExitCodes = spec.Success.ExitCodes
+	config.StdoutMustMatch = spec.Success.StdoutMatch
+	config.StdoutMustNotMatch = spec.Success.StdoutReject
+	config.StderrMustMatch = spec.Success.StderrMatch
+	config.StderrMustNotMatch = spec.Success.StderrReject
+	config.SuccessFile = spec.Success.File
+	config.SuccessCommand = spec.Success.Command
+
+	if spec.Sandbox != nil {
+		config.Sandbox = true
+		config.SandboxNetwork = spec.Sandbox.Network
+		config.SandboxReadOnly = spec.Sandbox.ReadOnly
+		config.SandboxReadWrite = spec.Sandbox.ReadWrite
+	}
+	config.SeccompProfile = spec.Seccomp
+	config.User = spec.User
+	config.Group = spec.Group
+	config.SupplementaryGroups = spec.Groups
+	if spec.Audit != nil {
+		config.Audit = *spec.Audit
+	}
+	config.Watch = spec.Watch
+	if spec.Network != nil {
+		config.AuditNetwork = *spec.Network
+	}
+	if spec.Trace != nil {
+		config.TraceSyscalls = *spec.Trace
+	}
+	if spec.Workspace != nil {
+		config.Workspace = true
+		config.WorkspaceTemplate = spec.Workspace.Template
+		config.WorkspaceSizeKB = spec.Workspace.SizeKB
+		config.Collect = spec.Workspace.Collect
+		if spec.Workspace.ArtifactsDir != "" {
+			config.ArtifactsDir = spec.Workspace.ArtifactsDir
+		}
+	}
+	if spec.Landlock != nil {
+		config.AllowRead = spec.Landlock.Read
+		config.AllowWrite = spec.Landlock.Write
+		config.AllowExec = spec.Landlock.Exec
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Job is a job accepted by the daemon
+type Job struct {
+	ID      string
+	Spec    JobSpec
+	Config  *cli.Config
+	Monitor *monitor.Monitor
+	Logs    *LogBuffer
+	Events  *Broadcaster
+
+	Priority  int     // Scheduling priority, higher first
+	Cpus      float64 // CPU cores reserved while running
+	submitSeq uint64  // Submission order, set by the scheduler
+
+	mu         sync.Mutex
+	state      string
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+	report     *reporter.JSONReport
+}
+
+// NewJob creates a queued job from a spec
+func NewJob(spec JobSpec) (*Job, error) {
+	config, err := spec.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		ID:        newJobID(),
+		Spec:      spec,
+		Config:    config,
+		Monitor:   monitor.NewMonitor(config),
+		Logs:      NewLogBuffer(defaultLogLimit),
+		Events:    NewBroadcaster(),
+		Priority:  spec.Priority,
+		Cpus:      spec.Cpus,
+		state:     StateQueued,
+		createdAt: time.Now(),
+	}
+	if job.Cpus <= 0 {
+		job.Cpus = 1
+	}
+
+	// Stream output, samples and limit events to subscribers
+	job.Logs.OnAppend = func(chunk LogChunk) {
+		job.Events.Publish(EventLog, chunk)
+	}
+	job.Monitor.OnSample = func(sample monitor.Sample) {
+		job.Events.Publish(EventSample, SampleEvent{
+			CpuSeconds: sample.CpuTime,
+			MemoryKB:   sample.MemoryKB,
+			Threads:    sample.Threads,
+			FDs:        sample.FDs,
+			Processes:  len(sample.Processes),
+			ReadBytes:  sample.IO.ReadBytes,
+			WriteBytes: sample.IO.WriteBytes,
+		})
+	}
+	job.Monitor.OnEvent = func(message string) {
+		job.Events.Publish(EventNotice, map[string]string{"message": message})
+	}
+
+	return job, nil
+}
+
+// Run executes the job through the usual executor, monitor and loop controller
+func (j *Job) Run() {
+	j.mu.Lock()
+	j.state = StateRunning
+	j.startedAt = time.Now()
+	j.mu.Unlock()
+	j.Events.Publish(EventStatus, j.Status())
+
+	fmt.Printf("Job %s: starting %s\n", j.ID, j.Config.BinaryPath)
+
+	exec := executor.NewExecutor(j.Config)
+	exec.Stdin = nil
+	exec.Stdout = j.Logs.Writer("stdout")
+	exec.Stderr = j.Logs.Writer("stderr")
+
+	loopCtrl := loopcontrol.NewLoopController(j.Config, exec, j.Monitor)
+	loopCtrl.StartLoop()
+
+	report := reporter.BuildJSONReport(j.Config, loopCtrl.Stats)
+
+	j.mu.Lock()
+	j.finishedAt = time.Now()
+	j.report = report
+	if loopCtrl.Stats.SuccessCount > 0 {
+		j.state = StateSucceeded
+	} else {
+		j.state = StateFailed
+	}
+	j.mu.Unlock()
+
+	fmt.Printf("Job %s: %s\n", j.ID, j.State())
+	j.Events.Publish(EventEnd, j.Status())
+	j.Events.Close()
+}
+
+// Finished reports whether the job has completed
+func (j *Job) Finished() bool {
+	state := j.State()
+	return state == StateSucceeded || state == StateFailed
+}
+
+// finishedTime returns when the job finished, or the zero time while it has not
+func (j *Job) finishedTime() time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.finishedAt
+}
+
+// State returns the current job state
+func (j *Job) State() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state
+}
+
+// Report returns the final report, or nil while the job has not finished
+func (j *Job) Report() *reporter.JSONReport {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.report
+}
+
+// JobStatus is the JSON form of a job's status
+type JobStatus struct {
+	ID         string     `json:"id"`
+	State      string     `json:"state"`
+	Binary     string     `json:"binary"`
+	Args       []string   `json:"args,omitempty"`
+	Account    string     `json:"account,omitempty"`
+	Priority   int        `json:"priority"`
+	Cpus       float64    `json:"cpus"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ExitCode   *int       `json:"exit_code,omitempty"`
+	TermReason string     `json:"termination_reason,omitempty"`
+	Success    string     `json:"success_reason,omitempty"` // Why the job counted as a success or a failure
+}
+
+// Status returns the job status
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := JobStatus{
+		ID:        j.ID,
+		State:     j.state,
+		Binary:    j.Config.BinaryPath,
+		Args:      j.Config.Args,
+		Account:   j.Config.Account,
+		Priority:  j.Priority,
+		Cpus:      j.Cpus,
+		CreatedAt: j.createdAt,
+	}
+	if !j.startedAt.IsZero() {
+		startedAt := j.startedAt
+		status.StartedAt = &startedAt
+	}
+	if !j.finishedAt.IsZero() {
+		finishedAt := j.finishedAt
+		status.FinishedAt = &finishedAt
+	}
+	if j.report != nil {
+		exitCode := j.report.ExitCode
+		status.ExitCode = &exitCode
+		status.TermReason = j.report.TermReason
+		status.Success = j.report.SuccessReason
+	}
+	return status
+}
+
+// LiveStats is the JSON form of a job's live resource usage
+type LiveStats struct {
+	Running         bool           `json:"running"`
+	ElapsedSeconds  float64        `json:"elapsed_seconds"`
+	CpuSeconds      float64        `json:"cpu_seconds"`
+	MemoryKB        uint64         `json:"memory_kb"`
+	PeakMemoryKB    uint64         `json:"peak_memory_kb"`
+	Threads         int            `json:"threads"`
+	FDs             int            `json:"fds"`
+	Processes       int            `json:"processes"`
+	ReadBytes       uint64         `json:"read_bytes"`
+	WriteBytes      uint64         `json:"write_bytes"`
+	Samples         int            `json:"samples"`
+	LimitHits       map[string]int `json:"limit_hits,omitempty"`
+	TermReason      string         `json:"termination_reason,omitempty"`
+	CreditRemaining *float64       `json:"cpu_credit_remaining,omitempty"`
+}
+
+// LiveStats returns the job's current resource usage
+func (j *Job) LiveStats() LiveStats {
+	stats := j.Monitor.Snapshot()
+
+	live := LiveStats{
+		Running:      stats.Running,
+		CpuSeconds:   stats.CpuTimeUsed,
+		PeakMemoryKB: stats.MaxMemoryKB,
+		ReadBytes:    stats.IO.ReadBytes,
+		WriteBytes:   stats.IO.WriteBytes,
+		Samples:      stats.SampleCount,
+		LimitHits:    stats.LimitHits,
+		TermReason:   stats.TermReason,
+	}
+	if !stats.StartTime.IsZero() {
+		end := stats.EndTime
+		if stats.Running || end.IsZero() {
+			end = time.Now()
+		}
+		live.ElapsedSeconds = end.Sub(stats.StartTime).Seconds()
+	}
+	if len(stats.Samples) > 0 {
+		last := stats.Samples[len(stats.Samples)-1]
+		live.MemoryKB = last.MemoryKB
+		live.Threads = last.Threads
+		live.FDs = last.FDs
+		live.Processes = len(last.Processes)
+	}
+	if j.Config.PrePaidMode {
+		remaining := j.Config.CpuCredit - stats.TotalCpuTime
+		if remaining < 0 {
+			remaining = 0
+		}
+		live.CreditRemaining = &remaining
+	}
+	return live
+}
+
+// newJobID returns a random job identifier
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,173 @@
+package daemon
+
+import (
+	"kernelscope/cli"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJobSpecConfig(t *testing.T) {
+	no := false
+	yes := true
+
+	tests := []struct {
+		name    string
+		spec    JobSpec
+		check   func(t *testing.T, config *cli.Config)
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			spec: JobSpec{Binary: "/bin/true"},
+			check: func(t *testing.T, config *cli.Config) {
+				defaults := cli.DefaultConfig()
+				if config.CpuLimit != defaults.CpuLimit || config.MemoryLimit != defaults.MemoryLimit || config.Timeout != defaults.Timeout {
+					t.Errorf("limits = %d s, %d KB, %d s; want the CLI defaults", config.CpuLimit, config.MemoryLimit, config.Timeout)
+				}
+				if !config.PrePaidMode || config.CpuCredit != defaults.CpuCredit {
+					t.Errorf("prepaid = %v with %.1f s of credit, want prepaid with the default credit", config.PrePaidMode, config.CpuCredit)
+				}
+				if config.Sandbox || config.Workspace || config.Audit || config.TraceSyscalls {
+					t.Errorf("optional features enabled without being asked for")
+				}
+			},
+		},
+		{
+			name: "arguments and sorted environment",
+			spec: JobSpec{Binary: "/usr/bin/make", Args: []string{"-j4"}, Env: map[string]string{"PATH": "/bin", "CC": "clang"}, Account: "team-a"},
+			check: func(t *testing.T, config *cli.Config) {
+				if !reflect.DeepEqual(config.Args, []string{"-j4"}) {
+					t.Errorf("args = %q", config.Args)
+				}
+				if !reflect.DeepEqual(config.Env, []string{"CC=clang", "PATH=/bin"}) {
+					t.Errorf("env = %q, want it sorted by name", config.Env)
+				}
+				if config.Account != "team-a" {
+					t.Errorf("account = %q", config.Account)
+				}
+			},
+		},
+		{
+			name: "limits and postpaid billing",
+			spec: JobSpec{
+				Binary:  "/bin/true",
+				Prepaid: &no,
+				Credit:  12.5,
+				Limits: JobLimits{
+					CpuSeconds: 60, MemoryKB: 2048, TimeoutSeconds: 600, IdleTimeout: 30, OutputTimeout: 40,
+					MaxWriteBytes: 1 << 20, MaxReadBytes: 2 << 20, MaxThreads: 64, MaxFDs: 128, MaxProcs: 8, CpuRate: 0.5,
+				},
+			},
+			check: func(t *testing.T, config *cli.Config) {
+				if config.PrePaidMode || config.CpuCredit != 12.5 {
+					t.Errorf("prepaid = %v with %.1f s of credit, want postpaid with 12.5 s", config.PrePaidMode, config.CpuCredit)
+				}
+				got := []int{config.CpuLimit, config.MemoryLimit, config.Timeout, config.IdleTimeout, config.OutputTimeout, config.MaxThreads, config.MaxFDs, config.MaxProcs}
+				if want := []int{60, 2048, 600, 30, 40, 64, 128, 8}; !reflect.DeepEqual(got, want) {
+					t.Errorf("limits = %v, want %v", got, want)
+				}
+				if config.MaxWriteBytes != 1<<20 || config.MaxReadBytes != 2<<20 || config.CpuRate != 0.5 {
+					t.Errorf("I/O limits = %d/%d, CPU rate = %.1f", config.MaxWriteBytes, config.MaxReadBytes, config.CpuRate)
+				}
+			},
+		},
+		{
+			name: "success criteria",
+			spec: JobSpec{Binary: "/bin/true", Success: JobSuccess{
+				ExitCodes: []int{0, 3}, StdoutMatch: "^ok", StdoutReject: "FAIL", StderrMatch: "done", StderrReject: "panic",
+				File: "out.txt", Command: "test -s out.txt",
+			}},
+			check: func(t *testing.T, config *cli.Config) {
+				if !reflect.DeepEqual(config.SuccessExitCodes, []int{0, 3}) {
+					t.Errorf("exit codes = %v", config.SuccessExitCodes)
+				}
+				got := []string{config.StdoutMustMatch, config.StdoutMustNotMatch, config.StderrMustMatch, config.StderrMustNotMatch, config.SuccessFile, config.SuccessCommand}
+				if want := []string{"^ok", "FAIL", "done", "panic", "out.txt", "test -s out.txt"}; !reflect.DeepEqual(got, want) {
+					t.Errorf("criteria = %q, want %q", got, want)
+				}
+			},
+		},
+		{
+			name: "sandbox, workspace and audits",
+			spec: JobSpec{
+				Binary:    "/bin/true",
+				Sandbox:   &JobSandbox{Network: true, ReadOnly: []string{"/usr"}, ReadWrite: []string{"/data:/mnt"}},
+				Workspace: &JobWorkspace{SizeKB: 1024, Collect: []string{"*.log"}},
+				Landlock:  &JobLandlock{Read: []string{"/etc"}},
+				Audit:     &yes,
+				Network:   &yes,
+				Trace:     &yes,
+				Watch:     []string{"/tmp"},
+			},
+			check: func(t *testing.T, config *cli.Config) {
+				if !config.Sandbox || !config.SandboxNetwork || !reflect.DeepEqual(config.SandboxReadOnly, []string{"/usr"}) ||
+					!reflect.DeepEqual(config.SandboxReadWrite, []string{"/data:/mnt"}) {
+					t.Errorf("sandbox = %v, network %v, binds %q and %q", config.Sandbox, config.SandboxNetwork, config.SandboxReadOnly, config.SandboxReadWrite)
+				}
+				if !config.Workspace || config.WorkspaceSizeKB != 1024 || !reflect.DeepEqual(config.Collect, []string{"*.log"}) {
+					t.Errorf("workspace = %v of %d KB collecting %q", config.Workspace, config.WorkspaceSizeKB, config.Collect)
+				}
+				if config.ArtifactsDir != cli.DefaultConfig().ArtifactsDir {
+					t.Errorf("artifacts dir = %q, want the default", config.ArtifactsDir)
+				}
+				if !reflect.DeepEqual(config.AllowRead, []string{"/etc"}) {
+					t.Errorf("landlock read paths = %q", config.AllowRead)
+				}
+				if !config.Audit || !config.AuditNetwork || !config.TraceSyscalls || !reflect.DeepEqual(config.Watch, []string{"/tmp"}) {
+					t.Errorf("audit = %v, network = %v, trace = %v, watch = %q", config.Audit, config.AuditNetwork, config.TraceSyscalls, config.Watch)
+				}
+			},
+		},
+		{
+			name: "identity",
+			spec: JobSpec{Binary: "/bin/true", User: "nobody", Group: "nogroup", Groups: []string{"audio"}},
+			check: func(t *testing.T, config *cli.Config) {
+				if config.User != "nobody" || config.Group != "nogroup" || !reflect.DeepEqual(config.SupplementaryGroups, []string{"audio"}) {
+					t.Errorf("identity = %q:%q %q", config.User, config.Group, config.SupplementaryGroups)
+				}
+			},
+		},
+		{
+			name:    "missing binary",
+			spec:    JobSpec{},
+			wantErr: "binary path is required",
+		},
+		{
+			name:    "invalid output pattern",
+			spec:    JobSpec{Binary: "/bin/true", Success: JobSuccess{StdoutMatch: "("}},
+			wantErr: "invalid output pattern",
+		},
+		{
+			name:    "workspace size outside the sandbox",
+			spec:    JobSpec{Binary: "/bin/true", Workspace: &JobWorkspace{SizeKB: 1024}},
+			wantErr: "--workspace-size requires --sandbox",
+		},
+		{
+			name:    "supplementary groups in the sandbox",
+			spec:    JobSpec{Binary: "/bin/true", Sandbox: &JobSandbox{}, Groups: []string{"audio"}},
+			wantErr: "--supplementary-groups cannot be used with --sandbox",
+		},
+		{
+			name:    "negative CPU rate",
+			spec:    JobSpec{Binary: "/bin/true", Limits: JobLimits{CpuRate: -1}},
+			wantErr: "--cpu-rate must not be negative",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := test.spec.Config()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			test.check(t, config)
+		})
+	}
+}
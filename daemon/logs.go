@@ -0,0 +1,95 @@
+package daemon
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// defaultLogLimit is how many bytes of output are kept per job
+const defaultLogLimit = 4 * 1024 * 1024
+
+// LogChunk is a piece of output written by a job
+type LogChunk struct {
+	Seq    uint64    `json:"seq"` // Position of the chunk in the job's output
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"` // "stdout" or "stderr"
+	Data   string    `json:"data"`
+}
+
+// LogBuffer keeps the most recent output of a job, oldest chunks are dropped first
+type LogBuffer struct {
+	mu      sync.Mutex
+	chunks  []LogChunk
+	size    int
+	limit   int
+	dropped int // Bytes dropped because the limit was reached
+	nextSeq uint64
+
+	// OnAppend is called with every new chunk, e.g. to stream it to clients
+	OnAppend func(chunk LogChunk)
+}
+
+// NewLogBuffer creates a log buffer holding at most limit bytes
+func NewLogBuffer(limit int) *LogBuffer {
+	return &LogBuffer{limit: limit}
+}
+
+// Writer returns a writer that appends to the buffer under the given stream name
+func (lb *LogBuffer) Writer(stream string) io.Writer {
+	return &streamWriter{buffer: lb, stream: stream}
+}
+
+// Chunks returns the buffered chunks, optionally filtered by stream
+func (lb *LogBuffer) Chunks(stream string) []LogChunk {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	var result []LogChunk
+	for _, chunk := range lb.chunks {
+		if stream == "" || chunk.Stream == stream {
+			result = append(result, chunk)
+		}
+	}
+	return result
+}
+
+// Dropped returns the number of bytes discarded to stay within the limit
+func (lb *LogBuffer) Dropped() int {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.dropped
+}
+
+// append adds a chunk and trims the oldest output beyond the limit
+func (lb *LogBuffer) append(chunk LogChunk) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	lb.nextSeq++
+	chunk.Seq = lb.nextSeq
+	if lb.OnAppend != nil {
+		lb.OnAppend(chunk)
+	}
+
+	lb.chunks = append(lb.chunks, chunk)
+	lb.size += len(chunk.Data)
+
+	for lb.size > lb.limit && len(lb.chunks) > 1 {
+		lb.size -= len(lb.chunks[0].Data)
+		lb.dropped += len(lb.chunks[0].Data)
+		lb.chunks = lb.chunks[1:]
+	}
+}
+
+// streamWriter writes into a LogBuffer under a fixed stream name
+type streamWriter struct {
+	buffer *LogBuffer
+	stream string
+}
+
+// Write implements io.Writer
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.buffer.append(LogChunk{Time: time.Now(), Stream: w.stream, Data: string(p)})
+	return len(p), nil
+}
@@ -0,0 +1,178 @@
+package daemon
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Scheduling policies
+const (
+	PolicyFIFO      = "fifo"       // Highest priority first, then submission order
+	PolicyFairShare = "fair-share" // Highest priority first, then the account that has used the least
+)
+
+// Budget holds the host-wide limits jobs are scheduled against. Zero means unlimited.
+type Budget struct {
+	MaxJobs     int     // Maximum concurrently running jobs
+	MaxMemoryKB int64   // Maximum total memory reserved by running jobs' memory limits
+	MaxCpus     float64 // Maximum total CPU cores reserved by running jobs
+}
+
+// Scheduler starts queued jobs when they fit in the budget
+type Scheduler struct {
+	Budget Budget
+	Policy string
+
+	mu           sync.Mutex
+	queue        []*Job
+	running      map[*Job]bool
+	usedMemoryKB int64
+	usedCpus     float64
+	accountUsage map[string]float64 // CPU seconds consumed by finished jobs, per account
+	accountJobs  map[string]int     // Running jobs per account
+	submitted    uint64
+}
+
+// NewScheduler creates a scheduler with the given budget and policy
+func NewScheduler(budget Budget, policy string) *Scheduler {
+	return &Scheduler{
+		Budget:       budget,
+		Policy:       policy,
+		running:      make(map[*Job]bool),
+		accountUsage: make(map[string]float64),
+		accountJobs:  make(map[string]int),
+	}
+}
+
+// Enqueue adds a job to the queue and starts whatever now fits.
+// Jobs that could never fit in the budget are rejected.
+func (s *Scheduler) Enqueue(job *Job) error {
+	if s.Budget.MaxMemoryKB > 0 && int64(job.Config.MemoryLimit) > s.Budget.MaxMemoryKB {
+		return fmt.Errorf("job memory limit %d KB exceeds the host budget of %d KB", job.Config.MemoryLimit, s.Budget.MaxMemoryKB)
+	}
+	if s.Budget.MaxCpus > 0 && job.Cpus > s.Budget.MaxCpus {
+		return fmt.Errorf("job requests %.2f CPUs but the host budget is %.2f", job.Cpus, s.Budget.MaxCpus)
+	}
+
+	s.mu.Lock()
+	s.submitted++
+	job.submitSeq = s.submitted
+	s.queue = append(s.queue, job)
+	s.mu.Unlock()
+
+	s.schedule()
+	return nil
+}
+
+// schedule starts queued jobs in policy order for as long as the next one fits.
+// The head of the queue is never skipped, so large jobs cannot be starved by small ones.
+func (s *Scheduler) schedule() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.queue) > 0 {
+		s.sortQueue()
+		next := s.queue[0]
+		if !s.fits(next) {
+			return
+		}
+
+		s.queue = s.queue[1:]
+		s.running[next] = true
+		s.usedMemoryKB += int64(next.Config.MemoryLimit)
+		s.usedCpus += next.Cpus
+		s.accountJobs[next.Config.Account]++
+
+		go func(job *Job) {
+			job.Run()
+			s.finished(job)
+		}(next)
+	}
+}
+
+// finished releases a job's reservation and starts whatever now fits
+func (s *Scheduler) finished(job *Job) {
+	stats := job.Monitor.Snapshot()
+
+	s.mu.Lock()
+	delete(s.running, job)
+	s.usedMemoryKB -= int64(job.Config.MemoryLimit)
+	s.usedCpus -= job.Cpus
+	s.accountJobs[job.Config.Account]--
+	s.accountUsage[job.Config.Account] += stats.TotalCpuTime
+	s.mu.Unlock()
+
+	s.schedule()
+}
+
+// fits reports whether a job fits in the remaining budget. The caller must hold s.mu.
+func (s *Scheduler) fits(job *Job) bool {
+	if s.Budget.MaxJobs > 0 && len(s.running) >= s.Budget.MaxJobs {
+		return false
+	}
+	if s.Budget.MaxMemoryKB > 0 && s.usedMemoryKB+int64(job.Config.MemoryLimit) > s.Budget.MaxMemoryKB {
+		return false
+	}
+	if s.Budget.MaxCpus > 0 && s.usedCpus+job.Cpus > s.Budget.MaxCpus+1e-9 {
+		return false
+	}
+	return true
+}
+
+// sortQueue orders the queue according to the policy. The caller must hold s.mu.
+func (s *Scheduler) sortQueue() {
+	sort.SliceStable(s.queue, func(i, j int) bool {
+		a, b := s.queue[i], s.queue[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if s.Policy == PolicyFairShare && a.Config.Account != b.Config.Account {
+			// Accounts with fewer running jobs, then less CPU consumed, go first
+			runA, runB := s.accountJobs[a.Config.Account], s.accountJobs[b.Config.Account]
+			if runA != runB {
+				return runA < runB
+			}
+			useA, useB := s.accountUsage[a.Config.Account], s.accountUsage[b.Config.Account]
+			if useA != useB {
+				return useA < useB
+			}
+		}
+		return a.submitSeq < b.submitSeq
+	})
+}
+
+// QueueStatus describes the scheduler state
+type QueueStatus struct {
+	Policy       string      `json:"policy"`
+	Queued       []JobStatus `json:"queued"` // In the order they will be started
+	Running      int         `json:"running"`
+	MaxJobs      int         `json:"max_jobs,omitempty"`
+	UsedMemoryKB int64       `json:"used_memory_kb"`
+	MaxMemoryKB  int64       `json:"max_memory_kb,omitempty"`
+	UsedCpus     float64     `json:"used_cpus"`
+	MaxCpus      float64     `json:"max_cpus,omitempty"`
+}
+
+// Status returns the queue in scheduling order together with the budget usage
+func (s *Scheduler) Status() QueueStatus {
+	s.mu.Lock()
+	s.sortQueue()
+	queued := append([]*Job(nil), s.queue...)
+	status := QueueStatus{
+		Policy:       s.Policy,
+		Running:      len(s.running),
+		MaxJobs:      s.Budget.MaxJobs,
+		UsedMemoryKB: s.usedMemoryKB,
+		MaxMemoryKB:  s.Budget.MaxMemoryKB,
+		UsedCpus:     s.usedCpus,
+		MaxCpus:      s.Budget.MaxCpus,
+	}
+	s.mu.Unlock()
+
+	status.Queued = make([]JobStatus, 0, len(queued))
+	for _, job := range queued {
+		status.Queued = append(status.Queued, job.Status())
+	}
+	return status
+}
@@ -0,0 +1,430 @@
+package daemon
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"kernelscope/cli"
+	"kernelscope/metrics"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxSpecBytes bounds the size of a submitted job spec
+const maxSpecBytes = 1 << 20
+
+// Server is the long-lived job supervisor behind "kernelscope serve"
+type Server struct {
+	Config    *cli.ServeConfig
+	Metrics   *metrics.Exporter
+	Scheduler *Scheduler
+	mu        sync.Mutex
+	jobs      map[string]*Job
+	order     []string // Job IDs in submission order
+	listener  net.Listener
+}
+
+// NewServer creates a daemon server
+func NewServer(config *cli.ServeConfig) *Server {
+	budget := Budget{
+		MaxJobs:     config.MaxJobs,
+		MaxMemoryKB: config.MaxMemoryKB,
+		MaxCpus:     config.MaxCpus,
+	}
+	return &Server{
+		Config:    config,
+		Metrics:   metrics.NewExporter(""),
+		Scheduler: NewScheduler(budget, config.Policy),
+		jobs:      make(map[string]*Job),
+	}
+}
+
+// Handler returns the HTTP handler of the job API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.handleSubmit)
+	mux.HandleFunc("GET /jobs", s.handleList)
+	mux.HandleFunc("GET /queue", s.handleQueue)
+	mux.HandleFunc("GET /jobs/{id}", s.handleStatus)
+	mux.HandleFunc("GET /jobs/{id}/stats", s.handleStats)
+	mux.HandleFunc("GET /jobs/{id}/logs", s.handleLogs)
+	mux.HandleFunc("GET /jobs/{id}/report", s.handleReport)
+	mux.HandleFunc("GET /jobs/{id}/events", s.handleEvents)
+	mux.HandleFunc("GET /jobs/{id}/ws", s.handleWebSocket)
+	mux.Handle("GET /metrics", s.Metrics.Handler())
+	return s.authenticate(mux)
+}
+
+// authenticate requires the configured token as a bearer token on every request
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	if s.Config.Token == "" {
+		return next
+	}
+	expected := []byte("Bearer " + s.Config.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kernelscope"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ListenAndServe serves the job API until the process receives SIGINT or SIGTERM
+func (s *Server) ListenAndServe() error {
+	network, address := "tcp", s.Config.Listen
+	if strings.HasPrefix(address, "unix:") {
+		network, address = "unix", strings.TrimPrefix(address, "unix:")
+		// Remove a stale socket left by a previous run
+		os.Remove(address)
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", s.Config.Listen, err)
+	}
+	s.listener = listener
+	if network == "unix" {
+		// Only the daemon's user may connect without a token
+		if err := os.Chmod(address, 0600); err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to restrict %s: %v", address, err)
+		}
+	}
+
+	server := &http.Server{Handler: s.Handler()}
+
+	// Drop finished jobs once they are past retention
+	stopEviction := make(chan struct{})
+	defer close(stopEviction)
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.evict(time.Now())
+			case <-stopEviction:
+				return
+			}
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		fmt.Printf("Received %v, shutting down\n", sig)
+		server.Close()
+	}()
+
+	fmt.Printf("KernelScope daemon listening on %s\n", s.Config.Listen)
+	err = server.Serve(listener)
+	if network == "unix" {
+		os.Remove(address)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Submit accepts a job and queues it until it fits in the host budget
+func (s *Server) Submit(spec JobSpec) (*Job, error) {
+	if err := s.checkIdentity(spec); err != nil {
+		return nil, err
+	}
+	if err := s.checkOptIns(spec); err != nil {
+		return nil, err
+	}
+
+	job, err := NewJob(spec)
+	if err != nil {
+		return nil, err
+	}
+	job.Config.AllowRoot = s.Config.AllowRoot
+
+	// Register the job first, as the scheduler may start it right away
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	s.order = append(s.order, job.ID)
+	s.mu.Unlock()
+
+	labels := map[string]string{"job_id": job.ID, "binary": job.Config.BinaryPath}
+	if job.Config.Account != "" {
+		labels["account"] = job.Config.Account
+	}
+	s.Metrics.Register(labels, job.Config, job.Monitor)
+
+	if err := s.Scheduler.Enqueue(job); err != nil {
+		s.forget(job)
+		return nil, err
+	}
+
+	s.evict(time.Now())
+	return job, nil
+}
+
+// forget removes a job that was never queued, along with its metrics
+func (s *Server) forget(job *Job) {
+	s.mu.Lock()
+	delete(s.jobs, job.ID)
+	s.order = slices.DeleteFunc(s.order, func(id string) bool { return id == job.ID })
+	s.mu.Unlock()
+
+	s.Metrics.Unregister(job.Monitor)
+}
+
+// checkIdentity rejects jobs asking for a user or groups the operator has not allowed
+func (s *Server) checkIdentity(spec JobSpec) error {
+	if spec.User != "" && !slices.Contains(s.Config.AllowedUsers, spec.User) {
+		return fmt.Errorf("user %s is not allowed: the daemon must be started with --allow-users", spec.User)
+	}
+	groups := spec.Groups
+	if spec.Group != "" {
+		groups = append([]string{spec.Group}, groups...)
+	}
+	for _, group := range groups {
+		if !slices.Contains(s.Config.AllowedGroups, group) {
+			return fmt.Errorf("group %s is not allowed: the daemon must be started with --allow-groups", group)
+		}
+	}
+	return nil
+}
+
+// checkOptIns rejects job fields the operator has to enable first
+func (s *Server) checkOptIns(spec JobSpec) error {
+	if spec.Success.Command != "" && !s.Config.AllowSuccessCommand {
+		return fmt.Errorf("success.command is not allowed: the daemon must be started with --allow-success-command")
+	}
+	if spec.Workspace != nil {
+		if err := checkUnder("workspace.template", spec.Workspace.Template, s.Config.WorkspaceRoot, "--workspace-root"); err != nil {
+			return err
+		}
+		if err := checkUnder("workspace.artifacts_dir", spec.Workspace.ArtifactsDir, s.Config.ArtifactsRoot, "--artifacts-root"); err != nil {
+			return err
+		}
+	}
+	for _, path := range spec.Watch {
+		if err := checkUnder("watch", path, s.Config.WatchRoot, "--watch-root"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkUnder rejects a host path from a job spec unless it lies beneath the
+// operator's root once symlinks are followed
+func checkUnder(field, path, root, flag string) error {
+	if path == "" {
+		return nil
+	}
+	if root == "" {
+		return fmt.Errorf("%s is not allowed: the daemon must be started with %s", field, flag)
+	}
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("%s must be an absolute path", field)
+	}
+	rootPath, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(resolvePath(rootPath), resolvePath(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s %s is outside %s", field, path, root)
+	}
+	return nil
+}
+
+// resolvePath follows the symlinks in the part of path that exists
+func resolvePath(path string) string {
+	rest := ""
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, rest)
+		}
+		if filepath.Dir(dir) == dir {
+			return path
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+	}
+}
+
+// evict forgets finished jobs past the retention period, then the oldest finished
+// jobs beyond the maximum, together with their metrics
+func (s *Server) evict(now time.Time) {
+	s.mu.Lock()
+	var finished []*Job
+	for _, id := range s.order {
+		job := s.jobs[id]
+		if !job.finishedTime().IsZero() {
+			finished = append(finished, job)
+		}
+	}
+	slices.SortStableFunc(finished, func(a, b *Job) int {
+		return a.finishedTime().Compare(b.finishedTime())
+	})
+
+	evicted := make(map[string]bool)
+	for i, job := range finished {
+		expired := s.Config.Retention > 0 && now.Sub(job.finishedTime()) > s.Config.Retention
+		excess := s.Config.MaxFinished > 0 && len(finished)-i > s.Config.MaxFinished
+		if expired || excess {
+			evicted[job.ID] = true
+			delete(s.jobs, job.ID)
+		}
+	}
+	if len(evicted) > 0 {
+		s.order = slices.DeleteFunc(s.order, func(id string) bool { return evicted[id] })
+	}
+	s.mu.Unlock()
+
+	for _, job := range finished {
+		if evicted[job.ID] {
+			s.Metrics.Unregister(job.Monitor)
+		}
+	}
+}
+
+// Job looks up a job by ID
+func (s *Server) Job(id string) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id]
+}
+
+// handleSubmit handles POST /jobs
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var spec JobSpec
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSpecBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spec); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid job spec: %v", err))
+		return
+	}
+
+	job, err := s.Submit(spec)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusCreated, job.Status())
+}
+
+// handleList handles GET /jobs
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]*Job, 0, len(s.order))
+	for _, id := range s.order {
+		jobs = append(jobs, s.jobs[id])
+	}
+	s.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(jobs))
+	for _, job := range jobs {
+		status := job.Status()
+		if state := r.URL.Query().Get("state"); state != "" && status.State != state {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// handleQueue handles GET /queue
+func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Scheduler.Status())
+}
+
+// handleStatus handles GET /jobs/{id}
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if job := s.lookup(w, r); job != nil {
+		writeJSON(w, http.StatusOK, job.Status())
+	}
+}
+
+// handleStats handles GET /jobs/{id}/stats
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if job := s.lookup(w, r); job != nil {
+		writeJSON(w, http.StatusOK, job.LiveStats())
+	}
+}
+
+// handleLogs handles GET /jobs/{id}/logs. The output is plain text unless format=json is given.
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	job := s.lookup(w, r)
+	if job == nil {
+		return
+	}
+
+	stream := r.URL.Query().Get("stream")
+	if stream != "" && stream != "stdout" && stream != "stderr" {
+		writeError(w, http.StatusBadRequest, "stream must be stdout or stderr")
+		return
+	}
+
+	chunks := job.Logs.Chunks(stream)
+	if r.URL.Query().Get("format") == "json" {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"chunks":        chunks,
+			"dropped_bytes": job.Logs.Dropped(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, chunk := range chunks {
+		fmt.Fprint(w, chunk.Data)
+	}
+}
+
+// handleReport handles GET /jobs/{id}/report
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	job := s.lookup(w, r)
+	if job == nil {
+		return
+	}
+
+	report := job.Report()
+	if report == nil {
+		writeError(w, http.StatusConflict, fmt.Sprintf("job %s has not finished", job.ID))
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// lookup finds the job named in the request path, writing a 404 if it does not exist
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) *Job {
+	id := r.PathValue("id")
+	job := s.Job(id)
+	if job == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("job %s not found", id))
+	}
+	return job
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,219 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"kernelscope/cli"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		want          int
+	}{
+		{name: "no token configured", want: http.StatusOK},
+		{name: "missing token", token: "secret", want: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", authorization: "Bearer guess", want: http.StatusUnauthorized},
+		{name: "token without scheme", token: "secret", authorization: "secret", want: http.StatusUnauthorized},
+		{name: "valid token", token: "secret", authorization: "Bearer secret", want: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := NewServer(&cli.ServeConfig{Policy: PolicyFIFO, Token: test.token})
+			req := httptest.NewRequest(http.MethodGet, "/queue", nil)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			rec := httptest.NewRecorder()
+			server.Handler().ServeHTTP(rec, req)
+			if rec.Code != test.want {
+				t.Errorf("status = %d, want %d", rec.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestSubmitIdentity(t *testing.T) {
+	tests := []struct {
+		name    string
+		users   []string
+		groups  []string
+		spec    string
+		wantErr string
+	}{
+		{name: "user not allowed", spec: `{"binary": "/bin/true", "user": "root"}`, wantErr: "user root is not allowed"},
+		{name: "other user allowed", users: []string{"nobody"}, spec: `{"binary": "/bin/true", "user": "root"}`, wantErr: "user root is not allowed"},
+		{name: "group not allowed", users: []string{"nobody"}, spec: `{"binary": "/bin/true", "user": "nobody", "group": "wheel"}`, wantErr: "group wheel is not allowed"},
+		{name: "supplementary group not allowed", groups: []string{"nogroup"}, spec: `{"binary": "/bin/true", "group": "nogroup", "groups": ["disk"]}`, wantErr: "group disk is not allowed"},
+		{name: "missing binary", users: []string{"nobody"}, spec: `{"user": "nobody"}`, wantErr: "binary path is required"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := NewServer(&cli.ServeConfig{Policy: PolicyFIFO, AllowedUsers: test.users, AllowedGroups: test.groups})
+			req := httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(test.spec))
+			rec := httptest.NewRecorder()
+			server.Handler().ServeHTTP(rec, req)
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), test.wantErr) {
+				t.Errorf("response = %d %s, want 400 with %q", rec.Code, rec.Body.String(), test.wantErr)
+			}
+			if len(server.jobs) != 0 {
+				t.Errorf("rejected job was kept")
+			}
+		})
+	}
+}
+
+func TestSubmitOptIns(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  cli.ServeConfig
+		spec    string
+		wantErr string // Empty when the job is accepted
+	}{
+		{name: "success command not allowed", spec: `{"binary": "/bin/true", "success": {"command": "true"}}`, wantErr: "success.command is not allowed"},
+		{name: "success command allowed", config: cli.ServeConfig{AllowSuccessCommand: true}, spec: `{"binary": "/bin/true", "success": {"command": "true"}}`},
+		{name: "other criteria", spec: `{"binary": "/bin/true", "success": {"exit_codes": [0, 1]}}`},
+		{name: "template without a root", spec: `{"binary": "/bin/true", "workspace": {"template": "/etc"}}`, wantErr: "workspace.template is not allowed"},
+		{name: "template outside the root", config: cli.ServeConfig{WorkspaceRoot: "/srv/templates"}, spec: `{"binary": "/bin/true", "workspace": {"template": "/etc"}}`, wantErr: "workspace.template /etc is outside /srv/templates"},
+		{name: "template escaping the root", config: cli.ServeConfig{WorkspaceRoot: "/srv/templates"}, spec: `{"binary": "/bin/true", "workspace": {"template": "/srv/templates/../../etc"}}`, wantErr: "is outside /srv/templates"},
+		{name: "relative template", config: cli.ServeConfig{WorkspaceRoot: "/srv/templates"}, spec: `{"binary": "/bin/true", "workspace": {"template": "fixtures"}}`, wantErr: "workspace.template must be an absolute path"},
+		{name: "template under the root", config: cli.ServeConfig{WorkspaceRoot: "/srv/templates"}, spec: `{"binary": "/bin/true", "workspace": {"template": "/srv/templates/fixtures"}}`},
+		{name: "artifacts dir without a root", spec: `{"binary": "/bin/true", "workspace": {"artifacts_dir": "/root/.ssh"}}`, wantErr: "workspace.artifacts_dir is not allowed"},
+		{name: "artifacts dir outside the root", config: cli.ServeConfig{ArtifactsRoot: "/srv/artifacts"}, spec: `{"binary": "/bin/true", "workspace": {"artifacts_dir": "/srv/artifacts-old"}}`, wantErr: "is outside /srv/artifacts"},
+		{name: "artifacts dir under the root", config: cli.ServeConfig{ArtifactsRoot: "/srv/artifacts"}, spec: `{"binary": "/bin/true", "workspace": {"artifacts_dir": "/srv/artifacts/job1"}}`},
+		{name: "watch without a root", spec: `{"binary": "/bin/true", "watch": ["/etc/shadow"]}`, wantErr: "watch is not allowed"},
+		{name: "watch outside the root", config: cli.ServeConfig{WatchRoot: "/srv/data"}, spec: `{"binary": "/bin/true", "watch": ["/srv/data/cache", "/etc/shadow"]}`, wantErr: "watch /etc/shadow is outside /srv/data"},
+		{name: "watch under the root", config: cli.ServeConfig{WatchRoot: "/srv/data"}, spec: `{"binary": "/bin/true", "watch": ["/srv/data/cache", "/srv/data"]}`},
+		{name: "workspace without paths", spec: `{"binary": "/bin/true", "workspace": {"collect": ["*.log"]}}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := test.config
+			config.Policy = PolicyFIFO
+			server := NewServer(&config)
+			var spec JobSpec
+			if err := json.Unmarshal([]byte(test.spec), &spec); err != nil {
+				t.Fatal(err)
+			}
+			err := server.checkOptIns(spec)
+			if test.wantErr == "" && err != nil {
+				t.Errorf("checkOptIns = %v, want the job accepted", err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("checkOptIns = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckUnderSymlink(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "fixtures"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{path: filepath.Join(root, "fixtures")},
+		{path: filepath.Join(root, "new", "dir")},
+		{path: filepath.Join(root, "escape"), wantErr: true},
+		{path: filepath.Join(root, "escape", "new", "dir"), wantErr: true},
+	}
+	for _, test := range tests {
+		if err := checkUnder("workspace.template", test.path, root, "--workspace-root"); (err != nil) != test.wantErr {
+			t.Errorf("checkUnder(%s) = %v, want error %v", test.path, err, test.wantErr)
+		}
+	}
+}
+
+func TestEvict(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		retention   time.Duration
+		maxFinished int
+		finished    []time.Duration // How long ago each job finished, 0 while running
+		want        []string
+	}{
+		{name: "nothing expired", retention: time.Hour, finished: []time.Duration{time.Minute, 0}, want: []string{"job0", "job1"}},
+		{name: "expired", retention: time.Hour, finished: []time.Duration{2 * time.Hour, time.Minute, 0}, want: []string{"job1", "job2"}},
+		{name: "running jobs are kept", retention: time.Hour, maxFinished: 1, finished: []time.Duration{0, 0, 0}, want: []string{"job0", "job1", "job2"}},
+		{name: "oldest finished beyond the maximum", maxFinished: 2, finished: []time.Duration{time.Minute, 3 * time.Minute, 0, 2 * time.Minute}, want: []string{"job0", "job2", "job3"}},
+		{name: "unlimited", finished: []time.Duration{72 * time.Hour, time.Minute}, want: []string{"job0", "job1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := NewServer(&cli.ServeConfig{Policy: PolicyFIFO, Retention: test.retention, MaxFinished: test.maxFinished})
+			for i, ago := range test.finished {
+				job, err := NewJob(JobSpec{Binary: "/bin/true"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				job.ID = fmt.Sprintf("job%d", i)
+				if ago > 0 {
+					job.state = StateSucceeded
+					job.finishedAt = now.Add(-ago)
+				}
+				server.jobs[job.ID] = job
+				server.order = append(server.order, job.ID)
+				server.Metrics.Register(map[string]string{"job_id": job.ID}, job.Config, job.Monitor)
+			}
+
+			server.evict(now)
+
+			if strings.Join(server.order, ",") != strings.Join(test.want, ",") || len(server.jobs) != len(test.want) {
+				t.Errorf("kept %v (%d jobs), want %v", server.order, len(server.jobs), test.want)
+			}
+			rec := httptest.NewRecorder()
+			server.Metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			for id := range server.jobs {
+				if !strings.Contains(rec.Body.String(), `job_id="`+id+`"`) {
+					t.Errorf("metrics of kept job %s are missing", id)
+				}
+			}
+			for i := range test.finished {
+				id := fmt.Sprintf("job%d", i)
+				if server.jobs[id] == nil && strings.Contains(rec.Body.String(), `job_id="`+id+`"`) {
+					t.Errorf("metrics of evicted job %s are still exported", id)
+				}
+			}
+		})
+	}
+}
+
+func TestSubmitOverBudget(t *testing.T) {
+	server := NewServer(&cli.ServeConfig{Policy: PolicyFIFO, MaxMemoryKB: 1024})
+	_, err := server.Submit(JobSpec{Binary: "/bin/true", Limits: JobLimits{MemoryKB: 2048}})
+	if err == nil || !strings.Contains(err.Error(), "exceeds the host budget") {
+		t.Fatalf("error = %v, want the job rejected by the budget", err)
+	}
+	if len(server.jobs) != 0 || len(server.order) != 0 {
+		t.Errorf("rejected job was kept: %v", server.order)
+	}
+
+	rec := httptest.NewRecorder()
+	server.Metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if strings.Contains(rec.Body.String(), "job_id=") {
+		t.Errorf("rejected job is still exported:\n%s", rec.Body.String())
+	}
+}
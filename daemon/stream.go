@@ -0,0 +1,134 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Stream tuning
+const (
+	streamBuffer      = 256              // Events buffered per client before dropping
+	keepAliveInterval = 15 * time.Second // How often idle streams send a keep-alive
+)
+
+// streamEvents sends a job's status, its output so far and then its live events to send.
+// It returns when the job ends, done is closed or send fails.
+func streamEvents(job *Job, done <-chan struct{}, send func(Event) error, keepAlive func() error) error {
+	// Subscribe before replaying so nothing written in between is lost
+	events, unsubscribe := job.Events.Subscribe(streamBuffer)
+	defer unsubscribe()
+
+	if err := send(Event{Type: EventStatus, Time: time.Now(), Data: job.Status()}); err != nil {
+		return err
+	}
+
+	var lastSeq uint64
+	for _, chunk := range job.Logs.Chunks("") {
+		if err := send(Event{Type: EventLog, Time: chunk.Time, Data: chunk}); err != nil {
+			return err
+		}
+		lastSeq = chunk.Seq
+	}
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// The job ended before or while we subscribed
+				if job.Finished() {
+					return send(Event{Type: EventEnd, Time: time.Now(), Data: job.Status()})
+				}
+				return nil
+			}
+			// Skip output already sent during the replay
+			if chunk, isLog := event.Data.(LogChunk); isLog && chunk.Seq <= lastSeq {
+				continue
+			}
+			if err := send(event); err != nil {
+				return err
+			}
+			if event.Type == EventEnd {
+				return nil
+			}
+		case <-ticker.C:
+			if err := keepAlive(); err != nil {
+				return err
+			}
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// handleEvents handles GET /jobs/{id}/events, streaming the job as Server-Sent Events
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	job := s.lookup(w, r)
+	if job == nil {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(event Event) error {
+		data, err := json.Marshal(event.Data)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	keepAlive := func() error {
+		if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	streamEvents(job, r.Context().Done(), send, keepAlive)
+}
+
+// handleWebSocket handles GET /jobs/{id}/ws, streaming the job as JSON WebSocket messages
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	job := s.lookup(w, r)
+	if job == nil {
+		return
+	}
+
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer conn.Close()
+
+	send := func(event Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return conn.WriteText(data)
+	}
+
+	if streamEvents(job, conn.Done(), send, conn.Ping) == nil {
+		conn.WriteClose(wsCloseNormal, "job finished")
+	}
+}
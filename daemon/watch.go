@@ -0,0 +1,143 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// NewClient returns an HTTP client and base URL for a daemon listening on
+// a TCP address or on unix:/path/to/socket
+func NewClient(server string) (*http.Client, string) {
+	if strings.HasPrefix(server, "unix:") {
+		socket := strings.TrimPrefix(server, "unix:")
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &http.Client{Transport: transport}, "http://kernelscope"
+	}
+
+	if !strings.HasPrefix(server, "http://") && !strings.HasPrefix(server, "https://") {
+		server = "http://" + server
+	}
+	return &http.Client{}, strings.TrimRight(server, "/")
+}
+
+// Watch follows a job on the daemon, printing its output and live samples until it ends.
+// It returns the job's exit code, or -1 if the job did not exit normally.
+func Watch(server string, token string, jobID string, showSamples bool) (int, error) {
+	client, baseURL := NewClient(server)
+
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/jobs/"+jobID+"/events", nil)
+	if err != nil {
+		return -1, fmt.Errorf("failed to create request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return -1, fmt.Errorf("failed to connect to daemon: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return -1, fmt.Errorf("daemon returned %s: %s", resp.Status, apiErr.Error)
+	}
+
+	// Parse the Server-Sent Events stream
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 8*1024*1024)
+	var eventType string
+	var data strings.Builder
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if eventType != "" {
+				if exitCode, done := printEvent(eventType, data.String(), showSamples); done {
+					return exitCode, nil
+				}
+			}
+			eventType = ""
+			data.Reset()
+		case strings.HasPrefix(line, "event:"):
+			eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return -1, fmt.Errorf("event stream failed: %v", err)
+	}
+	return -1, fmt.Errorf("event stream ended before the job finished")
+}
+
+// printEvent prints a single event. It reports the exit code and true once the job has ended.
+func printEvent(eventType string, data string, showSamples bool) (int, bool) {
+	switch eventType {
+	case EventLog:
+		var chunk LogChunk
+		if json.Unmarshal([]byte(data), &chunk) == nil {
+			if chunk.Stream == "stderr" {
+				fmt.Fprint(os.Stderr, chunk.Data)
+			} else {
+				fmt.Fprint(os.Stdout, chunk.Data)
+			}
+		}
+
+	case EventSample:
+		var sample SampleEvent
+		if showSamples && json.Unmarshal([]byte(data), &sample) == nil {
+			fmt.Fprintf(os.Stderr, "[kernelscope] CPU: %.2fs | Memory: %d KB | Threads: %d | Processes: %d\n",
+				sample.CpuSeconds, sample.MemoryKB, sample.Threads, sample.Processes)
+		}
+
+	case EventNotice:
+		var notice map[string]string
+		if json.Unmarshal([]byte(data), &notice) == nil {
+			fmt.Fprintf(os.Stderr, "[kernelscope] %s\n", notice["message"])
+		}
+
+	case EventStatus:
+		var status JobStatus
+		if json.Unmarshal([]byte(data), &status) == nil {
+			fmt.Fprintf(os.Stderr, "[kernelscope] Job %s is %s\n", status.ID, status.State)
+		}
+
+	case EventEnd:
+		var status JobStatus
+		if json.Unmarshal([]byte(data), &status) != nil {
+			return -1, true
+		}
+		fmt.Fprintf(os.Stderr, "[kernelscope] Job %s %s", status.ID, status.State)
+		if status.TermReason != "" {
+			fmt.Fprintf(os.Stderr, " (%s)", status.TermReason)
+		}
+		fmt.Fprintln(os.Stderr)
+		if status.ExitCode == nil || status.TermReason != "" {
+			return -1, true
+		}
+		return *status.ExitCode, true
+	}
+
+	return 0, false
+}
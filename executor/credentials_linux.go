@@ -0,0 +1,134 @@
+package executor
+
+import (
+	"fmt"
+	"kernelscope/workspace"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// credentials tells the init helper which identity to switch to before exec
+type credentials struct {
+	Uid    int   `json:"uid"`
+	Gid    int   `json:"gid"`
+	Groups []int `json:"groups"`
+	SetIDs bool  `json:"set_ids"` // False in the sandbox, where the user namespace already maps to the identity
+}
+
+// Securebits that stop uid 0 from regaining capabilities on exec or setuid, each locked
+const (
+	secbitNoRoot                  = 1 << 0
+	secbitNoRootLocked            = 1 << 1
+	secbitNoSetuidFixup           = 1 << 2
+	secbitNoSetuidFixupLocked     = 1 << 3
+	secbitKeepCapsLocked          = 1 << 5
+	secbitNoCapAmbientRaise       = 1 << 6
+	secbitNoCapAmbientRaiseLocked = 1 << 7
+)
+
+// lockedSecurebits keeps root without capabilities for good
+const lockedSecurebits = secbitNoRoot | secbitNoRootLocked | secbitNoSetuidFixup | secbitNoSetuidFixupLocked |
+	secbitKeepCapsLocked | secbitNoCapAmbientRaise | secbitNoCapAmbientRaiseLocked
+
+// dropPrivileges switches to the identity and gives up every capability,
+// including the bounding set, so nothing the binary execs can regain them
+func dropPrivileges(creds *credentials) error {
+	// Bounding set and securebits first, both need CAP_SETPCAP which is lost below.
+	// Without it no_new_privs alone keeps setuid and file capabilities from granting any.
+	setpcap, err := holdsCapability(unix.CAP_SETPCAP)
+	if err != nil {
+		return fmt.Errorf("failed to read capabilities: %v", err)
+	}
+	if setpcap {
+		for capability := 0; capability <= unix.CAP_LAST_CAP; capability++ {
+			if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(capability), 0, 0, 0); err != nil && err != unix.EINVAL {
+				return fmt.Errorf("failed to drop capability %d from the bounding set: %v", capability, err)
+			}
+		}
+		if err := unix.Prctl(unix.PR_SET_SECUREBITS, lockedSecurebits, 0, 0, 0); err != nil {
+			return fmt.Errorf("failed to lock securebits: %v", err)
+		}
+	}
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to clear ambient capabilities: %v", err)
+	}
+
+	if creds.SetIDs {
+		// The syscall package applies these to every thread
+		if err := syscall.Setgroups(creds.Groups); err != nil {
+			return fmt.Errorf("failed to set supplementary groups: %v", err)
+		}
+		if err := syscall.Setresgid(creds.Gid, creds.Gid, creds.Gid); err != nil {
+			return fmt.Errorf("failed to set group %d: %v", creds.Gid, err)
+		}
+		if err := syscall.Setresuid(creds.Uid, creds.Uid, creds.Uid); err != nil {
+			return fmt.Errorf("failed to set user %d: %v", creds.Uid, err)
+		}
+		// The kernel leaves an ID of -1 unchanged, so check what was applied
+		ruid, euid, suid := unix.Getresuid()
+		rgid, egid, sgid := unix.Getresgid()
+		for _, id := range []int{ruid, euid, suid} {
+			if id != creds.Uid {
+				return fmt.Errorf("still running as user %d instead of %d", id, creds.Uid)
+			}
+		}
+		for _, id := range []int{rgid, egid, sgid} {
+			if id != creds.Gid {
+				return fmt.Errorf("still running as group %d instead of %d", id, creds.Gid)
+			}
+		}
+	}
+
+	// Changing to a non-root user already clears these, but not when staying root
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capset(&header, &data[0]); err != nil {
+		return fmt.Errorf("failed to clear capabilities: %v", err)
+	}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %v", err)
+	}
+	return nil
+}
+
+// holdsCapability reports whether the calling thread has a capability in its effective set
+func holdsCapability(capability int) (bool, error) {
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&header, &data[0]); err != nil {
+		return false, err
+	}
+	return data[capability/32].Effective&(1<<(capability%32)) != 0, nil
+}
+
+// copyTreeAs copies a directory tree with the filesystem identity of id, so it only
+// reads what id can and the copies belong to id. The copy runs on its own thread,
+// which exits afterwards rather than go back to the runtime with that identity.
+func copyTreeAs(id Identity, src, dst string) error {
+	done := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread() // Never unlocked
+
+		// Unlike the syscall package, these only change the calling thread
+		if err := unix.Setgroups(id.Groups); err != nil {
+			done <- fmt.Errorf("failed to set supplementary groups: %v", err)
+			return
+		}
+		unix.Setfsgid(id.Gid)
+		unix.Setfsuid(id.Uid)
+		// Neither call reports failure, but both return the current ID
+		if gid, _ := unix.SetfsgidRetGid(-1); gid != id.Gid {
+			done <- fmt.Errorf("failed to switch to group %d", id.Gid)
+			return
+		}
+		if uid, _ := unix.SetfsuidRetUid(-1); uid != id.Uid {
+			done <- fmt.Errorf("failed to switch to user %d", id.Uid)
+			return
+		}
+		done <- workspace.CopyTree(src, dst)
+	}()
+	return <-done
+}
@@ -1,28 +1,72 @@
 package executor
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"kernelscope/cli"
+	"kernelscope/procevents"
+	"kernelscope/workspace"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
 )
 
 // Process represents a running process
 type Process struct {
-	Cmd    *exec.Cmd
+	Cmd        *exec.Cmd
+	Pid        int
+	Config     *cli.Config
+	Violations <-chan SyscallViolation // System calls refused by the seccomp profile, nil without one
+	Identity   Identity                // User and groups the binary runs as
+	Workspace  *workspace.Workspace    // Per-run working directory, nil unless configured
+	Events     <-chan procevents.Event // Forks, execs and exits reported by the tracer, nil unless tracing
+
+	traced   <-chan traceResult // Reported by the tracer when the binary exits, nil unless tracing
+	syscalls atomic.Pointer[SyscallSummary]
+	release  func() // Lets the binary exec once its limits are applied, nil if it was not held
+}
+
+// Release lets a binary held before exec run. The monitor calls it once the
+// limits are applied; it does nothing for a binary that was not held.
+func (p *Process) Release() {
+	if p.release != nil {
+		p.release()
+		p.release = nil
+	}
+}
+
+// Syscalls returns the system call counts of a traced process once it has
+// been waited for, nil otherwise
+func (p *Process) Syscalls() *SyscallSummary {
+	return p.syscalls.Load()
+}
+
+// SyscallViolation is a system call the seccomp profile does not allow
+type SyscallViolation struct {
 	Pid    int
-	Config *cli.Config
+	Number int
+	Name   string
 }
 
 // Executor handles process execution
 type Executor struct {
 	Config *cli.Config
+	Stdin  io.Reader // Standard input of the process, nil for /dev/null
+	Stdout io.Writer // Standard output of the process
+	Stderr io.Writer // Standard error of the process
 }
 
 // NewExecutor creates a new Executor with the given configuration
 func NewExecutor(config *cli.Config) *Executor {
 	return &Executor{
 		Config: config,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
 	}
 }
 
@@ -30,27 +74,187 @@ func NewExecutor(config *cli.Config) *Executor {
 func (e *Executor) StartProcess() (*Process, error) {
 	fmt.Printf("Starting process: %s\n", e.Config.BinaryPath)
 
-	// Create command with the binary path
-	cmd := exec.Command(e.Config.BinaryPath)
+	identity, err := resolveIdentity(e.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Give the run its own working directory
+	var ws *workspace.Workspace
+	if e.Config.UsesWorkspace() {
+		if ws, err = createWorkspace(e.Config, identity); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Workspace: %s\n", ws.Dir)
+	}
+
+	process, err := e.startProcess(identity, ws)
+	if err != nil && ws != nil {
+		ws.Remove()
+	}
+	return process, err
+}
+
+// startProcess starts the binary in the workspace, if any
+func (e *Executor) startProcess(identity Identity, ws *workspace.Workspace) (*Process, error) {
+	// Create command with the binary path and its arguments
+	cmd := exec.Command(e.Config.BinaryPath, e.Config.Args...)
+
+	// Add any extra environment variables
+	if len(e.Config.Env) > 0 {
+		cmd.Env = append(os.Environ(), e.Config.Env...)
+	}
+
+	// For a sandbox, seccomp, Landlock or another identity KernelScope re-executes itself to set
+	// them up. It also holds the binary before exec while the kernel limits are applied.
+	var started func(*Process)
+	helper := e.Config.Sandbox || e.Config.SeccompProfile != "" || e.Config.UsesLandlock() || e.Config.DropsPrivileges() || holdsForLimits(e.Config)
+	if helper {
+		sandboxCmd, hook, err := sandboxCommand(e.Config, cmd.Env, identity, ws)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare sandbox: %v", err)
+		}
+		cmd, started = sandboxCmd, hook
+	}
+
+	if ws != nil {
+		// A relative binary path must not be resolved against the workspace
+		if !filepath.IsAbs(cmd.Path) {
+			path, err := filepath.Abs(cmd.Path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve %s: %v", cmd.Path, err)
+			}
+			cmd.Path = path
+		}
+		cmd.Dir = ws.Dir
+	}
 
 	// Configure output redirection
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
+	cmd.Stdout = e.Stdout
+	cmd.Stderr = e.Stderr
+	cmd.Stdin = e.Stdin
 
-	// Start the process
-	err := cmd.Start()
+	// Start the process, under ptrace when tracing system calls
+	var traced <-chan traceResult
+	var events <-chan procevents.Event
+	var err error
+	if e.Config.TraceSyscalls {
+		traced, events, err = startTraced(cmd, e.Config.TraceLog, helper)
+	} else {
+		err = cmd.Start()
+	}
 	if err != nil {
+		if started != nil {
+			started(nil)
+		}
 		return nil, fmt.Errorf("failed to start process: %v", err)
 	}
 
 	fmt.Printf("Process started with PID: %d\n", cmd.Process.Pid)
 
-	return &Process{
-		Cmd:    cmd,
-		Pid:    cmd.Process.Pid,
-		Config: e.Config,
-	}, nil
+	process := &Process{
+		Cmd:       cmd,
+		Pid:       cmd.Process.Pid,
+		Config:    e.Config,
+		Identity:  identity,
+		Workspace: ws,
+		Events:    events,
+		traced:    traced,
+	}
+	if started != nil {
+		started(process)
+	}
+	return process, nil
+}
+
+// RunCommand runs a shell command confined like the binary: as the same identity,
+// under the same sandbox, seccomp profile and Landlock policy, and in the run's
+// workspace ws if there is one. In a sandbox tmpfs workspace it sees a copy of the
+// files. It returns the combined output and fails unless the command exits with 0.
+func (e *Executor) RunCommand(ctx context.Context, command string, env []string, ws *workspace.Workspace) ([]byte, error) {
+	config := *e.Config
+	config.BinaryPath = "/bin/sh"
+	config.Args = []string{"-c", command}
+	config.Env = append(slices.Clip(config.Env), env...)
+	config.TraceSyscalls = false
+
+	identity, err := resolveIdentity(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	// A sandbox sends back its own tmpfs workspace, which must not replace the run's
+	if ws != nil {
+		ws = &workspace.Workspace{Dir: ws.Dir, Root: ws.Root}
+		defer ws.Close()
+	}
+
+	var output bytes.Buffer
+	runner := &Executor{Config: &config, Stdout: &output, Stderr: &output}
+	process, err := runner.startProcess(identity, ws)
+	if err != nil {
+		return nil, err
+	}
+	process.Release()
+
+	done := make(chan error, 1)
+	go func() {
+		code, err := runner.WaitForProcess(process)
+		if err == nil && code != 0 {
+			err = fmt.Errorf("exit status %d", code)
+		}
+		done <- err
+	}()
+
+	violations := process.Violations
+	for {
+		select {
+		case err := <-done:
+			return output.Bytes(), err
+		case violation, ok := <-violations:
+			if !ok {
+				violations = nil
+				continue
+			}
+			runner.KillProcessTree(process)
+			<-done
+			return output.Bytes(), fmt.Errorf("seccomp violation: syscall %d (%s)", violation.Number, violation.Name)
+		case <-ctx.Done():
+			runner.KillProcessTree(process)
+			<-done
+			return output.Bytes(), ctx.Err()
+		}
+	}
+}
+
+// createWorkspace creates the run's working directory and hands it to the run's identity.
+// The template is read as that identity, so it cannot copy files the run could not read.
+// A sandbox tmpfs workspace is seeded inside the sandbox instead.
+func createWorkspace(config *cli.Config, identity Identity) (*workspace.Workspace, error) {
+	template := config.WorkspaceTemplate
+	if config.Sandbox && config.WorkspaceSizeKB > 0 {
+		template = ""
+	}
+
+	if !config.DropsPrivileges() {
+		return workspace.Create(template)
+	}
+
+	ws, err := workspace.Create("")
+	if err != nil {
+		return nil, err
+	}
+	if err := ws.Chown(identity.Uid, identity.Gid); err != nil {
+		ws.Remove()
+		return nil, fmt.Errorf("failed to hand the workspace to uid %d: %v", identity.Uid, err)
+	}
+	if template != "" {
+		if err := copyTreeAs(identity, template, ws.Dir); err != nil {
+			ws.Remove()
+			return nil, fmt.Errorf("failed to seed workspace from %s as uid %d: %v", template, identity.Uid, err)
+		}
+	}
+	return ws, nil
 }
 
 // KillProcess kills the specified process
@@ -63,6 +267,15 @@ func (e *Executor) KillProcess(process *Process) error {
 
 // WaitForProcess waits for the process to complete and returns exit code
 func (e *Executor) WaitForProcess(process *Process) (int, error) {
+	if process.traced != nil {
+		result := <-process.traced
+		process.syscalls.Store(result.syscalls)
+
+		// The tracer has reaped the process, this only collects its output
+		process.Cmd.Wait()
+		return result.exitCode, result.err
+	}
+
 	err := process.Cmd.Wait()
 
 	if err != nil {
@@ -0,0 +1,144 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"kernelscope/cli"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// Identity is the user and groups the binary runs as
+type Identity struct {
+	Uid    int      `json:"uid"`
+	Gid    int      `json:"gid"`
+	Groups []int    `json:"groups"`          // Supplementary group IDs
+	User   string   `json:"user,omitempty"`  // User name, empty if unknown
+	Group  string   `json:"group,omitempty"` // Group name, empty if unknown
+	Names  []string `json:"-"`               // Supplementary group names, empty where unknown
+}
+
+// String formats the identity like id(1)
+func (id Identity) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "uid=%d", id.Uid)
+	if id.User != "" {
+		fmt.Fprintf(&b, "(%s)", id.User)
+	}
+	fmt.Fprintf(&b, " gid=%d", id.Gid)
+	if id.Group != "" {
+		fmt.Fprintf(&b, "(%s)", id.Group)
+	}
+	if len(id.Groups) > 0 {
+		groups := make([]string, len(id.Groups))
+		for i, gid := range id.Groups {
+			groups[i] = strconv.Itoa(gid)
+			if i < len(id.Names) && id.Names[i] != "" {
+				groups[i] += "(" + id.Names[i] + ")"
+			}
+		}
+		fmt.Fprintf(&b, " groups=%s", strings.Join(groups, ","))
+	}
+	return b.String()
+}
+
+// resolveIdentity works out who the binary runs as. Without --user or --group
+// it keeps KernelScope's own identity; running as root is refused unless allowed.
+func resolveIdentity(config *cli.Config) (Identity, error) {
+	id := Identity{Uid: os.Geteuid(), Gid: os.Getegid()}
+	if !config.DropsPrivileges() {
+		if groups, err := os.Getgroups(); err == nil {
+			id.Groups = groups
+		}
+	}
+
+	if config.User != "" {
+		u, err := lookupUser(config.User)
+		if err != nil {
+			return id, err
+		}
+		id.Uid, _ = strconv.Atoi(u.Uid)
+		id.Gid, _ = strconv.Atoi(u.Gid)
+	}
+	if config.Group != "" {
+		gid, err := lookupGroup(config.Group)
+		if err != nil {
+			return id, err
+		}
+		id.Gid = gid
+	}
+	for _, name := range config.SupplementaryGroups {
+		gid, err := lookupGroup(name)
+		if err != nil {
+			return id, err
+		}
+		id.Groups = append(id.Groups, gid)
+	}
+
+	// Names are informational only
+	if u, err := user.LookupId(strconv.Itoa(id.Uid)); err == nil {
+		id.User = u.Username
+	}
+	if g, err := user.LookupGroupId(strconv.Itoa(id.Gid)); err == nil {
+		id.Group = g.Name
+	}
+	for _, gid := range id.Groups {
+		name := ""
+		if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+			name = g.Name
+		}
+		id.Names = append(id.Names, name)
+	}
+
+	if id.Uid == 0 && !config.AllowRoot {
+		return id, fmt.Errorf("refusing to run the binary as root: pass --user to drop privileges or --allow-root")
+	}
+	return id, nil
+}
+
+// maxID is the largest user or group ID; the kernel reads (uid_t)-1 as "leave unchanged"
+const maxID = 1<<32 - 2
+
+// parseID parses a numeric user or group ID, reporting false for a name
+func parseID(kind, name string) (int, bool, error) {
+	id, err := strconv.ParseInt(name, 10, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return 0, false, nil
+	}
+	if err != nil || id < 0 || id > maxID {
+		return 0, true, fmt.Errorf("%s ID %s is outside 0-%d", kind, name, maxID)
+	}
+	return int(id), true, nil
+}
+
+// lookupUser finds a user by name or numeric ID
+func lookupUser(name string) (*user.User, error) {
+	if _, numeric, err := parseID("user", name); err != nil {
+		return nil, err
+	} else if numeric {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+		// Numeric IDs need not exist in the user database
+		return &user.User{Uid: name, Gid: name}, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user %s: %v", name, err)
+	}
+	return u, nil
+}
+
+// lookupGroup finds a group ID by name or numeric ID
+func lookupGroup(name string) (int, error) {
+	if gid, numeric, err := parseID("group", name); err != nil || numeric {
+		return gid, err
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up group %s: %v", name, err)
+	}
+	return strconv.Atoi(g.Gid)
+}
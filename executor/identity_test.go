@@ -0,0 +1,53 @@
+package executor
+
+import (
+	"kernelscope/cli"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestResolveIdentity(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("user and group IDs are numeric only on Unix")
+	}
+
+	tests := []struct {
+		name    string
+		config  cli.Config
+		wantUid int
+		wantGid int
+		wantErr string // Empty when the identity resolves
+	}{
+		{name: "numeric user", config: cli.Config{User: "4242"}, wantUid: 4242, wantGid: 4242},
+		{name: "numeric user and group", config: cli.Config{User: "4242", Group: "4343"}, wantUid: 4242, wantGid: 4343},
+		{name: "largest ID", config: cli.Config{User: "4294967294", Group: "4294967294"}, wantUid: 4294967294, wantGid: 4294967294},
+		{name: "user -1", config: cli.Config{User: "-1"}, wantErr: "user ID -1 is outside"},
+		{name: "user -1 with root allowed", config: cli.Config{User: "-1", AllowRoot: true}, wantErr: "user ID -1 is outside"},
+		{name: "unchanged user ID", config: cli.Config{User: "4294967295"}, wantErr: "user ID 4294967295 is outside"},
+		{name: "user wrapping to root", config: cli.Config{User: "4294967296"}, wantErr: "user ID 4294967296 is outside"},
+		{name: "user beyond int64", config: cli.Config{User: "99999999999999999999"}, wantErr: "is outside"},
+		{name: "group -1", config: cli.Config{User: "4242", Group: "-1"}, wantErr: "group ID -1 is outside"},
+		{name: "supplementary group -1", config: cli.Config{User: "4242", SupplementaryGroups: []string{"-1"}}, wantErr: "group ID -1 is outside"},
+		{name: "root refused", config: cli.Config{User: "0"}, wantErr: "refusing to run the binary as root"},
+		{name: "root allowed", config: cli.Config{User: "0", AllowRoot: true}, wantUid: 0, wantGid: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, err := resolveIdentity(&test.config)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("resolveIdentity = %v, %v, want error %q", id, err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id.Uid != test.wantUid || id.Gid != test.wantGid {
+				t.Errorf("resolveIdentity = uid %d gid %d, want uid %d gid %d", id.Uid, id.Gid, test.wantUid, test.wantGid)
+			}
+		})
+	}
+}
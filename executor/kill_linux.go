@@ -0,0 +1,59 @@
+package executor
+
+import (
+	"fmt"
+	"kernelscope/utils"
+	"syscall"
+)
+
+// maxFreezePasses bounds how often the tree is re-walked while freezing it
+const maxFreezePasses = 10
+
+// KillProcessTree kills the process and all of its descendants.
+// The tree is stopped first so that nothing can fork while it is being
+// collected; otherwise a fork bomb outruns a walk-and-kill loop.
+func (e *Executor) KillProcessTree(process *Process) error {
+	fmt.Printf("Killing process tree rooted at PID: %d\n", process.Pid)
+
+	syscall.Kill(process.Pid, syscall.SIGSTOP)
+
+	stopped := make(map[int]bool)
+	for pass := 0; pass < maxFreezePasses; pass++ {
+		children, err := utils.GetAllChildProcesses(process.Pid)
+		if err != nil {
+			break
+		}
+
+		found := false
+		for _, child := range children {
+			if !stopped[child] {
+				syscall.Kill(child, syscall.SIGSTOP)
+				stopped[child] = true
+				found = true
+			}
+		}
+		if !found {
+			break
+		}
+	}
+
+	for child := range stopped {
+		syscall.Kill(child, syscall.SIGKILL)
+	}
+
+	return process.Cmd.Process.Kill()
+}
+
+// StopProcesses suspends processes with SIGSTOP
+func StopProcesses(pids []int) {
+	for _, pid := range pids {
+		syscall.Kill(pid, syscall.SIGSTOP)
+	}
+}
+
+// ContinueProcesses resumes processes suspended by StopProcesses
+func ContinueProcesses(pids []int) {
+	for _, pid := range pids {
+		syscall.Kill(pid, syscall.SIGCONT)
+	}
+}
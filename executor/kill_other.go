@@ -0,0 +1,14 @@
+//go:build !linux
+
+package executor
+
+// KillProcessTree kills the process; descendants cannot be discovered outside Linux
+func (e *Executor) KillProcessTree(process *Process) error {
+	return e.KillProcess(process)
+}
+
+// StopProcesses is a no-op outside Linux, where --cpu-rate is not enforced
+func StopProcesses(pids []int) {}
+
+// ContinueProcesses is a no-op outside Linux
+func ContinueProcesses(pids []int) {}
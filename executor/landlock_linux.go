@@ -0,0 +1,187 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Landlock access rights granted to each kind of path
+const (
+	landlockRead  = unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	landlockWrite = landlockRead | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE | unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_MAKE_SOCK | unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK | unix.LANDLOCK_ACCESS_FS_MAKE_SYM | unix.LANDLOCK_ACCESS_FS_REFER |
+		unix.LANDLOCK_ACCESS_FS_TRUNCATE | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	landlockExec = landlockRead | unix.LANDLOCK_ACCESS_FS_EXECUTE
+
+	// landlockFileAccess are the only rights that apply to a regular file rather than a directory
+	landlockFileAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+)
+
+// landlockLibraryPaths hold dynamic loaders, which the kernel opens for execution
+var landlockLibraryPaths = []string{"/lib", "/lib32", "/lib64", "/libx32", "/usr/lib", "/usr/lib32", "/usr/lib64", "/usr/libx32"}
+
+// landlockCurrentABI is the newest Landlock ABI version whose rights KernelScope uses
+const landlockCurrentABI = 5
+
+// landlockRule grants access beneath a path
+type landlockRule struct {
+	Path   string `json:"path"`
+	Access uint64 `json:"access"`
+
+	Inside bool `json:"inside,omitempty"` // Path is as seen inside the sandbox rather than on the host
+	Fd     int  `json:"fd,omitempty"`     // Path opened before the sandbox was built, 0 to open it when applied
+}
+
+// landlockPolicy is the ruleset the init helper applies before exec
+type landlockPolicy struct {
+	ABI   int            `json:"abi"`
+	Rules []landlockRule `json:"rules"`
+}
+
+// landlockABI returns the Landlock ABI version supported by the kernel, or 0 without Landlock
+func landlockABI() int {
+	version, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return 0
+	}
+	return int(version)
+}
+
+// landlockHandled returns the filesystem rights a given ABI version can restrict
+func landlockHandled(abi int) uint64 {
+	// ABI 1 covers everything up to making symlinks
+	handled := uint64(unix.LANDLOCK_ACCESS_FS_MAKE_SYM<<1 - 1)
+	if abi >= 2 {
+		handled |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		handled |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	if abi >= 5 {
+		handled |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+	return handled
+}
+
+// landlockPolicyFor builds the ruleset from the --allow-* paths. The binary stays
+// executable, the system paths readable, the library paths executable so
+// dynamically linked programs load, and the basic devices such as /dev/null usable.
+// It returns nil when the kernel has no Landlock, after warning that paths are unrestricted.
+func landlockPolicyFor(binary string, read, write, exec []string) (*landlockPolicy, error) {
+	abi := landlockABI()
+	if abi == 0 {
+		fmt.Println("Warning: Landlock is not supported by this kernel, filesystem access is not restricted")
+		return nil, nil
+	}
+	if abi < landlockCurrentABI {
+		fmt.Printf("Warning: this kernel supports Landlock ABI version %d of %d, some file operations are not restricted\n", abi, landlockCurrentABI)
+	}
+
+	policy := &landlockPolicy{ABI: abi}
+	add := func(paths []string, access uint64) error {
+		for _, path := range paths {
+			path, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			policy.Rules = append(policy.Rules, landlockRule{Path: path, Access: access})
+		}
+		return nil
+	}
+
+	for _, path := range sandboxSystemPaths {
+		if _, err := os.Stat(path); err == nil {
+			policy.Rules = append(policy.Rules, landlockRule{Path: path, Access: landlockRead})
+		}
+	}
+	for _, path := range landlockLibraryPaths {
+		if _, err := os.Stat(path); err == nil {
+			policy.Rules = append(policy.Rules, landlockRule{Path: path, Access: landlockExec})
+		}
+	}
+	for _, device := range sandboxDevices {
+		if _, err := os.Stat("/dev/" + device); err == nil {
+			policy.Rules = append(policy.Rules, landlockRule{Path: "/dev/" + device, Access: landlockWrite})
+		}
+	}
+	policy.Rules = append(policy.Rules, landlockRule{Path: binary, Access: landlockExec})
+
+	if err := add(read, landlockRead); err != nil {
+		return nil, err
+	}
+	if err := add(write, landlockWrite); err != nil {
+		return nil, err
+	}
+	if err := add(exec, landlockExec); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// openHostPaths opens the rules' host paths while the host filesystem is still
+// visible, so they can be applied after pivoting into the sandbox. Bind mounts
+// share the host's inodes, so the rules hold wherever the paths are mounted.
+func (policy *landlockPolicy) openHostPaths() error {
+	for i, rule := range policy.Rules {
+		if rule.Inside {
+			continue
+		}
+		fd, err := unix.Open(rule.Path, unix.O_PATH|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("landlock path %s: %v", rule.Path, err)
+		}
+		policy.Rules[i].Fd = fd
+	}
+	return nil
+}
+
+// applyLandlock restricts the calling thread, and everything it execs, to the policy's paths
+func applyLandlock(policy *landlockPolicy) error {
+	handled := landlockHandled(policy.ABI)
+
+	// Only the filesystem field is set, which every ABI version accepts
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	ruleset, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), 8, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create landlock ruleset: %v", errno)
+	}
+	defer unix.Close(int(ruleset))
+
+	for _, rule := range policy.Rules {
+		fd := rule.Fd
+		if fd == 0 {
+			var err error
+			if fd, err = unix.Open(rule.Path, unix.O_PATH|unix.O_CLOEXEC, 0); err != nil {
+				return fmt.Errorf("landlock path %s: %v", rule.Path, err)
+			}
+		}
+
+		access := rule.Access & handled
+		var stat unix.Stat_t
+		if err := unix.Fstat(fd, &stat); err == nil && stat.Mode&unix.S_IFMT != unix.S_IFDIR {
+			access &= landlockFileAccess
+		}
+
+		beneath := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+		_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, ruleset, unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&beneath)), 0, 0, 0)
+		unix.Close(fd)
+		if errno != 0 {
+			return fmt.Errorf("failed to add landlock rule for %s: %v", rule.Path, errno)
+		}
+	}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %v", err)
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, ruleset, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to enforce landlock ruleset: %v", errno)
+	}
+	return nil
+}
@@ -0,0 +1,485 @@
+package executor
+
+import (
+	"bufio"
+	"fmt"
+	"kernelscope/procevents"
+	"kernelscope/utils"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// traceOptions follow every process and thread the binary starts, stop them
+// before they exit, and mark system call stops so they cannot be mistaken for a SIGTRAP
+const traceOptions = unix.PTRACE_O_TRACESYSGOOD | unix.PTRACE_O_TRACEFORK | unix.PTRACE_O_TRACEVFORK |
+	unix.PTRACE_O_TRACECLONE | unix.PTRACE_O_TRACEEXEC | unix.PTRACE_O_TRACEEXIT | unix.PTRACE_O_EXITKILL
+
+// syscallStopSignal is the stop signal of a system call stop with PTRACE_O_TRACESYSGOOD
+const syscallStopSignal = syscall.SIGTRAP | 0x80
+
+// ptraceSyscallInfo mirrors struct ptrace_syscall_info
+type ptraceSyscallInfo struct {
+	Op                 uint8
+	_                  [3]uint8
+	Arch               uint32
+	InstructionPointer uint64
+	StackPointer       uint64
+	Data               [8]uint64 // Entry: nr and args[6]. Exit: rval and is_error.
+}
+
+// tracedThread is the state of one traced thread
+type tracedThread struct {
+	process *tracedProcess
+	inCall  bool
+	counted bool // Whether the call in progress is counted
+	number  int
+	args    [6]uint64
+	entered time.Time
+	swallow bool // Drop the next SIGCONT, sent by KernelScope while taking the root over
+}
+
+// tracedProcess holds the counts of one thread group
+type tracedProcess struct {
+	pid      int
+	comm     string
+	counting bool // False while the init helper runs, before it execs the binary; inherited on fork
+	exited   bool // Whether its exit has been reported
+	calls    map[int]*SyscallStat
+}
+
+// syscallTracer runs the binary under ptrace and counts the system calls of every process it starts
+type syscallTracer struct {
+	cmd       *exec.Cmd
+	fromExec  bool
+	log       *bufio.Writer // Full trace, nil without a trace log
+	logFile   *os.File
+	threads   map[int]*tracedThread
+	processes map[int]*tracedProcess
+	order     []*tracedProcess
+	result    chan traceResult
+	events    chan procevents.Event // Forks, execs and exits of the processes in the tree
+}
+
+// startTraced starts cmd under ptrace. When fromExec is set the root's calls
+// are only counted once it execs, skipping the init helper. The result is
+// delivered when the root exits; processes it leaves behind stay traced until
+// they exit too, and the event channel is closed after that.
+func startTraced(cmd *exec.Cmd, logPath string, fromExec bool) (<-chan traceResult, <-chan procevents.Event, error) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Ptrace = true
+
+	tracer := &syscallTracer{
+		cmd:       cmd,
+		fromExec:  fromExec,
+		threads:   make(map[int]*tracedThread),
+		processes: make(map[int]*tracedProcess),
+		result:    make(chan traceResult, 1),
+		events:    procevents.NewChannel(),
+	}
+	if logPath != "" {
+		file, err := os.Create(logPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create trace log: %v", err)
+		}
+		tracer.logFile = file
+		tracer.log = bufio.NewWriter(file)
+	}
+
+	started := make(chan error)
+	go tracer.run(started)
+	if err := <-started; err != nil {
+		tracer.closeLog()
+		return nil, nil, err
+	}
+	return tracer.result, tracer.events, nil
+}
+
+// run starts the binary and traces it until every tracee has exited
+func (t *syscallTracer) run(started chan<- error) {
+	// Every ptrace request must come from the thread that started the binary.
+	// The thread stays locked, so it exits along with this goroutine.
+	runtime.LockOSThread()
+
+	if err := t.cmd.Start(); err != nil {
+		started <- err
+		return
+	}
+	root := t.cmd.Process.Pid
+
+	// The binary stops with SIGTRAP once it has been executed
+	var status unix.WaitStatus
+	if _, err := unix.Wait4(root, &status, unix.WALL, nil); err != nil || !status.Stopped() {
+		started <- fmt.Errorf("failed to trace PID %d: it did not stop after exec", root)
+		return
+	}
+	if err := seize(root); err != nil {
+		t.cmd.Process.Kill()
+		unix.Wait4(root, &status, unix.WALL, nil)
+		started <- err
+		return
+	}
+	started <- nil
+
+	thread := t.addThread(root)
+	thread.process.counting = !t.fromExec
+	thread.swallow = true
+	unix.Kill(root, unix.SIGCONT)
+	unix.PtraceSyscall(root, 0)
+
+	t.trace(root)
+	t.closeLog()
+	close(t.events)
+}
+
+// trace handles stops until no tracee is left
+func (t *syscallTracer) trace(root int) {
+	delivered := false
+	for {
+		// __WNOTHREAD keeps this from reaping children of other KernelScope threads
+		var status unix.WaitStatus
+		tid, err := unix.Wait4(-1, &status, unix.WALL|unix.WNOTHREAD, nil)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			break
+		}
+
+		switch {
+		case status.Exited() || status.Signaled():
+			t.threadExited(tid, status)
+			if tid == root {
+				exitCode := -1
+				if status.Exited() {
+					exitCode = status.ExitStatus()
+				}
+				t.result <- traceResult{exitCode: exitCode, syscalls: t.summary()}
+				delivered = true
+			}
+		case status.Stopped():
+			t.stopped(tid, status)
+		}
+	}
+
+	if !delivered {
+		t.result <- traceResult{exitCode: -1, err: fmt.Errorf("lost track of PID %d", root), syscalls: t.summary()}
+	}
+}
+
+// seize hands the root over from PTRACE_TRACEME, which only stops it after exec,
+// to PTRACE_SEIZE, which tells group-stops apart so they can be kept. The root
+// waits in a SIGSTOP in between and is left in a PTRACE_EVENT_STOP, still stopped.
+func seize(root int) error {
+	// Detaching with SIGSTOP leaves it stopped as an ordinary child
+	if err := ptrace(unix.PTRACE_DETACH, root, uintptr(unix.SIGSTOP)); err != nil {
+		return fmt.Errorf("failed to detach from PID %d: %v", root, err)
+	}
+	var status unix.WaitStatus
+	if _, err := unix.Wait4(root, &status, unix.WUNTRACED|unix.WALL, nil); err != nil || !status.Stopped() {
+		return fmt.Errorf("failed to trace PID %d: it did not stop after detaching", root)
+	}
+	if err := ptrace(unix.PTRACE_SEIZE, root, traceOptions); err != nil {
+		return fmt.Errorf("failed to seize PID %d: %v", root, err)
+	}
+	// A stopped process reports its group-stop to the new tracer
+	if _, err := unix.Wait4(root, &status, unix.WALL, nil); err != nil || int(status)>>16 != unix.PTRACE_EVENT_STOP {
+		return fmt.Errorf("failed to trace PID %d: it did not stop after seizing it", root)
+	}
+	return nil
+}
+
+// stopped handles a tracee stop and resumes the tracee, unless it is in a group-stop
+func (t *syscallTracer) stopped(tid int, status unix.WaitStatus) {
+	signal := status.StopSignal()
+	event := int(status) >> 16
+
+	thread, known := t.threads[tid]
+	if !known {
+		// Its first stop can overtake the fork event of its parent
+		thread = t.addThread(tid)
+	}
+
+	switch {
+	case signal == syscallStopSignal:
+		t.syscallStop(tid, thread)
+		signal = 0
+	case event == unix.PTRACE_EVENT_STOP:
+		if isStopSignal(signal) {
+			// A group-stop: the tracee stays stopped until SIGCONT, which reports it again
+			ptrace(unix.PTRACE_LISTEN, tid, 0)
+			return
+		}
+		// A new tracee, or the end of a group-stop
+		signal = 0
+	case signal == unix.SIGTRAP && event > 0:
+		t.eventStop(tid, thread, event)
+		signal = 0
+	case signal == unix.SIGCONT && thread.swallow:
+		thread.swallow = false
+		signal = 0
+	}
+	unix.PtraceSyscall(tid, int(signal))
+}
+
+// isStopSignal reports whether a signal starts a group-stop
+func isStopSignal(signal syscall.Signal) bool {
+	return signal == unix.SIGSTOP || signal == unix.SIGTSTP || signal == unix.SIGTTIN || signal == unix.SIGTTOU
+}
+
+// syscallStop records the entry to or the exit from a system call
+func (t *syscallTracer) syscallStop(tid int, thread *tracedThread) {
+	var info ptraceSyscallInfo
+	if err := ptraceRequest(unix.PTRACE_GET_SYSCALL_INFO, tid, unsafe.Pointer(&info), unsafe.Sizeof(info)); err != nil {
+		return
+	}
+
+	switch info.Op {
+	case unix.PTRACE_SYSCALL_INFO_ENTRY:
+		thread.inCall = true
+		thread.counted = thread.process.counting
+		thread.number = int(info.Data[0])
+		copy(thread.args[:], info.Data[1:7])
+		thread.entered = time.Now()
+		if info.Arch != seccompAuditArch {
+			// Calls of another ABI, such as 32-bit compat, have other numbers
+			thread.number = -1
+		}
+
+	case unix.PTRACE_SYSCALL_INFO_EXIT:
+		if !thread.inCall {
+			return
+		}
+		result := int64(info.Data[0])
+		isError := *(*uint8)(unsafe.Pointer(&info.Data[1])) != 0
+		t.finishCall(tid, thread, result, isError, true)
+	}
+}
+
+// finishCall counts and logs the call in progress. Calls that never return,
+// such as exit_group, are finished when the thread exits.
+func (t *syscallTracer) finishCall(tid int, thread *tracedThread, result int64, isError bool, returned bool) {
+	thread.inCall = false
+	elapsed := time.Since(thread.entered)
+	name := t.name(thread.number)
+
+	if thread.counted {
+		stat := thread.process.calls[thread.number]
+		if stat == nil {
+			stat = &SyscallStat{Number: thread.number, Name: name}
+			thread.process.calls[thread.number] = stat
+		}
+		stat.Calls++
+		stat.Time += elapsed
+		if isError {
+			stat.Errors++
+		}
+	}
+
+	if t.log == nil {
+		return
+	}
+	fmt.Fprintf(t.log, "%s [pid %d] %s(%#x, %#x, %#x, %#x, %#x, %#x)", thread.entered.Format("15:04:05.000000"), tid, name,
+		thread.args[0], thread.args[1], thread.args[2], thread.args[3], thread.args[4], thread.args[5])
+	switch {
+	case !returned:
+		fmt.Fprintf(t.log, " = ?\n")
+	case isError:
+		fmt.Fprintf(t.log, " = -1 %s <%.6f>\n", unix.ErrnoName(syscall.Errno(-result)), elapsed.Seconds())
+	default:
+		fmt.Fprintf(t.log, " = %d <%.6f>\n", result, elapsed.Seconds())
+	}
+}
+
+// eventStop follows new tracees and execs
+func (t *syscallTracer) eventStop(tid int, thread *tracedThread, event int) {
+	message, err := unix.PtraceGetEventMsg(tid)
+	if err != nil {
+		return
+	}
+
+	switch event {
+	case unix.PTRACE_EVENT_FORK, unix.PTRACE_EVENT_VFORK, unix.PTRACE_EVENT_CLONE:
+		child := int(message)
+		added, known := t.threads[child]
+		if !known {
+			added = t.addThread(child)
+		}
+		// KernelScope's own processes stay out of the tree
+		if added.process.pid == child {
+			added.process.counting = thread.process.counting
+		}
+		if added.process.pid == child && added.process.counting {
+			procevents.Send(t.events, procevents.Event{Kind: procevents.Fork, Time: time.Now(), Pid: child, PPid: thread.process.pid})
+		}
+
+	case unix.PTRACE_EVENT_EXEC:
+		// A thread other than the leader that execs takes over the leader's ID
+		if former := int(message); former != tid {
+			if moved, ok := t.threads[former]; ok {
+				delete(t.threads, former)
+				t.threads[tid] = moved
+				thread = moved
+			}
+		}
+		// The sandbox's init starts the helper again before the binary
+		thread.process.counting = !t.fromExec || !execsHelper(tid)
+		if stats, err := utils.ReadProcStats(tid); err == nil {
+			thread.process.comm = stats.Comm
+		}
+		if thread.process.counting {
+			procevents.Send(t.events, procevents.ExecEvent(tid))
+		}
+
+	case unix.PTRACE_EVENT_EXIT:
+		// The process is still intact, so its final usage can be read
+		if tid == thread.process.pid {
+			t.processExited(thread.process, unix.WaitStatus(message))
+		}
+	}
+}
+
+// execsHelper reports whether a tracee has just executed KernelScope itself
+func execsHelper(tid int) bool {
+	exe, err := os.Stat(fmt.Sprintf("/proc/%d/exe", tid))
+	if err != nil {
+		return false
+	}
+	self, err := os.Stat("/proc/self/exe")
+	return err == nil && os.SameFile(exe, self)
+}
+
+// addThread starts tracking a new tracee, and its process if the tracee is the first of it
+func (t *syscallTracer) addThread(tid int) *tracedThread {
+	pid, err := utils.ReadTgid(tid)
+	if err != nil {
+		pid = tid
+	}
+
+	process, ok := t.processes[pid]
+	if !ok {
+		process = &tracedProcess{pid: pid, counting: true, calls: make(map[int]*SyscallStat)}
+		if stats, err := utils.ReadProcStats(pid); err == nil {
+			process.comm = stats.Comm
+		}
+		t.processes[pid] = process
+		t.order = append(t.order, process)
+	}
+
+	thread := &tracedThread{process: process}
+	t.threads[tid] = thread
+	return thread
+}
+
+// threadExited stops tracking a tracee that has exited
+func (t *syscallTracer) threadExited(tid int, status unix.WaitStatus) {
+	thread, ok := t.threads[tid]
+	if !ok {
+		return
+	}
+	if thread.inCall {
+		t.finishCall(tid, thread, 0, false, false)
+	}
+	// SIGKILL can skip the exit stop
+	if tid == thread.process.pid {
+		t.processExited(thread.process, status)
+	}
+	delete(t.threads, tid)
+}
+
+// processExited reports the exit of a process once, unless it never ran the binary
+func (t *syscallTracer) processExited(process *tracedProcess, status unix.WaitStatus) {
+	if process.exited {
+		return
+	}
+	process.exited = true
+
+	if !process.counting {
+		return
+	}
+
+	exitCode, signal := -1, 0
+	if status.Exited() {
+		exitCode = status.ExitStatus()
+	} else if status.Signaled() {
+		signal = int(status.Signal())
+	}
+	procevents.Send(t.events, procevents.ExitEvent(process.pid, exitCode, signal))
+}
+
+// name names a system call number of the native ABI
+func (t *syscallTracer) name(number int) string {
+	if number < 0 {
+		return "unknown"
+	}
+	return syscallName(number)
+}
+
+// summary copies the counts gathered so far
+func (t *syscallTracer) summary() *SyscallSummary {
+	summary := &SyscallSummary{}
+	totals := make(map[int]*SyscallStat)
+
+	for _, process := range t.order {
+		if len(process.calls) == 0 {
+			continue
+		}
+		entry := ProcessSyscalls{Pid: process.pid, Comm: process.comm}
+		for number, stat := range process.calls {
+			entry.Syscalls = append(entry.Syscalls, *stat)
+
+			total := totals[number]
+			if total == nil {
+				total = &SyscallStat{Number: stat.Number, Name: stat.Name}
+				totals[number] = total
+			}
+			total.Calls += stat.Calls
+			total.Errors += stat.Errors
+			total.Time += stat.Time
+		}
+		sortSyscalls(entry.Syscalls)
+		summary.Processes = append(summary.Processes, entry)
+	}
+
+	for _, total := range totals {
+		summary.Syscalls = append(summary.Syscalls, *total)
+	}
+	sortSyscalls(summary.Syscalls)
+	return summary
+}
+
+// closeLog flushes and closes the trace log
+func (t *syscallTracer) closeLog() {
+	if t.logFile == nil {
+		return
+	}
+	if err := t.log.Flush(); err != nil {
+		fmt.Printf("Warning: Failed to write trace log: %v\n", err)
+	}
+	t.logFile.Close()
+}
+
+// ptrace issues a ptrace request that passes a value in data
+func ptrace(request int, pid int, data uintptr) error {
+	_, _, errno := unix.Syscall6(unix.SYS_PTRACE, uintptr(request), uintptr(pid), 0, data, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// ptraceRequest issues a ptrace request that passes a buffer in data
+func ptraceRequest(request int, pid int, data unsafe.Pointer, size uintptr) error {
+	_, _, errno := unix.Syscall6(unix.SYS_PTRACE, uintptr(request), uintptr(pid), size, uintptr(data), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package executor
+
+import (
+	"fmt"
+	"kernelscope/procevents"
+	"os/exec"
+)
+
+// startTraced is unavailable because the tracer uses Linux ptrace requests
+func startTraced(cmd *exec.Cmd, logPath string, fromExec bool) (<-chan traceResult, <-chan procevents.Event, error) {
+	return nil, nil, fmt.Errorf("--trace-syscalls requires Linux")
+}
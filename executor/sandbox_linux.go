@@ -0,0 +1,851 @@
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"kernelscope/cli"
+	"kernelscope/workspace"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// SandboxInitArg is the argument KernelScope is re-executed with to set up a sandbox
+const SandboxInitArg = "__sandbox-init"
+
+// sandboxSpecEnv carries the sandbox description from KernelScope to the init helper
+const sandboxSpecEnv = "KERNELSCOPE_SANDBOX"
+
+// sandboxSetupFailed is the exit code of the init helper when the sandbox cannot be built
+const sandboxSetupFailed = 125
+
+// helperSocketFd is where the init helper finds the socket that carries
+// descriptors back to KernelScope
+const helperSocketFd = 3
+
+// helperHandoffTimeout bounds how long KernelScope waits for a descriptor from the helper
+const helperHandoffTimeout = 10 * time.Second
+
+// Tags of the descriptors the init helper sends, in the order they are sent
+const (
+	helperWorkspaceRoot = 'w' // Directory of the sandbox's tmpfs workspace
+	helperBinaryProcess = 'p' // Pidfd of the process the binary runs in, below the sandbox's init
+)
+
+// forwardedSignals are passed on by the sandbox's init to the binary
+var forwardedSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// sandboxHostname is the hostname inside the sandbox
+const sandboxHostname = "kernelscope"
+
+// sandboxSystemPaths are bind-mounted read-only so ordinary binaries can run
+var sandboxSystemPaths = []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libx32", "/etc"}
+
+// sandboxDevices are the device nodes made available in the sandbox's /dev
+var sandboxDevices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// bindMount is a host path mounted into the sandbox
+type bindMount struct {
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+// sandboxSpec tells the init helper how to build the sandbox and what to run in it
+type sandboxSpec struct {
+	Binary     string          `json:"binary"`
+	Args       []string        `json:"args"`
+	Env        []string        `json:"env"`
+	Namespaces bool            `json:"namespaces"` // Build the sandbox filesystem in new namespaces
+	Binds      []bindMount     `json:"binds"`
+	Network    bool            `json:"network"` // Share the host network
+	Workdir    string          `json:"workdir"`
+	Seccomp    *seccompPolicy  `json:"seccomp,omitempty"`
+	Landlock   *landlockPolicy `json:"landlock,omitempty"`
+	Drop       credentials     `json:"drop"` // Identity to switch to, if any, after giving up every capability
+	Workspace  *tmpfsWorkspace `json:"workspace,omitempty"`
+	HoldFd     int             `json:"hold_fd,omitempty"` // Pipe to wait on before exec, 0 when not held
+	Reaped     bool            `json:"reaped,omitempty"`  // Started by the sandbox's init, reports its process first
+}
+
+// tmpfsWorkspace is a size-capped workspace built inside the sandbox
+type tmpfsWorkspace struct {
+	Path       string `json:"path"`
+	SizeKB     int64  `json:"size_kb"`
+	Template   string `json:"template,omitempty"`    // Host directory copied into the workspace
+	TemplateFd int    `json:"template_fd,omitempty"` // Open directory copied instead, 0 when unset
+}
+
+// sandboxCommand builds the command that starts the binary through the init helper.
+// KernelScope re-executes itself to set up the namespaces and the seccomp filter, then
+// execs the binary in place, so the binary keeps the PID KernelScope monitors. In a
+// sandbox the helper stays as PID 1 to reap orphans, and the binary runs in a child
+// whose PID the hook reports. The returned hook must be called once the command was
+// started, or with nil if it failed.
+func sandboxCommand(config *cli.Config, env []string, identity Identity, ws *workspace.Workspace) (*exec.Cmd, func(*Process), error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to locate the kernelscope executable: %v", err)
+	}
+
+	binary := config.BinaryPath
+	if !strings.Contains(binary, "/") {
+		if binary, err = exec.LookPath(binary); err != nil {
+			return nil, nil, err
+		}
+	}
+	if binary, err = filepath.Abs(binary); err != nil {
+		return nil, nil, err
+	}
+
+	if env == nil {
+		env = os.Environ()
+	}
+	spec := sandboxSpec{
+		Binary:     binary,
+		Args:       config.Args,
+		Env:        env,
+		Namespaces: config.Sandbox,
+		Network:    config.SandboxNetwork,
+		Workdir:    "/tmp",
+	}
+
+	if config.SeccompProfile != "" {
+		if spec.Seccomp, err = loadSeccompPolicy(config.SeccompProfile); err != nil {
+			return nil, nil, err
+		}
+	}
+	// The binary never keeps capabilities, so root in the sandbox cannot remount the
+	// read-only binds and root outside it cannot undo seccomp or Landlock's intent
+	spec.Drop = credentials{Uid: identity.Uid, Gid: identity.Gid, Groups: identity.Groups, SetIDs: config.DropsPrivileges() && !config.Sandbox}
+	if config.UsesLandlock() {
+		if spec.Landlock, err = landlockPolicyFor(binary, config.AllowRead, config.AllowWrite, config.AllowExec); err != nil {
+			return nil, nil, err
+		}
+		// A tmpfs workspace only exists inside the sandbox
+		if spec.Landlock != nil && ws != nil {
+			spec.Landlock.Rules = append(spec.Landlock.Rules, landlockRule{Path: ws.Dir, Access: landlockWrite, Inside: config.Sandbox})
+		}
+	}
+
+	for _, value := range config.SandboxReadOnly {
+		bind, err := parseBind(value, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		spec.Binds = append(spec.Binds, bind)
+	}
+	for _, value := range config.SandboxReadWrite {
+		bind, err := parseBind(value, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		spec.Binds = append(spec.Binds, bind)
+	}
+
+	// Make sure the binary itself is visible
+	if !sandboxCovers(spec.Binds, binary) {
+		spec.Binds = append(spec.Binds, bindMount{Source: binary, Target: binary, ReadOnly: true})
+	}
+
+	// Keep the working directory if it was mounted into the sandbox
+	if cwd, err := os.Getwd(); err == nil && sandboxCovers(spec.Binds, cwd) {
+		spec.Workdir = cwd
+	}
+
+	// The workspace appears at the same path inside the sandbox
+	var seed *os.File
+	if ws != nil && config.Sandbox {
+		spec.Workdir = ws.Dir
+		if config.WorkspaceSizeKB > 0 {
+			spec.Workspace = &tmpfsWorkspace{Path: ws.Dir, SizeKB: config.WorkspaceSizeKB}
+			if ws.Root != ws.Dir {
+				// A command run after the binary starts from a copy of the files it left
+				if seed, err = os.Open(ws.Root); err != nil {
+					return nil, nil, fmt.Errorf("failed to open the workspace: %v", err)
+				}
+			} else if config.WorkspaceTemplate != "" {
+				if spec.Workspace.Template, err = filepath.Abs(config.WorkspaceTemplate); err != nil {
+					return nil, nil, err
+				}
+			}
+		} else {
+			spec.Binds = append(spec.Binds, bindMount{Source: ws.Dir, Target: ws.Dir})
+		}
+	}
+
+	cmd := exec.Command(self, SandboxInitArg)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+
+	if config.Sandbox {
+		cloneflags := uintptr(syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC)
+		if !config.SandboxNetwork {
+			cloneflags |= syscall.CLONE_NEWNET
+		}
+		cmd.SysProcAttr.Cloneflags = cloneflags
+		// Root inside the sandbox is the invoking user, or the --user identity, outside it
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: identity.Uid, Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: identity.Gid, Size: 1}}
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+		// Become that root before the helper runs, or it would hold no capabilities in the namespace
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0, NoSetGroups: true}
+	}
+
+	// The helper sends descriptors back over a socket: the tmpfs workspace, so its
+	// files can be collected after the sandbox is gone, and the binary's process in
+	// a sandbox
+	var parent, child *os.File
+	if spec.Namespaces || spec.Workspace != nil {
+		if parent, child, err = helperSocketPair(); err != nil {
+			return nil, nil, err
+		}
+		cmd.ExtraFiles = append(cmd.ExtraFiles, child)
+	}
+	if seed != nil {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, seed)
+		spec.Workspace.TemplateFd = helperSocketFd + len(cmd.ExtraFiles) - 1
+	}
+
+	// The helper waits on a pipe before exec until KernelScope has applied the
+	// limits the kernel enforces, so the binary never runs outside them
+	var holdRead, holdWrite *os.File
+	if holdsForLimits(config) {
+		if holdRead, holdWrite, err = os.Pipe(); err != nil {
+			if parent != nil {
+				parent.Close()
+				child.Close()
+			}
+			if seed != nil {
+				seed.Close()
+			}
+			return nil, nil, fmt.Errorf("failed to create hold pipe: %v", err)
+		}
+		cmd.ExtraFiles = append(cmd.ExtraFiles, holdRead)
+		spec.HoldFd = helperSocketFd + len(cmd.ExtraFiles) - 1
+	}
+
+	encoded, err := json.Marshal(spec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode sandbox spec: %v", err)
+	}
+	cmd.Env = append(os.Environ(), sandboxSpecEnv+"="+string(encoded))
+
+	started := func(process *Process) {
+		for _, file := range cmd.ExtraFiles {
+			file.Close()
+		}
+		if process == nil {
+			if parent != nil {
+				parent.Close()
+			}
+			if holdWrite != nil {
+				holdWrite.Close()
+			}
+			return
+		}
+		if holdWrite != nil {
+			process.release = func() {
+				holdWrite.Write([]byte{0})
+				holdWrite.Close()
+			}
+		}
+		// The binary's process, which a sandbox's helper sends before installing any filter
+		pidfd := -1
+		if parent != nil {
+			conn, err := net.FileConn(parent)
+			parent.Close()
+			if err != nil {
+				fmt.Printf("Warning: failed to talk to the init helper: %v\n", err)
+			} else {
+				unixConn := conn.(*net.UnixConn)
+				if spec.Workspace != nil {
+					fd, err := receiveFd(unixConn, helperWorkspaceRoot)
+					if err != nil {
+						fmt.Printf("Warning: workspace files will not be collected: %v\n", err)
+					} else {
+						ws.Attach(os.NewFile(uintptr(fd), ws.Dir))
+					}
+				}
+
+				// Monitor and limit the binary rather than the sandbox's init
+				if spec.Namespaces {
+					pid, fd, err := receiveProcess(unixConn)
+					if err != nil {
+						fmt.Printf("Warning: monitoring the sandbox's init instead of the binary: %v\n", err)
+					} else {
+						process.Pid, pidfd = pid, fd
+						fmt.Printf("Binary runs with PID %d under the sandbox's init\n", pid)
+					}
+				}
+				unixConn.Close()
+			}
+		}
+
+		if spec.Seccomp == nil {
+			if pidfd >= 0 {
+				unix.Close(pidfd)
+			}
+			return
+		}
+		if !spec.Namespaces {
+			if fd, err := unix.PidfdOpen(process.Pid, 0); err != nil {
+				fmt.Printf("Warning: failed to open pidfd: %v\n", err)
+			} else {
+				pidfd = fd
+			}
+		}
+		if pidfd < 0 {
+			// The binary cannot exec until the supervisor answers
+			fmt.Printf("Warning: terminating the binary, seccomp cannot be supervised\n")
+			process.Cmd.Process.Kill()
+			return
+		}
+		violations := make(chan SyscallViolation, 16)
+		process.Violations = violations
+		go superviseSeccomp(pidfd, process.Pid, spec.Seccomp, violations)
+	}
+	return cmd, started, nil
+}
+
+// holdsForLimits reports whether the binary must wait before exec until the
+// thread, fd, process and CPU rate limits are applied to it
+func holdsForLimits(config *cli.Config) bool {
+	return config.MaxThreads > 0 || config.MaxFDs > 0 || config.MaxProcs > 0 || config.CpuRate > 0
+}
+
+// helperSocketPair creates the socket pair the init helper sends descriptors over
+func helperSocketPair() (parent, child *os.File, err error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create helper socket: %v", err)
+	}
+	return os.NewFile(uintptr(fds[0]), "helper-parent"), os.NewFile(uintptr(fds[1]), "helper-child"), nil
+}
+
+// sendFd sends a descriptor to KernelScope, tagged with what it is
+func sendFd(fd int, tag byte) error {
+	return unix.Sendmsg(helperSocketFd, []byte{tag}, unix.UnixRights(fd), nil, 0)
+}
+
+// receiveFd reads the next descriptor sent by the init helper, which must carry the given tag
+func receiveFd(conn *net.UnixConn, tag byte) (int, error) {
+	conn.SetReadDeadline(time.Now().Add(helperHandoffTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 1)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return -1, fmt.Errorf("failed to receive from the init helper: %v", err)
+	}
+	messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(messages) == 0 {
+		return -1, fmt.Errorf("init helper exited during setup")
+	}
+	fds, err := unix.ParseUnixRights(&messages[0])
+	if err != nil || len(fds) == 0 {
+		return -1, fmt.Errorf("no descriptor received from the init helper")
+	}
+	if n != 1 || buf[0] != tag {
+		unix.Close(fds[0])
+		return -1, fmt.Errorf("unexpected descriptor from the init helper")
+	}
+	return fds[0], nil
+}
+
+// receiveProcess reads the pidfd of the binary's process and returns its PID in
+// KernelScope's namespace along with the pidfd
+func receiveProcess(conn *net.UnixConn) (int, int, error) {
+	fd, err := receiveFd(conn, helperBinaryProcess)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	data, err := os.ReadFile(fmt.Sprintf("/proc/self/fdinfo/%d", fd))
+	if err != nil {
+		unix.Close(fd)
+		return -1, -1, fmt.Errorf("failed to read pidfd: %v", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, found := strings.CutPrefix(line, "Pid:"); found {
+			if pid, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && pid > 0 {
+				return pid, fd, nil
+			}
+		}
+	}
+	unix.Close(fd)
+	return -1, -1, fmt.Errorf("the binary's process has already exited")
+}
+
+// parseBind parses a bind mount given as path or src:dst
+func parseBind(value string, readOnly bool) (bindMount, error) {
+	source, target, found := strings.Cut(value, ":")
+	if !found {
+		target = source
+	}
+
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return bindMount{}, err
+	}
+	if _, err := os.Stat(source); err != nil {
+		return bindMount{}, fmt.Errorf("bind source %s: %v", source, err)
+	}
+	if !filepath.IsAbs(target) {
+		return bindMount{}, fmt.Errorf("bind target %s must be an absolute path", target)
+	}
+	return bindMount{Source: source, Target: filepath.Clean(target), ReadOnly: readOnly}, nil
+}
+
+// sandboxCovers reports whether a path is visible inside the sandbox at the same location
+func sandboxCovers(binds []bindMount, path string) bool {
+	for _, dir := range sandboxSystemPaths {
+		if isWithin(path, dir) {
+			return true
+		}
+	}
+	for _, bind := range binds {
+		if bind.Source == bind.Target && isWithin(path, bind.Target) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWithin reports whether path is dir or inside it
+func isWithin(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// SandboxInit runs as PID 1 inside the new namespaces: it builds the sandbox's
+// filesystem and starts itself again in a child, which it reaps along with every
+// orphan. The child, or the helper itself without a sandbox, drops privileges,
+// applies Landlock, waits until KernelScope has applied the resource limits and then
+// installs the seccomp filter and replaces itself with the binary. It never returns.
+func SandboxInit() {
+	// Landlock and seccomp only restrict the thread that applies them, which must also exec
+	runtime.LockOSThread()
+
+	var spec sandboxSpec
+	if err := json.Unmarshal([]byte(os.Getenv(sandboxSpecEnv)), &spec); err != nil {
+		fmt.Fprintf(os.Stderr, "kernelscope sandbox: invalid spec: %v\n", err)
+		os.Exit(sandboxSetupFailed)
+	}
+
+	if spec.Namespaces {
+		// The host paths Landlock allows are out of reach once the sandbox is built
+		if spec.Landlock != nil {
+			if err := spec.Landlock.openHostPaths(); err != nil {
+				fmt.Fprintf(os.Stderr, "kernelscope sandbox: %v\n", err)
+				os.Exit(sandboxSetupFailed)
+			}
+		}
+		if err := setupSandbox(&spec); err != nil {
+			fmt.Fprintf(os.Stderr, "kernelscope sandbox: %v\n", err)
+			os.Exit(sandboxSetupFailed)
+		}
+	}
+
+	// Let KernelScope reach the tmpfs workspace after the sandbox is gone
+	if spec.Workspace != nil {
+		unix.CloseOnExec(helperSocketFd)
+		fd, err := unix.Open(spec.Workspace.Path, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+		if err == nil {
+			err = sendFd(fd, helperWorkspaceRoot)
+			unix.Close(fd)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kernelscope sandbox: failed to hand over the workspace: %v\n", err)
+			os.Exit(sandboxSetupFailed)
+		}
+	}
+
+	if spec.Namespaces {
+		reapSandbox(&spec)
+	}
+
+	// Tell KernelScope which process the binary is going to run in
+	if spec.Reaped {
+		if err := reportProcess(); err != nil {
+			fmt.Fprintf(os.Stderr, "kernelscope sandbox: %v\n", err)
+			os.Exit(sandboxSetupFailed)
+		}
+	}
+
+	if err := dropPrivileges(&spec.Drop); err != nil {
+		fmt.Fprintf(os.Stderr, "kernelscope sandbox: %v\n", err)
+		os.Exit(sandboxSetupFailed)
+	}
+
+	if spec.Landlock != nil {
+		if err := applyLandlock(spec.Landlock); err != nil {
+			fmt.Fprintf(os.Stderr, "kernelscope sandbox: %v\n", err)
+			os.Exit(sandboxSetupFailed)
+		}
+	}
+
+	// Last, as pids.max may already leave no room for the helper's threads
+	if spec.HoldFd != 0 {
+		if err := awaitRelease(spec.HoldFd); err != nil {
+			fmt.Fprintf(os.Stderr, "kernelscope sandbox: %v\n", err)
+			os.Exit(sandboxSetupFailed)
+		}
+	}
+
+	if spec.Seccomp != nil {
+		err := execWithSeccomp(&spec)
+		fmt.Fprintf(os.Stderr, "kernelscope sandbox: %v\n", err)
+		os.Exit(sandboxSetupFailed)
+	}
+
+	err := syscall.Exec(spec.Binary, append([]string{spec.Binary}, spec.Args...), spec.Env)
+	fmt.Fprintf(os.Stderr, "kernelscope sandbox: failed to execute %s: %v\n", spec.Binary, err)
+	os.Exit(127)
+}
+
+// reapSandbox starts the helper again to run the binary, forwards signals to it and
+// reaps every process of the sandbox until the binary exits, then exits with its
+// status. A binary killed by a signal is reported as 128 plus the signal number.
+func reapSandbox(spec *sandboxSpec) {
+	child := *spec
+	child.Namespaces = false
+	child.Workspace = nil
+	child.Reaped = true
+
+	// The socket and the hold pipe keep their descriptors in the child, the Landlock paths follow them
+	cmd := exec.Command("/proc/self/exe", SandboxInitArg)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{os.NewFile(helperSocketFd, "helper")}
+	if spec.HoldFd != 0 {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, os.NewFile(uintptr(spec.HoldFd), "hold"))
+	}
+	if spec.Landlock != nil {
+		landlock := *spec.Landlock
+		landlock.Rules = slices.Clone(spec.Landlock.Rules)
+		for i, rule := range landlock.Rules {
+			if rule.Fd != 0 {
+				cmd.ExtraFiles = append(cmd.ExtraFiles, os.NewFile(uintptr(rule.Fd), rule.Path))
+				landlock.Rules[i].Fd = helperSocketFd + len(cmd.ExtraFiles) - 1
+			}
+		}
+		child.Landlock = &landlock
+	}
+
+	encoded, err := json.Marshal(child)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kernelscope sandbox: failed to encode spec: %v\n", err)
+		os.Exit(sandboxSetupFailed)
+	}
+	cmd.Env = append(os.Environ(), sandboxSpecEnv+"="+string(encoded))
+
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals, forwardedSignals...)
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "kernelscope sandbox: failed to start the binary's process: %v\n", err)
+		os.Exit(sandboxSetupFailed)
+	}
+	for _, file := range cmd.ExtraFiles {
+		file.Close()
+	}
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	for {
+		var status unix.WaitStatus
+		pid, err := unix.Wait4(-1, &status, 0, nil)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			os.Exit(sandboxSetupFailed)
+		}
+		if pid != cmd.Process.Pid {
+			continue
+		}
+		// Leaving the namespace kills whatever the binary left behind
+		if status.Signaled() {
+			os.Exit(128 + int(status.Signal()))
+		}
+		os.Exit(status.ExitStatus())
+	}
+}
+
+// reportProcess sends a pidfd of the calling process to KernelScope. The socket is not passed on to the binary.
+func reportProcess() error {
+	unix.CloseOnExec(helperSocketFd)
+	fd, err := unix.PidfdOpen(os.Getpid(), 0)
+	if err != nil {
+		return fmt.Errorf("failed to open pidfd: %v", err)
+	}
+	defer unix.Close(fd)
+	if err := sendFd(fd, helperBinaryProcess); err != nil {
+		return fmt.Errorf("failed to report the binary's process: %v", err)
+	}
+	return nil
+}
+
+// awaitRelease blocks until KernelScope has applied the limits and writes to the hold pipe
+func awaitRelease(fd int) error {
+	defer unix.Close(fd)
+	buf := make([]byte, 1)
+	for {
+		n, err := unix.Read(fd, buf)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to wait for resource limits: %v", err)
+		}
+		if n == 0 {
+			return fmt.Errorf("kernelscope exited before applying resource limits")
+		}
+		return nil
+	}
+}
+
+// setupSandbox builds a new root filesystem containing only the system paths,
+// the requested binds, a private /tmp, /proc and a minimal /dev, and pivots into it
+func setupSandbox(spec *sandboxSpec) error {
+	// Keep every mount change inside this namespace
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %v", err)
+	}
+
+	// Stage on a tmpfs so the host root stays reachable under /oldroot while the new root is filled
+	if err := unix.Mount("tmpfs", "/tmp", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=0755"); err != nil {
+		return fmt.Errorf("failed to mount staging tmpfs: %v", err)
+	}
+	for _, dir := range []string{"/tmp/oldroot", "/tmp/newroot"} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := unix.PivotRoot("/tmp", "/tmp/oldroot"); err != nil {
+		return fmt.Errorf("failed to pivot to the staging root: %v", err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return err
+	}
+
+	const root = "/newroot"
+	if err := unix.Mount("tmpfs", root, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=0755"); err != nil {
+		return fmt.Errorf("failed to mount sandbox root: %v", err)
+	}
+
+	// System paths, keeping symlinks such as /bin -> usr/bin
+	for _, path := range sandboxSystemPaths {
+		info, err := os.Lstat("/oldroot" + path)
+		if err != nil {
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink("/oldroot" + path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(target, root+path); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := bindInto(root, bindMount{Source: path, Target: path, ReadOnly: true}); err != nil {
+			return err
+		}
+	}
+
+	// Private /tmp
+	if err := os.MkdirAll(root+"/tmp", 0755); err != nil {
+		return err
+	}
+	if err := unix.Mount("tmpfs", root+"/tmp", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("failed to mount /tmp: %v", err)
+	}
+
+	// Requested binds, after /tmp so they can be placed inside it
+	for _, bind := range spec.Binds {
+		if err := bindInto(root, bind); err != nil {
+			return err
+		}
+	}
+
+	// Size-capped workspace, seeded from the template while the host root is still reachable
+	if ws := spec.Workspace; ws != nil {
+		if err := os.MkdirAll(root+ws.Path, 0755); err != nil {
+			return err
+		}
+		if err := unix.Mount("tmpfs", root+ws.Path, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, fmt.Sprintf("size=%dk,mode=0755", ws.SizeKB)); err != nil {
+			return fmt.Errorf("failed to mount the workspace: %v", err)
+		}
+		template := ws.Template
+		if ws.TemplateFd != 0 {
+			// The trailing slash follows the descriptor's link to the directory
+			template = fmt.Sprintf("/proc/self/fd/%d/", ws.TemplateFd)
+		}
+		if template != "" {
+			if err := workspace.CopyTree("/oldroot"+template, root+ws.Path); err != nil {
+				return fmt.Errorf("failed to seed the workspace: %v", err)
+			}
+		}
+	}
+
+	// /proc for the new PID namespace
+	if err := os.MkdirAll(root+"/proc", 0755); err != nil {
+		return err
+	}
+	if err := unix.Mount("proc", root+"/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("failed to mount /proc: %v", err)
+	}
+
+	if err := setupDev(root); err != nil {
+		return err
+	}
+
+	// Replace the staging root with the new one and drop the host root
+	if err := os.Chdir(root); err != nil {
+		return err
+	}
+	if err := unix.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("failed to pivot to the sandbox root: %v", err)
+	}
+	if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to detach the host root: %v", err)
+	}
+	if err := os.Chdir(spec.Workdir); err != nil {
+		return fmt.Errorf("failed to enter working directory: %v", err)
+	}
+
+	if err := unix.Sethostname([]byte(sandboxHostname)); err != nil {
+		return fmt.Errorf("failed to set hostname: %v", err)
+	}
+
+	// A new network namespace only has a loopback interface, and it starts down
+	if !spec.Network {
+		if err := bringUpLoopback(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindInto bind-mounts a host path, found under /oldroot, into the new root
+func bindInto(root string, bind bindMount) error {
+	source := "/oldroot" + bind.Source
+	target := root + bind.Target
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("bind source %s: %v", bind.Source, err)
+	}
+
+	// The mount point must exist and be of the same kind as the source
+	if info.IsDir() {
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		file.Close()
+	}
+
+	if err := unix.Mount(source, target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to bind %s: %v", bind.Source, err)
+	}
+	if bind.ReadOnly {
+		if err := remountReadOnly(target); err != nil {
+			return fmt.Errorf("failed to make %s read-only: %v", bind.Source, err)
+		}
+	}
+	return nil
+}
+
+// remountReadOnly makes a bind mount read-only. The flags locked by the user
+// namespace (nosuid, nodev, ...) must be kept or the kernel refuses the remount.
+func remountReadOnly(path string) error {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return err
+	}
+	locked := uintptr(st.Flags) & (unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC | unix.MS_NOATIME | unix.MS_NODIRATIME | unix.MS_RELATIME)
+	return unix.Mount("", path, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY|locked, "")
+}
+
+// setupDev creates a minimal /dev with the common device nodes bound from the host
+func setupDev(root string) error {
+	dev := root + "/dev"
+	if err := os.MkdirAll(dev, 0755); err != nil {
+		return err
+	}
+	if err := unix.Mount("tmpfs", dev, "tmpfs", unix.MS_NOSUID|unix.MS_NOEXEC, "mode=0755"); err != nil {
+		return fmt.Errorf("failed to mount /dev: %v", err)
+	}
+
+	for _, name := range sandboxDevices {
+		if _, err := os.Stat("/oldroot/dev/" + name); err != nil {
+			continue
+		}
+		if err := bindInto(root, bindMount{Source: "/dev/" + name, Target: "/dev/" + name}); err != nil {
+			return err
+		}
+	}
+
+	links := map[string]string{
+		"fd":     "/proc/self/fd",
+		"stdin":  "/proc/self/fd/0",
+		"stdout": "/proc/self/fd/1",
+		"stderr": "/proc/self/fd/2",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, dev+"/"+name); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Mkdir(dev+"/shm", 01777); err != nil {
+		return err
+	}
+	if err := unix.Mount("tmpfs", dev+"/shm", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("failed to mount /dev/shm: %v", err)
+	}
+	return nil
+}
+
+// bringUpLoopback sets the loopback interface of the new network namespace up
+func bringUpLoopback() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open control socket: %v", err)
+	}
+	defer unix.Close(fd)
+
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("failed to read loopback flags: %v", err)
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("failed to bring up loopback: %v", err)
+	}
+	return nil
+}
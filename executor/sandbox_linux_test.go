@@ -0,0 +1,58 @@
+package executor
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestReceiveProcess(t *testing.T) {
+	tests := []struct {
+		name    string
+		tag     byte
+		wantErr bool
+	}{
+		{name: "binary's process", tag: helperBinaryProcess},
+		{name: "unexpected descriptor", tag: helperWorkspaceRoot, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parent, child, err := helperSocketPair()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer child.Close()
+			conn, err := net.FileConn(parent)
+			parent.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			pidfd, err := unix.PidfdOpen(os.Getpid(), 0)
+			if err != nil {
+				t.Skipf("pidfd_open is not available: %v", err)
+			}
+			defer unix.Close(pidfd)
+			if err := unix.Sendmsg(int(child.Fd()), []byte{test.tag}, unix.UnixRights(pidfd), nil, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			pid, fd, err := receiveProcess(conn.(*net.UnixConn))
+			if test.wantErr {
+				if err == nil {
+					unix.Close(fd)
+					t.Errorf("receiveProcess = %d, want an error", pid)
+				}
+				return
+			}
+			if err != nil || pid != os.Getpid() {
+				t.Fatalf("receiveProcess = %d, %v; want %d", pid, err, os.Getpid())
+			}
+			unix.Close(fd)
+		})
+	}
+}
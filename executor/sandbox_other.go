@@ -0,0 +1,33 @@
+//go:build !linux
+
+package executor
+
+import (
+	"fmt"
+	"kernelscope/cli"
+	"kernelscope/workspace"
+	"os/exec"
+)
+
+// SandboxInitArg is the argument KernelScope is re-executed with to set up a sandbox
+const SandboxInitArg = "__sandbox-init"
+
+// sandboxCommand is unavailable because the init helper is Linux-specific
+func sandboxCommand(config *cli.Config, env []string, identity Identity, ws *workspace.Workspace) (*exec.Cmd, func(*Process), error) {
+	return nil, nil, fmt.Errorf("sandbox mode, seccomp, Landlock and --user require Linux")
+}
+
+// holdsForLimits is false outside Linux, where the limits are only polled
+func holdsForLimits(config *cli.Config) bool {
+	return false
+}
+
+// copyTreeAs copies as KernelScope outside Linux, where --user is refused anyway
+func copyTreeAs(id Identity, src, dst string) error {
+	return workspace.CopyTree(src, dst)
+}
+
+// SandboxInit is never reached outside Linux
+func SandboxInit() {
+	panic("sandbox mode requires Linux")
+}
@@ -0,0 +1,443 @@
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Actions a seccomp rule can take
+const (
+	seccompAllow     = unix.SECCOMP_RET_ALLOW
+	seccompViolation = unix.SECCOMP_RET_USER_NOTIF // Reported to KernelScope, which terminates the tree
+	seccompNoSys     = unix.SECCOMP_RET_ERRNO | uint32(unix.ENOSYS)
+)
+
+// Argument checks a seccomp rule can make before it applies
+const (
+	checkNone      = iota
+	checkMaskClear // None of the bits in Value are set in the argument
+	checkMaskSet   // At least one bit in Value is set in the argument
+	checkEqual     // The low 32 bits of the argument equal Value
+)
+
+// seccompRule applies Action to a system call, optionally only when an argument passes a check
+type seccompRule struct {
+	Syscall int    `json:"syscall"`
+	Action  uint32 `json:"action"`
+	Arg     int    `json:"arg"`
+	Check   int    `json:"check"`
+	Value   uint64 `json:"value"`
+}
+
+// seccompPolicy is a resolved profile: rules are tried in order, then Default applies
+type seccompPolicy struct {
+	Name    string        `json:"name"`
+	Rules   []seccompRule `json:"rules"`
+	Default uint32        `json:"default"`
+}
+
+// seccompProfileFile is the format of custom profiles
+type seccompProfileFile struct {
+	Default string   `json:"default"` // "allow" or "deny"
+	Allow   []string `json:"allow"`
+	Deny    []string `json:"deny"`
+}
+
+// strictSyscalls are what a compute-only program needs: memory, signals, time,
+// threads and I/O on descriptors it already has
+var strictSyscalls = []string{
+	"read", "write", "readv", "writev", "pread64", "pwrite64", "preadv", "pwritev", "lseek",
+	"close", "close_range", "fstat", "newfstatat", "fstatat", "stat", "lstat", "statx", "fstatfs",
+	"access", "faccessat", "faccessat2", "readlink", "readlinkat", "getcwd", "getdents64",
+	"mmap", "munmap", "mprotect", "mremap", "brk", "madvise", "mlock", "munlock", "membarrier",
+	"rt_sigaction", "rt_sigprocmask", "rt_sigreturn", "rt_sigsuspend", "rt_sigtimedwait",
+	"sigaltstack", "restart_syscall", "tgkill", "tkill",
+	"exit", "exit_group", "futex", "futex_waitv", "set_tid_address", "set_robust_list",
+	"get_robust_list", "rseq", "arch_prctl", "sched_yield", "sched_getaffinity",
+	"sched_getparam", "sched_getscheduler",
+	"clock_gettime", "clock_getres", "clock_nanosleep", "nanosleep", "gettimeofday", "time",
+	"times", "getrusage", "getrandom", "uname", "prlimit64", "getrlimit",
+	"getpid", "gettid", "getppid", "getpgrp", "getuid", "geteuid", "getgid", "getegid",
+	"getresuid", "getresgid", "getgroups",
+	"ioctl", "fcntl", "dup", "dup2", "dup3", "pipe", "pipe2", "eventfd2",
+	"poll", "ppoll", "select", "pselect6", "epoll_create", "epoll_create1", "epoll_ctl",
+	"epoll_wait", "epoll_pwait", "epoll_pwait2",
+}
+
+// openWriteFlags are the open flags that could change the filesystem
+const openWriteFlags = unix.O_WRONLY | unix.O_RDWR | unix.O_CREAT | unix.O_TRUNC | unix.O_APPEND | (unix.O_TMPFILE &^ unix.O_DIRECTORY)
+
+// syscallNumbers maps system call names to numbers
+var syscallNumbers = func() map[string]int {
+	numbers := make(map[string]int, len(syscallNames))
+	for number, name := range syscallNames {
+		numbers[name] = number
+	}
+	return numbers
+}()
+
+// syscallName returns the name of a system call, or "unknown"
+func syscallName(number int) string {
+	if name, ok := syscallNames[number]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// loadSeccompPolicy resolves a built-in profile name or a custom profile file
+func loadSeccompPolicy(profile string) (*seccompPolicy, error) {
+	if seccompAuditArch == 0 {
+		return nil, fmt.Errorf("seccomp profiles are not supported on %s", runtime.GOARCH)
+	}
+
+	// rule returns a rule for a system call, or false if this architecture lacks it
+	rule := func(name string, action uint32) (seccompRule, bool) {
+		number, ok := syscallNumbers[name]
+		return seccompRule{Syscall: number, Action: action, Arg: -1}, ok
+	}
+	policy := &seccompPolicy{Name: profile}
+
+	switch profile {
+	case "strict":
+		// Reading files is fine, creating or changing them is not
+		if r, ok := rule("open", seccompAllow); ok {
+			r.Arg, r.Check, r.Value = 1, checkMaskClear, openWriteFlags
+			policy.Rules = append(policy.Rules, r)
+		}
+		if r, ok := rule("openat", seccompAllow); ok {
+			r.Arg, r.Check, r.Value = 2, checkMaskClear, openWriteFlags
+			policy.Rules = append(policy.Rules, r)
+		}
+		// Threads but not processes; clone3 cannot be inspected, so steer libc back to clone
+		if r, ok := rule("clone", seccompAllow); ok {
+			r.Arg, r.Check, r.Value = 0, checkMaskSet, unix.CLONE_THREAD
+			policy.Rules = append(policy.Rules, r)
+		}
+		if r, ok := rule("clone3", seccompNoSys); ok {
+			policy.Rules = append(policy.Rules, r)
+		}
+		for _, name := range strictSyscalls {
+			if r, ok := rule(name, seccompAllow); ok {
+				policy.Rules = append(policy.Rules, r)
+			}
+		}
+		policy.Default = seccompViolation
+
+	case "no-network":
+		// Unix sockets stay usable for local IPC
+		if r, ok := rule("socket", seccompAllow); ok {
+			r.Arg, r.Check, r.Value = 0, checkEqual, unix.AF_UNIX
+			policy.Rules = append(policy.Rules, r)
+		}
+		// io_uring can open sockets without calling socket()
+		for _, name := range []string{"socket", "io_uring_setup"} {
+			if r, ok := rule(name, seccompViolation); ok {
+				policy.Rules = append(policy.Rules, r)
+			}
+		}
+		policy.Default = seccompAllow
+
+	case "no-exec":
+		for _, name := range []string{"execve", "execveat"} {
+			if r, ok := rule(name, seccompViolation); ok {
+				policy.Rules = append(policy.Rules, r)
+			}
+		}
+		policy.Default = seccompAllow
+
+	default:
+		data, err := os.ReadFile(profile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read seccomp profile: %v", err)
+		}
+		var file seccompProfileFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse seccomp profile %s: %v", profile, err)
+		}
+
+		switch file.Default {
+		case "", "allow":
+			policy.Default = seccompAllow
+		case "deny":
+			policy.Default = seccompViolation
+		default:
+			return nil, fmt.Errorf("seccomp profile %s: default must be allow or deny, got %q", profile, file.Default)
+		}
+
+		// Denials win over allowances of the same system call
+		for _, list := range []struct {
+			names  []string
+			action uint32
+		}{{file.Deny, seccompViolation}, {file.Allow, seccompAllow}} {
+			for _, name := range list.names {
+				r, ok := rule(strings.TrimSpace(name), list.action)
+				if !ok {
+					return nil, fmt.Errorf("seccomp profile %s: unknown system call %q", profile, name)
+				}
+				policy.Rules = append(policy.Rules, r)
+			}
+		}
+	}
+
+	return policy, nil
+}
+
+// bpfStmt and bpfJump build classic BPF instructions
+func bpfStmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// Offsets into struct seccomp_data
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArgs = 16
+)
+
+// compileSeccomp turns rules into a BPF program. Unknown architectures and, on amd64,
+// x32 system calls are killed outright.
+func compileSeccomp(rules []seccompRule, defaultAction uint32) []unix.SockFilter {
+	const (
+		load = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+		jeq  = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+		jge  = unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K
+		jset = unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K
+		ret  = unix.BPF_RET | unix.BPF_K
+	)
+
+	program := []unix.SockFilter{
+		bpfStmt(load, seccompDataArch),
+		bpfJump(jeq, seccompAuditArch, 1, 0),
+		bpfStmt(ret, unix.SECCOMP_RET_KILL_PROCESS),
+	}
+	if seccompX32Bit != 0 {
+		program = append(program,
+			bpfStmt(load, seccompDataNr),
+			bpfJump(jge, seccompX32Bit, 0, 1),
+			bpfStmt(ret, unix.SECCOMP_RET_KILL_PROCESS),
+		)
+	}
+
+	for _, rule := range rules {
+		// Arguments are little-endian: the low word comes first
+		low := uint32(seccompDataArgs + 8*rule.Arg)
+		var block []unix.SockFilter
+		switch rule.Check {
+		case checkMaskClear:
+			block = []unix.SockFilter{bpfStmt(load, low), bpfJump(jset, uint32(rule.Value), 1, 0)}
+		case checkMaskSet:
+			block = []unix.SockFilter{bpfStmt(load, low), bpfJump(jset, uint32(rule.Value), 0, 1)}
+		case checkEqual:
+			block = []unix.SockFilter{bpfStmt(load, low), bpfJump(jeq, uint32(rule.Value), 0, 1)}
+		}
+		block = append(block, bpfStmt(ret, rule.Action))
+
+		program = append(program, bpfStmt(load, seccompDataNr), bpfJump(jeq, uint32(rule.Syscall), 0, uint8(len(block))))
+		program = append(program, block...)
+	}
+
+	return append(program, bpfStmt(ret, defaultAction))
+}
+
+// action is what the policy does with a system call, as its compiled filter would
+func (p *seccompPolicy) action(nr int, args [6]uint64) uint32 {
+	for _, rule := range p.Rules {
+		if rule.Syscall != nr {
+			continue
+		}
+		arg := uint32(0)
+		if rule.Arg >= 0 && rule.Arg < len(args) {
+			arg = uint32(args[rule.Arg])
+		}
+		switch rule.Check {
+		case checkMaskClear:
+			if arg&uint32(rule.Value) != 0 {
+				continue
+			}
+		case checkMaskSet:
+			if arg&uint32(rule.Value) == 0 {
+				continue
+			}
+		case checkEqual:
+			if arg != uint32(rule.Value) {
+				continue
+			}
+		}
+		return rule.Action
+	}
+	return p.Default
+}
+
+// seccompFilter compiles the policy for the helper. Every execve is reported to
+// KernelScope, which lets the helper's own exec of the binary through and decides
+// the others by the policy.
+func seccompFilter(policy *seccompPolicy) []unix.SockFilter {
+	rules := append([]seccompRule{{Syscall: unix.SYS_EXECVE, Action: seccompViolation, Arg: -1}}, policy.Rules...)
+	return compileSeccomp(rules, policy.Default)
+}
+
+// execWithSeccomp installs the policy on the calling thread, which must be locked,
+// and execs the binary. KernelScope takes the listener from this process while the
+// exec waits for it, so only raw system calls are made after the filter is in
+// place. It only returns if the filter cannot be installed.
+func execWithSeccomp(spec *sandboxSpec) error {
+	binary, err := unix.BytePtrFromString(spec.Binary)
+	if err != nil {
+		return err
+	}
+	argv, err := syscall.SlicePtrFromStrings(append([]string{spec.Binary}, spec.Args...))
+	if err != nil {
+		return err
+	}
+	envv, err := syscall.SlicePtrFromStrings(spec.Env)
+	if err != nil {
+		return err
+	}
+
+	filter := seccompFilter(spec.Seccomp)
+	program := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %v", err)
+	}
+	if _, _, errno := unix.RawSyscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_NEW_LISTENER, uintptr(unsafe.Pointer(&program))); errno != 0 {
+		return fmt.Errorf("failed to install seccomp filter: %v", errno)
+	}
+
+	unix.RawSyscall(unix.SYS_EXECVE, uintptr(unsafe.Pointer(binary)), uintptr(unsafe.Pointer(&argv[0])), uintptr(unsafe.Pointer(&envv[0])))
+	runtime.KeepAlive(argv)
+	runtime.KeepAlive(envv)
+	runtime.KeepAlive(filter)
+	unix.RawSyscall(unix.SYS_EXIT_GROUP, 127, 0, 0)
+	return nil
+}
+
+// seccompNotif is struct seccomp_notif
+type seccompNotif struct {
+	ID    uint64
+	Pid   uint32
+	Flags uint32
+	Nr    int32
+	Arch  uint32
+	IP    uint64
+	Args  [6]uint64
+}
+
+// seccompNotifResp is struct seccomp_notif_resp
+type seccompNotifResp struct {
+	ID    uint64
+	Val   int64
+	Error int32
+	Flags uint32
+}
+
+// seccompListenerLink is what /proc/<pid>/fd shows for a seccomp listener
+const seccompListenerLink = "anon_inode:seccomp notify"
+
+// takeSeccompListener copies the listener out of the helper once it has installed
+// the filter. The helper cannot exec the binary before KernelScope answers, so it
+// is waited for until the process exits.
+func takeSeccompListener(pidfd, pid int) (int, error) {
+	dir := fmt.Sprintf("/proc/%d/fd", pid)
+	for {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if link, err := os.Readlink(dir + "/" + entry.Name()); err != nil || link != seccompListenerLink {
+				continue
+			}
+			fd, err := strconv.Atoi(entry.Name())
+			if err != nil {
+				continue
+			}
+			listener, err := unix.PidfdGetfd(pidfd, fd, 0)
+			if err != nil {
+				return -1, fmt.Errorf("failed to take the seccomp listener: %v", err)
+			}
+			return listener, nil
+		}
+
+		// A readable pidfd means the process has exited
+		fds := []unix.PollFd{{Fd: int32(pidfd), Events: unix.POLLIN}}
+		if n, err := unix.Poll(fds, 5); err == nil && n > 0 {
+			return -1, fmt.Errorf("the binary's process exited before installing the filter")
+		}
+	}
+}
+
+// superviseSeccomp takes the listener from the binary's process, lets its exec of
+// the binary through and reports every system call the policy refuses. The
+// offending call is left blocked so the process makes no further progress until
+// the monitor terminates the tree. It owns pidfd.
+func superviseSeccomp(pidfd, pid int, policy *seccompPolicy, violations chan<- SyscallViolation) {
+	defer close(violations)
+
+	listener, err := takeSeccompListener(pidfd, pid)
+	if err != nil {
+		// The binary must not run unsupervised, and cannot exec without an answer
+		fmt.Printf("Warning: terminating the binary, seccomp cannot be supervised: %v\n", err)
+		unix.PidfdSendSignal(pidfd, unix.SIGKILL, nil, 0)
+		unix.Close(pidfd)
+		return
+	}
+	unix.Close(pidfd)
+	defer unix.Close(listener)
+
+	execed := false
+	for {
+		fds := []unix.PollFd{{Fd: int32(listener), Events: unix.POLLIN}}
+		if _, err := unix.Poll(fds, -1); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return
+		}
+		if fds[0].Revents&unix.POLLIN == 0 {
+			// POLLHUP: every process using the filter is gone
+			return
+		}
+
+		var notif seccompNotif
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(listener), unix.SECCOMP_IOCTL_NOTIF_RECV, uintptr(unsafe.Pointer(&notif))); errno != 0 {
+			if errno == unix.EINTR || errno == unix.ENOENT {
+				continue
+			}
+			return
+		}
+
+		action := policy.action(int(notif.Nr), notif.Args)
+		if !execed && int(notif.Pid) == pid && int(notif.Nr) == unix.SYS_EXECVE {
+			// The helper replacing itself with the binary
+			execed = true
+			action = seccompAllow
+		}
+
+		resp := seccompNotifResp{ID: notif.ID}
+		switch action {
+		case seccompAllow:
+			resp.Flags = unix.SECCOMP_USER_NOTIF_FLAG_CONTINUE
+		case seccompNoSys:
+			resp.Error = -int32(unix.ENOSYS)
+		default:
+			violation := SyscallViolation{Pid: int(notif.Pid), Number: int(notif.Nr), Name: syscallName(int(notif.Nr))}
+			select {
+			case violations <- violation:
+			default:
+			}
+			continue
+		}
+		// ENOENT: the caller was killed while waiting
+		unix.Syscall(unix.SYS_IOCTL, uintptr(listener), unix.SECCOMP_IOCTL_NOTIF_SEND, uintptr(unsafe.Pointer(&resp)))
+	}
+}
@@ -0,0 +1,130 @@
+package executor
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// runSeccomp interprets a compiled filter for one system call the way the kernel would
+func runSeccomp(t *testing.T, program []unix.SockFilter, arch uint32, nr int, args [6]uint64) uint32 {
+	t.Helper()
+	data := make([]byte, seccompDataArgs+8*len(args))
+	binary.LittleEndian.PutUint32(data[seccompDataNr:], uint32(nr))
+	binary.LittleEndian.PutUint32(data[seccompDataArch:], arch)
+	for i, arg := range args {
+		binary.LittleEndian.PutUint64(data[seccompDataArgs+8*i:], arg)
+	}
+
+	var acc uint32
+	for pc := 0; pc < len(program); pc++ {
+		ins := program[pc]
+		switch ins.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			acc = binary.LittleEndian.Uint32(data[ins.K:])
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K:
+			pc += int(jump(ins, acc == ins.K))
+		case unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			pc += int(jump(ins, acc >= ins.K))
+		case unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K:
+			pc += int(jump(ins, acc&ins.K != 0))
+		case unix.BPF_RET | unix.BPF_K:
+			return ins.K
+		default:
+			t.Fatalf("unexpected instruction %#x at %d", ins.Code, pc)
+		}
+	}
+	t.Fatalf("filter fell off the end")
+	return 0
+}
+
+func jump(ins unix.SockFilter, taken bool) uint8 {
+	if taken {
+		return ins.Jt
+	}
+	return ins.Jf
+}
+
+func TestCompileSeccomp(t *testing.T) {
+	if seccompAuditArch == 0 {
+		t.Skip("no seccomp support on this architecture")
+	}
+
+	dir := t.TempDir()
+	custom := filepath.Join(dir, "profile.json")
+	if err := os.WriteFile(custom, []byte(`{"default": "deny", "allow": ["read", "write", "socket"], "deny": ["socket"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	nr := func(name string) int {
+		number, ok := syscallNumbers[name]
+		if !ok {
+			t.Fatalf("no system call %s on this architecture", name)
+		}
+		return number
+	}
+
+	tests := []struct {
+		name    string
+		profile string
+		arch    uint32
+		syscall int
+		args    [6]uint64
+		x32     bool // Made through the x32 ABI, where there is one
+		want    uint32
+	}{
+		{name: "foreign architecture", profile: "no-exec", arch: unix.AUDIT_ARCH_I386, syscall: nr("getpid"), want: unix.SECCOMP_RET_KILL_PROCESS},
+		{name: "execve is reported", profile: "no-network", syscall: unix.SYS_EXECVE, want: seccompViolation},
+		{name: "strict reads", profile: "strict", syscall: nr("openat"), args: [6]uint64{0, 0, unix.O_RDONLY}, want: seccompAllow},
+		{name: "strict refuses writes", profile: "strict", syscall: nr("openat"), args: [6]uint64{0, 0, unix.O_WRONLY | unix.O_CREAT}, want: seccompViolation},
+		{name: "strict threads", profile: "strict", syscall: nr("clone"), args: [6]uint64{unix.CLONE_THREAD | unix.CLONE_VM}, want: seccompAllow},
+		{name: "strict refuses processes", profile: "strict", syscall: nr("clone"), args: [6]uint64{uint64(unix.SIGCHLD)}, want: seccompViolation},
+		{name: "strict steers clone3 back", profile: "strict", syscall: nr("clone3"), want: seccompNoSys},
+		{name: "strict default", profile: "strict", syscall: nr("socket"), want: seccompViolation},
+		{name: "unix sockets", profile: "no-network", syscall: nr("socket"), args: [6]uint64{unix.AF_UNIX}, want: seccompAllow},
+		{name: "inet sockets", profile: "no-network", syscall: nr("socket"), args: [6]uint64{unix.AF_INET}, want: seccompViolation},
+		{name: "only the low word of an argument", profile: "no-network", syscall: nr("socket"), args: [6]uint64{1<<32 | unix.AF_UNIX}, want: seccompAllow},
+		{name: "io_uring", profile: "no-network", syscall: nr("io_uring_setup"), want: seccompViolation},
+		{name: "no-exec default", profile: "no-exec", syscall: nr("socket"), want: seccompAllow},
+		{name: "no-exec execveat", profile: "no-exec", syscall: nr("execveat"), want: seccompViolation},
+		{name: "custom deny wins", profile: custom, syscall: nr("socket"), want: seccompViolation},
+		{name: "custom allow", profile: custom, syscall: nr("write"), want: seccompAllow},
+		{name: "custom default", profile: custom, syscall: nr("getpid"), want: seccompViolation},
+		{name: "x32 system call", profile: "no-exec", syscall: nr("getpid"), x32: true, want: unix.SECCOMP_RET_KILL_PROCESS},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.x32 && seccompX32Bit == 0 {
+				t.Skip("no x32 ABI on this architecture")
+			}
+			policy, err := loadSeccompPolicy(test.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			arch, syscall := test.arch, test.syscall
+			if arch == 0 {
+				arch = seccompAuditArch
+			}
+			if test.x32 {
+				syscall |= seccompX32Bit
+			}
+
+			got := runSeccomp(t, seccompFilter(policy), arch, syscall, test.args)
+			if got != test.want {
+				t.Errorf("filter returned %#x, want %#x", got, test.want)
+			}
+
+			// What the supervisor decides for reported calls must match the filter without the execve rule
+			if arch == seccompAuditArch && !test.x32 {
+				compiled := runSeccomp(t, compileSeccomp(policy.Rules, policy.Default), arch, test.syscall, test.args)
+				if action := policy.action(test.syscall, test.args); action != compiled {
+					t.Errorf("policy action = %#x, compiled filter returned %#x", action, compiled)
+				}
+			}
+		})
+	}
+}
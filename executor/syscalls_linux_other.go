@@ -0,0 +1,12 @@
+//go:build linux && !amd64 && !arm64
+
+package executor
+
+// seccompAuditArch is zero where KernelScope has no system call table, disabling seccomp profiles
+const seccompAuditArch = 0
+
+// seccompX32Bit is zero as there is no x32-style ABI sharing the architecture
+const seccompX32Bit = 0
+
+// syscallNames is empty where KernelScope has no system call table
+var syscallNames = map[int]string{}
@@ -0,0 +1,58 @@
+package executor
+
+import (
+	"sort"
+	"time"
+)
+
+// SyscallStat counts the calls of one system call
+type SyscallStat struct {
+	Number int
+	Name   string
+	Calls  int
+	Errors int           // Calls that returned an error
+	Time   time.Duration // Wall-clock time between entering and leaving the call
+}
+
+// ProcessSyscalls are the system calls made by one traced process, across all its threads
+type ProcessSyscalls struct {
+	Pid      int
+	Comm     string
+	Syscalls []SyscallStat // Most time first
+}
+
+// SyscallSummary is what --trace-syscalls counted up to the binary's exit
+type SyscallSummary struct {
+	Syscalls  []SyscallStat     // Totals across every traced process, most time first
+	Processes []ProcessSyscalls // In order of appearance
+}
+
+// Totals adds up the calls, errors and time of a list of system calls
+func Totals(stats []SyscallStat) (calls int, errors int, elapsed time.Duration) {
+	for _, stat := range stats {
+		calls += stat.Calls
+		errors += stat.Errors
+		elapsed += stat.Time
+	}
+	return calls, errors, elapsed
+}
+
+// traceResult is what the tracer reports once the binary has exited
+type traceResult struct {
+	exitCode int
+	err      error
+	syscalls *SyscallSummary
+}
+
+// sortSyscalls orders system calls by time spent, then by number of calls
+func sortSyscalls(stats []SyscallStat) {
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Time != stats[j].Time {
+			return stats[i].Time > stats[j].Time
+		}
+		if stats[i].Calls != stats[j].Calls {
+			return stats[i].Calls > stats[j].Calls
+		}
+		return stats[i].Number < stats[j].Number
+	})
+}
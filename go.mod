@@ -3,6 +3,8 @@ module kernelscope
 go 1.24.3
 
 require (
-	github.com/shirou/gopsutil/v3 v3.24.5 // indirect
-	golang.org/x/sys v0.33.0 // indirect
+	golang.org/x/sys v0.33.0
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require github.com/shirou/gopsutil/v3 v3.24.5 // indirect
@@ -0,0 +1,112 @@
+package judge
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Comparison modes
+const (
+	CompareExact      = "exact"      // Outputs must be byte-for-byte identical
+	CompareWhitespace = "whitespace" // Outputs must have the same tokens, however they are spaced
+	CompareFloat      = "float"      // Like whitespace, but numbers may differ by the tolerance
+	CompareChecker    = "checker"    // A checker binary decides
+)
+
+// compareOutput reports whether the actual output is accepted and, if not, why
+func compareOutput(mode string, tolerance float64, expected, actual []byte) (bool, string) {
+	switch mode {
+	case CompareExact:
+		if bytes.Equal(expected, actual) {
+			return true, ""
+		}
+		return false, firstDifference(expected, actual)
+	case CompareWhitespace:
+		return compareTokens(expected, actual, func(e, a string) bool { return e == a })
+	case CompareFloat:
+		return compareTokens(expected, actual, func(e, a string) bool {
+			if e == a {
+				return true
+			}
+			ef, errE := strconv.ParseFloat(e, 64)
+			af, errA := strconv.ParseFloat(a, 64)
+			if errE != nil || errA != nil {
+				return false
+			}
+			// Accept an absolute or relative error within the tolerance
+			diff := math.Abs(ef - af)
+			return diff <= tolerance || diff <= tolerance*math.Abs(ef)
+		})
+	default:
+		return false, fmt.Sprintf("unknown comparison mode %q", mode)
+	}
+}
+
+// compareTokens compares whitespace-separated tokens with the given equality
+func compareTokens(expected, actual []byte, equal func(e, a string) bool) (bool, string) {
+	expectedTokens := strings.Fields(string(expected))
+	actualTokens := strings.Fields(string(actual))
+
+	for i := 0; i < len(expectedTokens) && i < len(actualTokens); i++ {
+		if !equal(expectedTokens[i], actualTokens[i]) {
+			return false, fmt.Sprintf("token %d: expected %q, got %q", i+1, expectedTokens[i], actualTokens[i])
+		}
+	}
+	if len(expectedTokens) != len(actualTokens) {
+		return false, fmt.Sprintf("expected %d tokens, got %d", len(expectedTokens), len(actualTokens))
+	}
+	return true, ""
+}
+
+// firstDifference describes where two outputs first differ
+func firstDifference(expected, actual []byte) string {
+	line := 1
+	for i := 0; i < len(expected) && i < len(actual); i++ {
+		if expected[i] != actual[i] {
+			return fmt.Sprintf("outputs differ on line %d", line)
+		}
+		if expected[i] == '\n' {
+			line++
+		}
+	}
+	if len(actual) < len(expected) {
+		return fmt.Sprintf("output ends early on line %d", line)
+	}
+	return fmt.Sprintf("unexpected extra output on line %d", line)
+}
+
+// runChecker runs "checker <input> <expected> <actual>". Exit code 0 accepts the output;
+// anything the checker prints is used as the reason.
+func runChecker(checker, inputPath, expectedPath string, actual []byte) (bool, string, error) {
+	file, err := os.CreateTemp("", "kernelscope-output-*")
+	if err != nil {
+		return false, "", fmt.Errorf("failed to create output file: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(actual); err != nil {
+		file.Close()
+		return false, "", fmt.Errorf("failed to write output file: %v", err)
+	}
+	file.Close()
+
+	cmd := exec.Command(checker, inputPath, expectedPath, file.Name())
+	out, err := cmd.CombinedOutput()
+	message := strings.TrimSpace(string(out))
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			if message == "" {
+				message = fmt.Sprintf("rejected by %s", filepath.Base(checker))
+			}
+			return false, message, nil
+		}
+		return false, "", fmt.Errorf("failed to run checker: %v", err)
+	}
+	return true, message, nil
+}
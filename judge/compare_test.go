@@ -0,0 +1,93 @@
+package judge
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCompareOutput(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		tolerance  float64
+		expected   string
+		actual     string
+		accepted   bool
+		wantDetail string
+	}{
+		{name: "exact match", mode: CompareExact, expected: "1 2\n3\n", actual: "1 2\n3\n", accepted: true},
+		{name: "exact differs", mode: CompareExact, expected: "1 2\n3\n", actual: "1 2\n4\n", wantDetail: "outputs differ on line 2"},
+		{name: "exact ends early", mode: CompareExact, expected: "1\n2\n", actual: "1\n", wantDetail: "output ends early on line 2"},
+		{name: "exact extra output", mode: CompareExact, expected: "1\n", actual: "1\n2\n", wantDetail: "unexpected extra output on line 2"},
+		{name: "exact trailing newline", mode: CompareExact, expected: "1\n", actual: "1", wantDetail: "output ends early on line 1"},
+		{name: "whitespace spacing", mode: CompareWhitespace, expected: "1 2\n3\n", actual: "1\t2   3", accepted: true},
+		{name: "whitespace token", mode: CompareWhitespace, expected: "1 2 3", actual: "1 2 4", wantDetail: `token 3: expected "3", got "4"`},
+		{name: "whitespace missing token", mode: CompareWhitespace, expected: "1 2 3", actual: "1 2", wantDetail: "expected 3 tokens, got 2"},
+		{name: "whitespace is not numeric", mode: CompareWhitespace, expected: "1.0", actual: "1", wantDetail: `token 1: expected "1.0", got "1"`},
+		{name: "float identical", mode: CompareFloat, tolerance: 1e-6, expected: "abc 1.5", actual: "abc 1.5", accepted: true},
+		{name: "float absolute error", mode: CompareFloat, tolerance: 1e-6, expected: "0.1234567", actual: "0.1234571", accepted: true},
+		{name: "float relative error", mode: CompareFloat, tolerance: 1e-6, expected: "1000000", actual: "1000000.5", accepted: true},
+		{name: "float outside tolerance", mode: CompareFloat, tolerance: 1e-6, expected: "0.5", actual: "0.5001", wantDetail: `token 1: expected "0.5", got "0.5001"`},
+		{name: "float words must match", mode: CompareFloat, tolerance: 1e-6, expected: "yes 1", actual: "no 1", wantDetail: `token 1: expected "yes", got "no"`},
+		{name: "unknown mode", mode: "fuzzy", expected: "1", actual: "1", wantDetail: `unknown comparison mode "fuzzy"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			accepted, detail := compareOutput(test.mode, test.tolerance, []byte(test.expected), []byte(test.actual))
+			if accepted != test.accepted || detail != test.wantDetail {
+				t.Errorf("compareOutput = %v, %q, want %v, %q", accepted, detail, test.accepted, test.wantDetail)
+			}
+		})
+	}
+}
+
+func TestRunChecker(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the checker is a shell script")
+	}
+
+	dir := t.TempDir()
+	input, expected := filepath.Join(dir, "1.in"), filepath.Join(dir, "1.out")
+	for path, data := range map[string]string{input: "2 3\n", expected: "5\n"} {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The checker accepts any output equal to the expected one, and names the input otherwise
+	checker := filepath.Join(dir, "checker")
+	script := "#!/bin/sh\ncmp -s \"$2\" \"$3\" && exit 0\necho \"wrong sum for $(cat \"$1\")\"\nexit 1\n"
+	if err := os.WriteFile(checker, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		checker    string
+		actual     string
+		accepted   bool
+		wantDetail string
+		wantErr    bool
+	}{
+		{name: "accepted", checker: checker, actual: "5\n", accepted: true},
+		{name: "rejected with a reason", checker: checker, actual: "6\n", wantDetail: "wrong sum for 2 3"},
+		{name: "rejected silently", checker: "/bin/false", actual: "5\n", wantDetail: "rejected by false"},
+		{name: "missing checker", checker: filepath.Join(dir, "missing"), actual: "5\n", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			accepted, detail, err := runChecker(test.checker, input, expected, []byte(test.actual))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("runChecker error = %v, want error %v", err, test.wantErr)
+			}
+			if accepted != test.accepted || !strings.Contains(detail, test.wantDetail) || (test.wantDetail == "" && detail != "") {
+				t.Errorf("runChecker = %v, %q, want %v, %q", accepted, detail, test.accepted, test.wantDetail)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package judge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kernelscope/cli"
+	"kernelscope/executor"
+	"kernelscope/loopcontrol"
+	"kernelscope/monitor"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Verdicts
+const (
+	Accepted            = "AC"
+	WrongAnswer         = "WA"
+	TimeLimitExceeded   = "TLE"
+	MemoryLimitExceeded = "MLE"
+	RuntimeError        = "RE"
+	OutputLimitExceeded = "OLE"
+	JudgeError          = "JE" // The case could not be judged, e.g. the checker failed
+)
+
+// verdictNames are the long forms of the verdicts
+var verdictNames = map[string]string{
+	Accepted:            "Accepted",
+	WrongAnswer:         "Wrong Answer",
+	TimeLimitExceeded:   "Time Limit Exceeded",
+	MemoryLimitExceeded: "Memory Limit Exceeded",
+	RuntimeError:        "Runtime Error",
+	OutputLimitExceeded: "Output Limit Exceeded",
+	JudgeError:          "Judge Error",
+}
+
+// TestCase is an input file and the output expected for it
+type TestCase struct {
+	Name         string
+	InputPath    string
+	ExpectedPath string
+}
+
+// CaseResult is the verdict of a single test case
+type CaseResult struct {
+	Name         string  `json:"name"`
+	Verdict      string  `json:"verdict"`
+	Detail       string  `json:"detail,omitempty"` // Why the case was not accepted
+	ExitCode     int     `json:"exit_code"`
+	TermReason   string  `json:"termination_reason,omitempty"`
+	WallSeconds  float64 `json:"wall_seconds"`
+	CpuSeconds   float64 `json:"cpu_seconds"`
+	PeakMemoryKB uint64  `json:"peak_memory_kb"`
+	OutputBytes  int     `json:"output_bytes"`
+}
+
+// Report is the result of judging every test case
+type Report struct {
+	Binary   string         `json:"binary"`
+	Tests    string         `json:"tests"`
+	Compare  string         `json:"compare"`
+	Verdict  string         `json:"verdict"` // Verdict of the first case that was not accepted, or AC
+	Passed   int            `json:"passed"`
+	Total    int            `json:"total"`
+	Verdicts map[string]int `json:"verdicts"`
+	Cases    []CaseResult   `json:"cases"`
+}
+
+// FindTestCases pairs every NAME.in in dir with NAME.out or NAME.ans, in natural order
+func FindTestCases(dir string) ([]TestCase, error) {
+	inputs, err := filepath.Glob(filepath.Join(dir, "*.in"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list test cases: %v", err)
+	}
+
+	var cases []TestCase
+	for _, input := range inputs {
+		base := strings.TrimSuffix(input, ".in")
+		expected := ""
+		for _, ext := range []string{".out", ".ans"} {
+			if _, err := os.Stat(base + ext); err == nil {
+				expected = base + ext
+				break
+			}
+		}
+		if expected == "" {
+			return nil, fmt.Errorf("test case %s has no .out or .ans file", filepath.Base(input))
+		}
+		cases = append(cases, TestCase{Name: filepath.Base(base), InputPath: input, ExpectedPath: expected})
+	}
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("no *.in files found in %s", dir)
+	}
+
+	sort.Slice(cases, func(i, j int) bool { return naturalLess(cases[i].Name, cases[j].Name) })
+	return cases, nil
+}
+
+// Run judges the binary against every test case
+func Run(config *cli.JudgeConfig) (*Report, error) {
+	cases, err := FindTestCases(config.TestsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Binary:   config.Run.BinaryPath,
+		Tests:    config.TestsDir,
+		Compare:  config.Compare,
+		Verdict:  Accepted,
+		Total:    len(cases),
+		Verdicts: make(map[string]int),
+	}
+
+	for _, tc := range cases {
+		fmt.Printf("Judge: running case %s\n", tc.Name)
+		result := runCase(config, tc)
+		fmt.Printf("Judge: case %s: %s\n", tc.Name, verdictNames[result.Verdict])
+
+		report.Cases = append(report.Cases, result)
+		report.Verdicts[result.Verdict]++
+		if result.Verdict == Accepted {
+			report.Passed++
+		} else if report.Verdict == Accepted {
+			report.Verdict = result.Verdict
+		}
+	}
+	return report, nil
+}
+
+// runCase runs the binary on one input and assigns a verdict
+func runCase(config *cli.JudgeConfig, tc TestCase) CaseResult {
+	result := CaseResult{Name: tc.Name}
+
+	input, err := os.Open(tc.InputPath)
+	if err != nil {
+		result.Verdict, result.Detail = JudgeError, fmt.Sprintf("failed to open input: %v", err)
+		return result
+	}
+	defer input.Close()
+
+	// Every case gets a fresh copy of the configuration and its own monitor
+	runConfig := *config.Run
+	stdout := &limitedBuffer{limit: config.MaxOutputBytes}
+
+	exec := executor.NewExecutor(&runConfig)
+	exec.Stdin = input
+	exec.Stdout = stdout
+	exec.Stderr = os.Stderr
+
+	mon := monitor.NewMonitor(&runConfig)
+	loopCtrl := loopcontrol.NewLoopController(&runConfig, exec, mon)
+	loopCtrl.StartLoop()
+
+	stats := loopCtrl.Stats
+	result.ExitCode = stats.ExitCode
+	result.TermReason = stats.TermReason
+	result.WallSeconds = stats.WallTime.Seconds()
+	result.CpuSeconds = stats.CpuTimeUsed
+	result.PeakMemoryKB = stats.MaxMemoryKB
+	result.OutputBytes = stdout.total
+
+	switch {
+	case stdout.exceeded:
+		result.Verdict = OutputLimitExceeded
+		result.Detail = fmt.Sprintf("wrote more than %d bytes to stdout", config.MaxOutputBytes)
+	case stats.TermReason != "":
+		result.Verdict, result.Detail = terminationVerdict(stats.TermReason), stats.TermReason
+	case stats.ExitCode != 0:
+		result.Verdict, result.Detail = RuntimeError, fmt.Sprintf("exit code %d", stats.ExitCode)
+	default:
+		result.Verdict, result.Detail = judgeOutput(config, tc, stdout.Bytes())
+	}
+	return result
+}
+
+// terminationVerdict is the verdict of a run a limit terminated, by the reason
+func terminationVerdict(reason string) string {
+	switch reason {
+	case "Timeout", "CPU quota exceeded", "Idle timeout", "Output timeout":
+		return TimeLimitExceeded
+	case "Memory limit exceeded":
+		return MemoryLimitExceeded
+	case "Write limit exceeded":
+		return OutputLimitExceeded
+	default:
+		return RuntimeError
+	}
+}
+
+// judgeOutput compares the output of a run that finished normally with the expected output
+func judgeOutput(config *cli.JudgeConfig, tc TestCase, actual []byte) (string, string) {
+	if config.Compare == CompareChecker {
+		accepted, message, err := runChecker(config.Checker, tc.InputPath, tc.ExpectedPath, actual)
+		if err != nil {
+			return JudgeError, err.Error()
+		}
+		if !accepted {
+			return WrongAnswer, message
+		}
+		return Accepted, ""
+	}
+
+	expected, err := os.ReadFile(tc.ExpectedPath)
+	if err != nil {
+		return JudgeError, fmt.Sprintf("failed to read expected output: %v", err)
+	}
+	if accepted, detail := compareOutput(config.Compare, config.Tolerance, expected, actual); !accepted {
+		return WrongAnswer, detail
+	}
+	return Accepted, ""
+}
+
+// errOutputLimit stops copying the process output once the limit is reached
+var errOutputLimit = errors.New("output limit exceeded")
+
+// limitedBuffer keeps the process output up to a limit. Writing past it fails,
+// which closes the pipe so the process gets SIGPIPE.
+type limitedBuffer struct {
+	data     []byte
+	limit    int64 // 0 = unlimited
+	total    int
+	exceeded bool
+}
+
+// Write appends to the buffer, failing once the limit is exceeded
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.total += len(p)
+	if b.limit > 0 && int64(len(b.data)+len(p)) > b.limit {
+		b.exceeded = true
+		return 0, errOutputLimit
+	}
+	b.data = append(b.data, p...)
+	return len(p), nil
+}
+
+// Bytes returns the buffered output
+func (b *limitedBuffer) Bytes() []byte {
+	return b.data
+}
+
+// Print prints the per-case verdicts and the overall result
+func (report *Report) Print() {
+	fmt.Println("\n=========== KernelScope Judge Report ===========")
+	fmt.Printf("%-16s %-7s %9s %9s %12s  %s\n", "CASE", "VERDICT", "WALL(s)", "CPU(s)", "PEAK MEM(KB)", "DETAIL")
+	for _, result := range report.Cases {
+		fmt.Printf("%-16s %-7s %9.2f %9.2f %12d  %s\n",
+			result.Name, result.Verdict, result.WallSeconds, result.CpuSeconds, result.PeakMemoryKB, result.Detail)
+	}
+	fmt.Println("------------------------------------------------")
+
+	verdicts := make([]string, 0, len(report.Verdicts))
+	for verdict, count := range report.Verdicts {
+		verdicts = append(verdicts, fmt.Sprintf("%s %d", verdict, count))
+	}
+	sort.Strings(verdicts)
+	fmt.Printf("Passed: %d/%d (%s)\n", report.Passed, report.Total, strings.Join(verdicts, ", "))
+	fmt.Printf("Verdict: %s\n", verdictNames[report.Verdict])
+	fmt.Println("================================================")
+}
+
+// WriteJSON writes the judge report as JSON to path
+func (report *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode judge report: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write judge report: %v", err)
+	}
+	return nil
+}
+
+// naturalLess orders names so that "2" comes before "10"
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		na, restA := leadingNumber(a)
+		nb, restB := leadingNumber(b)
+		if na >= 0 && nb >= 0 {
+			if na != nb {
+				return na < nb
+			}
+			a, b = restA, restB
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+// leadingNumber parses the digits at the start of s, returning -1 if there are none
+func leadingNumber(s string) (int64, string) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	if i == 0 {
+		return -1, s
+	}
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return -1, s
+	}
+	return n, s[i:]
+}
@@ -0,0 +1,125 @@
+package judge
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	names := []string{"a10", "b", "a2", "10", "a1", "2", "a", "a02b", "a2a"}
+	sort.Slice(names, func(i, j int) bool { return naturalLess(names[i], names[j]) })
+
+	want := []string{"2", "10", "a", "a1", "a2", "a2a", "a02b", "a10", "b"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("sorted = %q, want %q", names, want)
+	}
+
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"a1", "a2", true},
+		{"a2", "a10", true},
+		{"a10", "a2", false},
+		{"a2", "a2", false},
+		{"a", "a1", true},
+		{"case9", "case10", true},
+	}
+	for _, test := range tests {
+		if got := naturalLess(test.a, test.b); got != test.want {
+			t.Errorf("naturalLess(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestFindTestCases(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		want    []string // Name, input and expected file of each case
+		wantErr bool
+	}{
+		{
+			name:  "natural order",
+			files: []string{"a10.in", "a10.out", "a2.in", "a2.out", "a1.in", "a1.out"},
+			want:  []string{"a1 a1.in a1.out", "a2 a2.in a2.out", "a10 a10.in a10.out"},
+		},
+		{
+			name:  "answers",
+			files: []string{"1.in", "1.ans", "2.in", "2.out", "2.ans", "notes.txt"},
+			want:  []string{"1 1.in 1.ans", "2 2.in 2.out"},
+		},
+		{name: "missing expected output", files: []string{"1.in", "1.out", "2.in"}, wantErr: true},
+		{name: "no inputs", files: []string{"1.out"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range test.files {
+				if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cases, err := FindTestCases(dir)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("FindTestCases = %+v, want an error", cases)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, tc := range cases {
+				got = append(got, tc.Name+" "+filepath.Base(tc.InputPath)+" "+filepath.Base(tc.ExpectedPath))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("cases = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTerminationVerdict(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   string
+	}{
+		{"Timeout", TimeLimitExceeded},
+		{"CPU quota exceeded", TimeLimitExceeded},
+		{"Idle timeout", TimeLimitExceeded},
+		{"Output timeout", TimeLimitExceeded},
+		{"Memory limit exceeded", MemoryLimitExceeded},
+		{"Write limit exceeded", OutputLimitExceeded},
+		{"Read limit exceeded", RuntimeError},
+		{"Thread limit exceeded", RuntimeError},
+		{"Process limit exceeded", RuntimeError},
+		{"Seccomp violation: syscall 41 (socket)", RuntimeError},
+		{"Process start failure", RuntimeError},
+	}
+
+	for _, test := range tests {
+		if got := terminationVerdict(test.reason); got != test.want {
+			t.Errorf("terminationVerdict(%q) = %s, want %s", test.reason, got, test.want)
+		}
+	}
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	buffer := &limitedBuffer{limit: 5}
+	if n, err := buffer.Write([]byte("abc")); n != 3 || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if _, err := buffer.Write([]byte("def")); err != errOutputLimit {
+		t.Errorf("Write past the limit = %v, want %v", err, errOutputLimit)
+	}
+	if string(buffer.Bytes()) != "abc" || buffer.total != 6 || !buffer.exceeded {
+		t.Errorf("buffer = %q, total %d, exceeded %v", buffer.Bytes(), buffer.total, buffer.exceeded)
+	}
+}
@@ -1,145 +1,349 @@
-package loopcontrol
-
-import (
-	"fmt"
-	"kernelscope/cli"
-	"kernelscope/executor"
-	"kernelscope/monitor"
-	"kernelscope/reporter"
-	"time"
-)
-
-// LoopController manages the main execution loop
-type LoopController struct {
-	Config      *cli.Config
-	Executor    *executor.Executor
-	Monitor     *monitor.Monitor
-	UsedCpuTime float64
-	Stats       *monitor.Stats
-}
-
-func NewLoopController(config *cli.Config, exec *executor.Executor, mon *monitor.Monitor) *LoopController {
-	return &LoopController{
-		Config:      config,
-		Executor:    exec,
-		Monitor:     mon,
-		UsedCpuTime: 0,
-		Stats:       &monitor.Stats{},
-	}
-}
-
-// StartLoop starts the main execution loop
-func (lc *LoopController) StartLoop() {
-	fmt.Println("Starting process execution and monitoring...")
-
-	// Initialize stats
-	lc.Stats.StartTime = time.Now()
-	lc.Stats.LoopCount = 1
-	lc.Stats.SuccessCount = 0
-
-	// Start process once
-	process, err := lc.Executor.StartProcess()
-	if err != nil {
-		fmt.Printf("Failed to start process: %v\n", err)
-		lc.Stats.TermReason = "Process start failure"
-
-		// Generate report even if process failed to start
-		lc.Stats.EndTime = time.Now()
-		reporter.GenerateReport(lc.Stats, lc.Stats)
-		return
-	}
-
-	// Start monitoring the process
-	lc.Monitor.StartMonitoring(process)
-
-	// Use a separate goroutine to properly wait for the process
-	waitDone := make(chan int)
-	go func() {
-		exitCode, err := lc.Executor.WaitForProcess(process)
-		if err != nil {
-			fmt.Printf("Error waiting for process: %v\n", err)
-		}
-		waitDone <- exitCode
-	}()
-
-	// Wait for process to complete or reach resource limits
-	processRunning := true
-	for processRunning && lc.shouldContinue() {
-		// Report progress
-		reporter.ReportProgress(lc.Monitor.Stats)
-
-		// Check if process has completed via the wait channel
-		select {
-		case exitCode := <-waitDone:
-			lc.Stats.ExitCode = exitCode
-			processRunning = false
-			fmt.Printf("Process exited with code: %d\n", exitCode)
-		case <-time.After(500 * time.Millisecond):
-			// Continue monitoring
-		}
-	}
-
-	// If we broke out of the loop due to resource limits but process is still running
-	if processRunning {
-		fmt.Println("Resource limits reached, terminating process...")
-		lc.Executor.KillProcess(process)
-
-		// Wait for the process to be fully terminated
-		select {
-		case exitCode := <-waitDone:
-			lc.Stats.ExitCode = exitCode
-		case <-time.After(2 * time.Second):
-			fmt.Println("Warning: Process did not terminate gracefully")
-		}
-	}
-
-	// Wait for final process stats
-	result := lc.Monitor.WaitForCompletion()
-
-	// Update CPU time used
-	lc.UsedCpuTime = result.CpuTimeUsed
-
-	// Update overall stats
-	lc.updateStats(result)
-
-	// Record success
-	success := lc.Stats.ExitCode == 0 && lc.Stats.TermReason == ""
-	if success {
-		lc.Stats.SuccessCount = 1
-	}
-
-	lc.Stats.EndTime = time.Now()
-	lc.Stats.CpuTimeUsed = lc.UsedCpuTime
-
-	// Generate final report
-	reporter.GenerateReport(lc.Stats, lc.Stats)
-}
-
-// shouldContinue determines if the loop should continue
-func (lc *LoopController) shouldContinue() bool {
-	if lc.Config.PrePaidMode {
-		// In prepaid mode, continue until CPU credits are exhausted
-		return lc.UsedCpuTime < lc.Config.CpuCredit
-	} else {
-		// In postpaid mode, continue until CPU limit is reached
-		return lc.UsedCpuTime < float64(lc.Config.CpuLimit)
-	}
-}
-
-// updateStats updates the overall statistics
-func (lc *LoopController) updateStats(result *monitor.Stats) {
-	// Update max memory usage
-	if result.MaxMemoryKB > lc.Stats.MaxMemoryKB {
-		lc.Stats.MaxMemoryKB = result.MaxMemoryKB
-	}
-
-	// Update termination reason if set
-	if result.TermReason != "" {
-		lc.Stats.TermReason = result.TermReason
-	}
-
-	// Update exit code if non-zero
-	if result.ExitCode != 0 {
-		lc.Stats.ExitCode = result.ExitCode
-	}
-}
+package loopcontrol
+
+import (
+	"fmt"
+	"kernelscope/cli"
+	"kernelscope/executor"
+	"kernelscope/monitor"
+	"kernelscope/otlp"
+	"kernelscope/reporter"
+	"kernelscope/timeseries"
+	"kernelscope/workspace"
+	"path/filepath"
+	"time"
+)
+
+// LoopController manages the main execution loop
+type LoopController struct {
+	Config      *cli.Config
+	Executor    *executor.Executor
+	Monitor     *monitor.Monitor
+	UsedCpuTime float64
+	Stats       *monitor.Stats
+	Telemetry   *otlp.Exporter // OTLP exporter, nil when export is disabled
+
+	stdout *outputCapture // Captured output, nil unless the success criteria need it
+	stderr *outputCapture
+
+	workspace *workspace.Workspace // Working directory of the current run, nil without one
+	baseline  *workspace.Snapshot  // Working directory before the run, nil unless audited
+	watched   []*workspace.Snapshot
+}
+
+func NewLoopController(config *cli.Config, exec *executor.Executor, mon *monitor.Monitor) *LoopController {
+	lc := &LoopController{
+		Config:      config,
+		Executor:    exec,
+		Monitor:     mon,
+		UsedCpuTime: 0,
+		Stats:       &monitor.Stats{},
+	}
+	if config.OTLPEndpoint != "" {
+		lc.Telemetry = otlp.NewExporter(config.OTLPEndpoint, config.OTLPServiceName)
+	}
+	return lc
+}
+
+// StartLoop starts the main execution loop
+func (lc *LoopController) StartLoop() {
+	fmt.Println("Starting process execution and monitoring...")
+
+	// Initialize stats
+	lc.Stats.StartTime = time.Now()
+	lc.Stats.LoopCount = 1
+	lc.Stats.SuccessCount = 0
+
+	// Trace the run and each iteration
+	runSpan := lc.startSpan("kernelscope.run", nil)
+	iterationSpan := lc.startSpan("kernelscope.iteration", runSpan)
+	if iterationSpan != nil {
+		iterationSpan.SetAttribute("kernelscope.iteration", lc.Stats.LoopCount)
+	}
+
+	// Start process once
+	lc.captureOutput()
+	lc.trackOutput()
+	lc.snapshotFiles()
+	process, err := lc.Executor.StartProcess()
+	if err != nil {
+		fmt.Printf("Failed to start process: %v\n", err)
+		lc.Stats.TermReason = "Process start failure"
+		lc.Stats.SuccessReason = "process failed to start"
+
+		// Generate report even if process failed to start
+		lc.Stats.EndTime = time.Now()
+		lc.finishSpan(iterationSpan, lc.Stats)
+		lc.finishSpan(runSpan, lc.Stats)
+		reporter.GenerateReport(lc.Stats, lc.Stats)
+		lc.writeJSONReport()
+		lc.exportTelemetry()
+		return
+	}
+
+	lc.workspace = process.Workspace
+	if lc.workspace != nil {
+		lc.Stats.Workspace = lc.workspace.Dir
+		defer lc.removeWorkspace()
+	}
+
+	// Start monitoring the process
+	lc.Monitor.StartMonitoring(process)
+
+	// Use a separate goroutine to properly wait for the process
+	waitDone := make(chan int)
+	go func() {
+		exitCode, err := lc.Executor.WaitForProcess(process)
+		if err != nil {
+			fmt.Printf("Error waiting for process: %v\n", err)
+		}
+		waitDone <- exitCode
+	}()
+
+	// Wait for process to complete or reach resource limits
+	processRunning := true
+	var exitedAt time.Time
+	for processRunning && lc.shouldContinue() {
+		// Report progress from a copy, the monitor updates its stats meanwhile
+		progress := lc.Monitor.Snapshot()
+		reporter.ReportProgress(&progress)
+
+		// Check if process has completed via the wait channel
+		select {
+		case exitCode := <-waitDone:
+			lc.Stats.ExitCode = exitCode
+			exitedAt = time.Now()
+			processRunning = false
+			fmt.Printf("Process exited with code: %d\n", exitCode)
+		case <-time.After(500 * time.Millisecond):
+			// Continue monitoring
+		}
+	}
+
+	// If we broke out of the loop due to resource limits but process is still running
+	if processRunning {
+		fmt.Println("Resource limits reached, terminating process...")
+		lc.Executor.KillProcessTree(process)
+
+		// Wait for the process to be fully terminated
+		select {
+		case exitCode := <-waitDone:
+			lc.Stats.ExitCode = exitCode
+		case <-time.After(2 * time.Second):
+			fmt.Println("Warning: Process did not terminate gracefully")
+		}
+		exitedAt = time.Now()
+	}
+
+	// Wait for final process stats
+	result := lc.Monitor.WaitForCompletion()
+
+	// Update CPU time used, including children that exited before the end
+	lc.UsedCpuTime = result.TotalCpuTime
+
+	// Update overall stats
+	lc.updateStats(result)
+	lc.Stats.WallTime = exitedAt.Sub(result.StartTime)
+	lc.Stats.Syscalls = process.Syscalls()
+
+	// Audit the filesystem before the success command can touch it
+	lc.auditFiles()
+
+	// Record success according to the configured criteria
+	success, reason := lc.evaluateSuccess()
+	if success {
+		lc.Stats.SuccessCount = 1
+	}
+	lc.Stats.SuccessReason = reason
+	lc.finishSpan(iterationSpan, lc.Stats)
+
+	// Keep the requested outputs before the workspace is removed
+	lc.collectArtifacts()
+
+	lc.Stats.EndTime = time.Now()
+	lc.Stats.CpuTimeUsed = lc.UsedCpuTime
+	lc.finishSpan(runSpan, lc.Stats)
+
+	// Save the time series if requested
+	if lc.Config.TimeSeriesPath != "" {
+		if err := timeseries.Write(lc.Config.TimeSeriesPath, lc.Stats.Samples); err != nil {
+			fmt.Printf("Warning: Failed to save time series: %v\n", err)
+		} else {
+			fmt.Printf("Time series written to %s\n", lc.Config.TimeSeriesPath)
+		}
+	}
+
+	// Generate final report
+	reporter.GenerateReport(lc.Stats, lc.Stats)
+	lc.writeJSONReport()
+	lc.exportTelemetry()
+}
+
+// collectArtifacts copies the workspace files matching --collect into a
+// subdirectory of the artifacts directory named after the workspace
+func (lc *LoopController) collectArtifacts() {
+	if lc.workspace == nil || len(lc.Config.Collect) == 0 {
+		return
+	}
+
+	dest := filepath.Join(lc.Config.ArtifactsDir, lc.workspace.Name())
+	artifacts, err := lc.workspace.Collect(lc.Config.Collect, dest)
+	if err != nil {
+		fmt.Printf("Warning: Failed to collect artifacts: %v\n", err)
+	}
+	if len(artifacts) > 0 {
+		lc.Stats.ArtifactsDir = dest
+		lc.Stats.Artifacts = artifacts
+		fmt.Printf("Collected %d artifacts into %s\n", len(artifacts), dest)
+	}
+}
+
+// snapshotFiles records the audited paths before the run. The audited working
+// directory is always a workspace, which starts as a copy of its template, so
+// the template stands in for it.
+func (lc *LoopController) snapshotFiles() {
+	lc.baseline, lc.watched = nil, nil
+
+	if lc.Config.Audit {
+		snapshot, err := workspace.TakeSnapshot(lc.Config.WorkspaceTemplate)
+		if err != nil {
+			fmt.Printf("Warning: Failed to audit the working directory: %v\n", err)
+		}
+		lc.baseline = snapshot
+	}
+
+	for _, path := range lc.Config.Watch {
+		snapshot, err := workspace.TakeSnapshot(path)
+		if err != nil {
+			fmt.Printf("Warning: Failed to audit %s: %v\n", path, err)
+			continue
+		}
+		lc.watched = append(lc.watched, snapshot)
+	}
+}
+
+// auditFiles diffs the audited paths against their snapshots. Changes in the
+// working directory are relative to it, changes in watch paths include the path.
+func (lc *LoopController) auditFiles() {
+	var changes []workspace.Change
+
+	if lc.baseline != nil {
+		root := lc.baseline.Root
+		if lc.workspace != nil {
+			root = lc.workspace.Root
+		}
+		if after, err := workspace.TakeSnapshot(root); err != nil {
+			fmt.Printf("Warning: Failed to audit the working directory: %v\n", err)
+		} else {
+			changes = append(changes, lc.baseline.Diff(after)...)
+		}
+	}
+
+	for _, before := range lc.watched {
+		after, err := workspace.TakeSnapshot(before.Root)
+		if err != nil {
+			fmt.Printf("Warning: Failed to audit %s: %v\n", before.Root, err)
+			continue
+		}
+		for _, change := range before.Diff(after) {
+			change.Path = filepath.Join(before.Root, change.Path)
+			changes = append(changes, change)
+		}
+	}
+
+	lc.Stats.FileChanges = changes
+}
+
+// removeWorkspace deletes the run's working directory
+func (lc *LoopController) removeWorkspace() {
+	if err := lc.workspace.Remove(); err != nil {
+		fmt.Printf("Warning: Failed to remove workspace %s: %v\n", lc.workspace.Dir, err)
+	}
+}
+
+// writeJSONReport saves the JSON report if requested
+func (lc *LoopController) writeJSONReport() {
+	if lc.Config.JSONReportPath == "" {
+		return
+	}
+	if err := reporter.WriteJSONReport(lc.Config.JSONReportPath, lc.Config, lc.Stats); err != nil {
+		fmt.Printf("Warning: Failed to save JSON report: %v\n", err)
+	} else {
+		fmt.Printf("JSON report written to %s\n", lc.Config.JSONReportPath)
+	}
+}
+
+// shouldContinue determines if the loop should continue
+func (lc *LoopController) shouldContinue() bool {
+	if lc.Config.PrePaidMode {
+		// In prepaid mode, continue until CPU credits are exhausted
+		return lc.UsedCpuTime < lc.Config.CpuCredit
+	} else {
+		// In postpaid mode, continue until CPU limit is reached
+		return lc.UsedCpuTime < float64(lc.Config.CpuLimit)
+	}
+}
+
+// updateStats updates the overall statistics
+func (lc *LoopController) updateStats(result *monitor.Stats) {
+	// Update max memory usage
+	if result.MaxMemoryKB > lc.Stats.MaxMemoryKB {
+		lc.Stats.MaxMemoryKB = result.MaxMemoryKB
+	}
+
+	// Update peak counts
+	if result.PeakThreads > lc.Stats.PeakThreads {
+		lc.Stats.PeakThreads = result.PeakThreads
+	}
+	if result.PeakFDs > lc.Stats.PeakFDs {
+		lc.Stats.PeakFDs = result.PeakFDs
+	}
+	if result.PeakProcesses > lc.Stats.PeakProcesses {
+		lc.Stats.PeakProcesses = result.PeakProcesses
+	}
+
+	lc.Stats.Identity = result.Identity
+	lc.Stats.ProcessEvents = result.ProcessEvents
+	lc.Stats.Network = result.Network
+	lc.Stats.CpuRateControl = result.CpuRateControl
+	lc.Stats.ThrottledTime += result.ThrottledTime
+
+	// Update termination reason if set
+	if result.TermReason != "" {
+		lc.Stats.TermReason = result.TermReason
+	}
+	for reason, hits := range result.LimitHits {
+		if lc.Stats.LimitHits == nil {
+			lc.Stats.LimitHits = make(map[string]int)
+		}
+		lc.Stats.LimitHits[reason] += hits
+	}
+
+	// Accumulate I/O
+	lc.Stats.IO.Add(result.IO)
+
+	// Accumulate the time spent in each process state and wait channel
+	if lc.Stats.StateTime == nil {
+		lc.Stats.StateTime = make(map[string]time.Duration)
+		lc.Stats.WaitTime = make(map[string]time.Duration)
+	}
+	for state, d := range result.StateTime {
+		lc.Stats.StateTime[state] += d
+	}
+	for wchan, d := range result.WaitTime {
+		lc.Stats.WaitTime[wchan] += d
+	}
+	if len(result.Diagnostics) > 0 {
+		lc.Stats.Diagnostics = result.Diagnostics
+	}
+
+	// Accumulate sampling statistics
+	lc.Stats.SampleCount += result.SampleCount
+	lc.Stats.SamplingOverhead += result.SamplingOverhead
+	lc.Stats.Samples = append(lc.Stats.Samples, result.Samples...)
+	lc.Stats.Processes = append(lc.Stats.Processes, result.Processes...)
+
+	// Update exit code if non-zero
+	if result.ExitCode != 0 {
+		lc.Stats.ExitCode = result.ExitCode
+	}
+}
@@ -0,0 +1,201 @@
+package loopcontrol
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCapturedOutput is how much of each output stream is kept for the success criteria
+const maxCapturedOutput = 16 * 1024 * 1024
+
+// successCommandTimeout bounds how long the success command may run
+const successCommandTimeout = time.Minute
+
+// outputCapture keeps the start of an output stream so it can be matched after the run
+type outputCapture struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+// Write keeps up to maxCapturedOutput bytes and discards the rest
+func (c *outputCapture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if room := maxCapturedOutput - len(c.data); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		c.data = append(c.data, p[:room]...)
+	}
+	return len(p), nil
+}
+
+// String returns the captured output
+func (c *outputCapture) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return string(c.data)
+}
+
+// captureOutput tees the process output into buffers when the success criteria look at it
+func (lc *LoopController) captureOutput() {
+	config := lc.Config
+	if config.StdoutMustMatch == "" && config.StdoutMustNotMatch == "" &&
+		config.StderrMustMatch == "" && config.StderrMustNotMatch == "" {
+		return
+	}
+
+	lc.stdout, lc.stderr = &outputCapture{}, &outputCapture{}
+	lc.Executor.Stdout = teeWriter(lc.Executor.Stdout, lc.stdout)
+	lc.Executor.Stderr = teeWriter(lc.Executor.Stderr, lc.stderr)
+}
+
+// teeWriter writes to both writers, or only to the capture when there is no destination
+func teeWriter(dst io.Writer, capture *outputCapture) io.Writer {
+	if dst == nil {
+		return capture
+	}
+	return io.MultiWriter(dst, capture)
+}
+
+// trackOutput reports every write to stdout or stderr to the monitor for --output-timeout.
+// The output then goes through a pipe rather than straight to KernelScope's terminal.
+func (lc *LoopController) trackOutput() {
+	if lc.Config.OutputTimeout <= 0 {
+		return
+	}
+	lc.Executor.Stdout = &activityWriter{dst: lc.Executor.Stdout, touch: lc.Monitor.RecordOutput}
+	lc.Executor.Stderr = &activityWriter{dst: lc.Executor.Stderr, touch: lc.Monitor.RecordOutput}
+}
+
+// activityWriter passes writes through and records that output happened
+type activityWriter struct {
+	dst   io.Writer // May be nil to discard the output
+	touch func()
+}
+
+// Write records the activity and forwards the data
+func (w *activityWriter) Write(p []byte) (int, error) {
+	w.touch()
+	if w.dst == nil {
+		return len(p), nil
+	}
+	return w.dst.Write(p)
+}
+
+// evaluateSuccess applies the success criteria to the finished iteration and explains the result
+func (lc *LoopController) evaluateSuccess() (bool, string) {
+	config := lc.Config
+	stats := lc.Stats
+
+	if stats.TermReason != "" {
+		return false, "terminated: " + stats.TermReason
+	}
+
+	var passed []string
+
+	// Exit code
+	accepted := config.SuccessExitCodes
+	if len(accepted) == 0 {
+		accepted = []int{0}
+	}
+	exitOK := false
+	for _, code := range accepted {
+		if stats.ExitCode == code {
+			exitOK = true
+			break
+		}
+	}
+	if !exitOK {
+		return false, fmt.Sprintf("exit code %d not in accepted codes %s", stats.ExitCode, formatCodes(accepted))
+	}
+	passed = append(passed, fmt.Sprintf("exit code %d accepted", stats.ExitCode))
+
+	// Output patterns
+	checks := []struct {
+		stream  string
+		pattern string
+		capture *outputCapture
+		must    bool
+	}{
+		{"stdout", config.StdoutMustMatch, lc.stdout, true},
+		{"stdout", config.StdoutMustNotMatch, lc.stdout, false},
+		{"stderr", config.StderrMustMatch, lc.stderr, true},
+		{"stderr", config.StderrMustNotMatch, lc.stderr, false},
+	}
+	for _, check := range checks {
+		if check.pattern == "" {
+			continue
+		}
+		re := regexp.MustCompile(check.pattern) // Validated with the configuration
+		matched := check.capture != nil && re.MatchString(check.capture.String())
+		switch {
+		case check.must && !matched:
+			return false, fmt.Sprintf("%s does not match /%s/", check.stream, check.pattern)
+		case !check.must && matched:
+			return false, fmt.Sprintf("%s matches forbidden /%s/", check.stream, check.pattern)
+		case check.must:
+			passed = append(passed, fmt.Sprintf("%s matches /%s/", check.stream, check.pattern))
+		default:
+			passed = append(passed, fmt.Sprintf("%s free of /%s/", check.stream, check.pattern))
+		}
+	}
+
+	// Output file, relative to the workspace if there is one
+	if config.SuccessFile != "" {
+		path := config.SuccessFile
+		if lc.workspace != nil && !filepath.IsAbs(path) {
+			path = filepath.Join(lc.workspace.Root, path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return false, fmt.Sprintf("output file %s missing", config.SuccessFile)
+		}
+		passed = append(passed, fmt.Sprintf("output file %s exists", config.SuccessFile))
+	}
+
+	// Checker command
+	if config.SuccessCommand != "" {
+		if ok, detail := lc.runSuccessCommand(); !ok {
+			return false, detail
+		}
+		passed = append(passed, "success command passed")
+	}
+
+	return true, strings.Join(passed, "; ")
+}
+
+// runSuccessCommand runs the success command through the shell, confined like the
+// binary. The iteration's exit code is passed in KERNELSCOPE_EXIT_CODE; the command
+// accepts the run by exiting with 0.
+func (lc *LoopController) runSuccessCommand() (bool, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), successCommandTimeout)
+	defer cancel()
+
+	env := []string{"KERNELSCOPE_EXIT_CODE=" + strconv.Itoa(lc.Stats.ExitCode)}
+	out, err := lc.Executor.RunCommand(ctx, lc.Config.SuccessCommand, env, lc.workspace)
+	if err != nil {
+		detail := fmt.Sprintf("success command failed: %v", err)
+		if message := strings.TrimSpace(string(out)); message != "" {
+			detail += ": " + message
+		}
+		return false, detail
+	}
+	return true, ""
+}
+
+// formatCodes formats a list of exit codes as [0 3]
+func formatCodes(codes []int) string {
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = strconv.Itoa(code)
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
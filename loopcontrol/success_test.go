@@ -0,0 +1,120 @@
+package loopcontrol
+
+import (
+	"kernelscope/cli"
+	"kernelscope/executor"
+	"kernelscope/monitor"
+	"kernelscope/workspace"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestEvaluateSuccess(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the success command runs through /bin/sh")
+	}
+
+	tests := []struct {
+		name       string
+		config     cli.Config
+		exitCode   int
+		termReason string
+		stdout     string
+		stderr     string
+		files      []string // Created in the workspace before the criteria are checked
+		want       bool
+		wantDetail string
+	}{
+		{name: "default exit code", want: true, wantDetail: "exit code 0 accepted"},
+		{name: "non-zero exit code", exitCode: 1, wantDetail: "exit code 1 not in accepted codes [0]"},
+		{name: "terminated", termReason: "Timeout", wantDetail: "terminated: Timeout"},
+		{name: "exit code list", config: cli.Config{SuccessExitCodes: []int{0, 3}}, exitCode: 3, want: true, wantDetail: "exit code 3 accepted"},
+		{name: "exit code not listed", config: cli.Config{SuccessExitCodes: []int{1, 2}}, wantDetail: "exit code 0 not in accepted codes [1 2]"},
+		{
+			name:       "stdout matches",
+			config:     cli.Config{StdoutMustMatch: `^done \d+`},
+			stdout:     "done 42\n",
+			want:       true,
+			wantDetail: `exit code 0 accepted; stdout matches /^done \d+/`,
+		},
+		{name: "stdout does not match", config: cli.Config{StdoutMustMatch: `^done`}, stdout: "failed\n", wantDetail: "stdout does not match /^done/"},
+		{
+			name:       "stderr free of rejected pattern",
+			config:     cli.Config{StderrMustNotMatch: `(?i)error`},
+			stderr:     "warning: slow\n",
+			want:       true,
+			wantDetail: "exit code 0 accepted; stderr free of /(?i)error/",
+		},
+		{name: "stderr matches rejected pattern", config: cli.Config{StderrMustNotMatch: `(?i)error`}, stderr: "ERROR: disk full\n", wantDetail: "stderr matches forbidden /(?i)error/"},
+		{name: "stdout rejected", config: cli.Config{StdoutMustMatch: "ok", StdoutMustNotMatch: "panic"}, stdout: "ok\npanic\n", wantDetail: "stdout matches forbidden /panic/"},
+		{name: "stderr must match", config: cli.Config{StderrMustMatch: "ready"}, wantDetail: "stderr does not match /ready/"},
+		{
+			name:       "success file in the workspace",
+			config:     cli.Config{SuccessFile: "out/result.txt"},
+			files:      []string{"out/result.txt"},
+			want:       true,
+			wantDetail: "exit code 0 accepted; output file out/result.txt exists",
+		},
+		{name: "success file missing", config: cli.Config{SuccessFile: "result.txt"}, wantDetail: "output file result.txt missing"},
+		{
+			name:       "success command passes",
+			config:     cli.Config{SuccessCommand: `test -f result.txt && test "$KERNELSCOPE_EXIT_CODE" = 2`, SuccessExitCodes: []int{2}},
+			exitCode:   2,
+			files:      []string{"result.txt"},
+			want:       true,
+			wantDetail: "exit code 2 accepted; success command passed",
+		},
+		{
+			name:       "success command fails",
+			config:     cli.Config{SuccessCommand: "echo 'wrong answer' >&2; exit 4"},
+			wantDetail: "success command failed: exit status 4: wrong answer",
+		},
+		{
+			name:       "criteria checked in order",
+			config:     cli.Config{StdoutMustMatch: "ok", SuccessFile: "result.txt", SuccessCommand: "exit 1"},
+			stdout:     "ok\n",
+			wantDetail: "output file result.txt missing",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ws, err := workspace.Create("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ws.Remove()
+			for _, name := range test.files {
+				path := filepath.Join(ws.Dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config := test.config
+			config.Workspace = true
+			config.AllowRoot = true
+			lc := &LoopController{
+				Config:    &config,
+				Executor:  &executor.Executor{Config: &config},
+				Stats:     &monitor.Stats{ExitCode: test.exitCode, TermReason: test.termReason},
+				workspace: ws,
+			}
+			lc.captureOutput()
+			if lc.stdout != nil {
+				lc.stdout.Write([]byte(test.stdout))
+				lc.stderr.Write([]byte(test.stderr))
+			}
+
+			success, detail := lc.evaluateSuccess()
+			if success != test.want || detail != test.wantDetail {
+				t.Errorf("evaluateSuccess = %v, %q, want %v, %q", success, detail, test.want, test.wantDetail)
+			}
+		})
+	}
+}
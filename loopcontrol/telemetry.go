@@ -0,0 +1,116 @@
+package loopcontrol
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"kernelscope/monitor"
+	"kernelscope/otlp"
+	"strings"
+)
+
+// startSpan starts a span if OTLP export is enabled
+func (lc *LoopController) startSpan(name string, parent *otlp.Span) *otlp.Span {
+	if lc.Telemetry == nil {
+		return nil
+	}
+
+	span := lc.Telemetry.StartSpan(name, parent)
+	span.SetAttribute("kernelscope.binary", lc.Config.BinaryPath)
+	span.SetAttribute("kernelscope.args_hash", argsHash(lc.Config.BinaryPath, lc.Config.Args))
+	span.SetAttribute("kernelscope.mode", modeName(lc.Config.PrePaidMode))
+	return span
+}
+
+// finishSpan records the outcome of a run or iteration on its span
+func (lc *LoopController) finishSpan(span *otlp.Span, stats *monitor.Stats) {
+	if span == nil {
+		return
+	}
+
+	span.SetAttribute("process.exit_code", stats.ExitCode)
+	span.SetAttribute("kernelscope.cpu_seconds", stats.CpuTimeUsed)
+	span.SetAttribute("kernelscope.peak_memory_kb", stats.MaxMemoryKB)
+	span.SetAttribute("kernelscope.io.read_bytes", stats.IO.ReadBytes)
+	span.SetAttribute("kernelscope.io.write_bytes", stats.IO.WriteBytes)
+	span.SetAttribute("kernelscope.peak_threads", stats.PeakThreads)
+	span.SetAttribute("kernelscope.peak_processes", stats.PeakProcesses)
+
+	if stats.TermReason != "" {
+		span.SetAttribute("kernelscope.termination_reason", stats.TermReason)
+	}
+	if stats.SuccessReason != "" {
+		span.SetAttribute("kernelscope.success_reason", stats.SuccessReason)
+	}
+
+	if stats.TermReason != "" {
+		span.SetStatus(otlp.StatusError, stats.TermReason)
+	} else if stats.SuccessReason != "" {
+		if stats.SuccessCount > 0 {
+			span.SetStatus(otlp.StatusOk, "")
+		} else {
+			span.SetStatus(otlp.StatusError, stats.SuccessReason)
+		}
+	} else if stats.ExitCode != 0 {
+		span.SetStatus(otlp.StatusError, fmt.Sprintf("exit code %d", stats.ExitCode))
+	} else {
+		span.SetStatus(otlp.StatusOk, "")
+	}
+
+	span.Finish()
+}
+
+// exportTelemetry sends the finished spans and the sampled metrics to the collector
+func (lc *LoopController) exportTelemetry() {
+	if lc.Telemetry == nil {
+		return
+	}
+
+	if err := lc.Telemetry.ExportSpans(); err != nil {
+		fmt.Printf("Warning: Failed to export OTLP spans: %v\n", err)
+	}
+	if err := lc.Telemetry.ExportMetrics(sampleGauges(lc.Config.BinaryPath, lc.Stats.Samples)); err != nil {
+		fmt.Printf("Warning: Failed to export OTLP metrics: %v\n", err)
+	} else {
+		fmt.Printf("Telemetry exported to %s\n", lc.Telemetry.Endpoint)
+	}
+}
+
+// sampleGauges converts the sampled time series into OTLP gauges
+func sampleGauges(binary string, samples []monitor.Sample) []*otlp.Gauge {
+	cpu := &otlp.Gauge{Name: "kernelscope.cpu.time", Description: "CPU time used by the process tree", Unit: "s"}
+	rss := &otlp.Gauge{Name: "kernelscope.memory.rss", Description: "Resident memory of the process tree", Unit: "By", Integer: true}
+	threads := &otlp.Gauge{Name: "kernelscope.threads", Description: "Threads in the process tree", Unit: "{thread}", Integer: true}
+	fds := &otlp.Gauge{Name: "kernelscope.open_fds", Description: "Open file descriptors in the process tree", Unit: "{fd}", Integer: true}
+	readBytes := &otlp.Gauge{Name: "kernelscope.io.read", Description: "Bytes read from storage by the process tree", Unit: "By", Integer: true}
+	writeBytes := &otlp.Gauge{Name: "kernelscope.io.write", Description: "Bytes written to storage by the process tree", Unit: "By", Integer: true}
+
+	attributes := map[string]interface{}{"kernelscope.binary": binary}
+	for _, sample := range samples {
+		point := func(value float64) otlp.DataPoint {
+			return otlp.DataPoint{Time: sample.Time, Value: value, Attributes: attributes}
+		}
+		cpu.Points = append(cpu.Points, point(sample.CpuTime))
+		rss.Points = append(rss.Points, point(float64(sample.MemoryKB*1024)))
+		threads.Points = append(threads.Points, point(float64(sample.Threads)))
+		fds.Points = append(fds.Points, point(float64(sample.FDs)))
+		readBytes.Points = append(readBytes.Points, point(float64(sample.IO.ReadBytes)))
+		writeBytes.Points = append(writeBytes.Points, point(float64(sample.IO.WriteBytes)))
+	}
+
+	return []*otlp.Gauge{cpu, rss, threads, fds, readBytes, writeBytes}
+}
+
+// argsHash identifies a command line without exposing its arguments
+func argsHash(binary string, args []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(append([]string{binary}, args...), "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// modeName returns the billing mode name
+func modeName(prepaid bool) string {
+	if prepaid {
+		return "prepaid"
+	}
+	return "postpaid"
+}
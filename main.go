@@ -2,15 +2,35 @@ package main
 
 import (
 	"fmt"
+	"kernelscope/batch"
 	"kernelscope/cli"
+	"kernelscope/daemon"
 	"kernelscope/executor"
+	"kernelscope/judge"
 	"kernelscope/loopcontrol"
+	"kernelscope/metrics"
 	"kernelscope/monitor"
 	"os"
 	"runtime"
 )
 
 func main() {
+	// Dispatch subcommands before parsing the run flags
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case executor.SandboxInitArg:
+			executor.SandboxInit()
+		case "serve":
+			runServe(os.Args[2:])
+		case "watch":
+			runWatch(os.Args[2:])
+		case "batch":
+			runBatch(os.Args[2:])
+		case "judge":
+			runJudge(os.Args[2:])
+		}
+	}
+	
 	fmt.Println("KernelScope - Process Execution and Monitoring System")
 	
 	// Check if running on Linux
@@ -31,6 +51,15 @@ func main() {
 	// Initialize the monitor
 	mon := monitor.NewMonitor(config)
 	
+	// Serve live metrics if requested
+	if config.MetricsAddr != "" {
+		exporter := metrics.NewExporter(config.MetricsAddr)
+		exporter.Register(map[string]string{"binary": config.BinaryPath}, config, mon)
+		if err := exporter.Start(); err != nil {
+			fmt.Printf("Warning: Failed to start metrics server: %v\n", err)
+		}
+	}
+	
 	// Initialize the loop controller
 	loopCtrl := loopcontrol.NewLoopController(config, exec, mon)
 	
@@ -39,4 +68,76 @@ func main() {
 	
 	fmt.Println("KernelScope execution completed")
 	os.Exit(0)
-} 
\ No newline at end of file
+}
+
+// runServe runs the job daemon: kernelscope serve [options]
+func runServe(args []string) {
+	server := daemon.NewServer(cli.ParseServeArgs(args))
+	if err := server.ListenAndServe(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// runWatch follows a daemon job and exits with its exit code: kernelscope watch [options] <job>
+func runWatch(args []string) {
+	watchConfig := cli.ParseWatchArgs(args)
+	exitCode, err := daemon.Watch(watchConfig.Server, watchConfig.Token, watchConfig.JobID, watchConfig.ShowSamples)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if exitCode < 0 {
+		exitCode = 1
+	}
+	os.Exit(exitCode)
+}
+
+// runBatch runs every job of a manifest and exits non-zero if any job failed: kernelscope batch [options] <jobs.yaml>
+func runBatch(args []string) {
+	batchConfig := cli.ParseBatchArgs(args)
+	report, err := batch.Run(batchConfig)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	
+	report.Print()
+	if batchConfig.JSONReportPath != "" {
+		if err := report.WriteJSON(batchConfig.JSONReportPath); err != nil {
+			fmt.Printf("Warning: Failed to save JSON report: %v\n", err)
+		} else {
+			fmt.Printf("JSON report written to %s\n", batchConfig.JSONReportPath)
+		}
+	}
+	
+	if report.Totals.Failed > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// runJudge judges a binary against a directory of test cases and exits non-zero unless every case is accepted: kernelscope judge [options]
+func runJudge(args []string) {
+	judgeConfig := cli.ParseJudgeArgs(args)
+	report, err := judge.Run(judgeConfig)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	
+	report.Print()
+	if judgeConfig.JSONReportPath != "" {
+		if err := report.WriteJSON(judgeConfig.JSONReportPath); err != nil {
+			fmt.Printf("Warning: Failed to save JSON report: %v\n", err)
+		} else {
+			fmt.Printf("JSON report written to %s\n", judgeConfig.JSONReportPath)
+		}
+	}
+	
+	if report.Verdict != judge.Accepted {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
\ No newline at end of file
@@ -0,0 +1,265 @@
+package metrics
+
+import (
+	"fmt"
+	"kernelscope/cli"
+	"kernelscope/monitor"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Content types for the two exposition formats
+const (
+	prometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// target is a monitored run exported by the server
+type target struct {
+	labels  map[string]string
+	config  *cli.Config
+	monitor *monitor.Monitor
+}
+
+// Exporter serves live run statistics in the Prometheus exposition format
+type Exporter struct {
+	Addr    string
+	mu      sync.Mutex
+	targets []*target
+	server  *http.Server
+}
+
+// NewExporter creates a metrics exporter listening on addr
+func NewExporter(addr string) *Exporter {
+	return &Exporter{
+		Addr: addr,
+	}
+}
+
+// Register adds a monitor to the exported runs. The labels distinguish it from other runs.
+func (e *Exporter) Register(labels map[string]string, config *cli.Config, mon *monitor.Monitor) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.targets = append(e.targets, &target{labels: labels, config: config, monitor: mon})
+}
+
+// Unregister removes a previously registered monitor
+func (e *Exporter) Unregister(mon *monitor.Monitor) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, t := range e.targets {
+		if t.monitor == mon {
+			e.targets = append(e.targets[:i], e.targets[i+1:]...)
+			return
+		}
+	}
+}
+
+// Handler returns the HTTP handler serving the metrics, for mounting on another server
+func (e *Exporter) Handler() http.Handler {
+	return http.HandlerFunc(e.handleMetrics)
+}
+
+// Start starts serving /metrics in the background
+func (e *Exporter) Start() error {
+	listener, err := net.Listen("tcp", e.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", e.Addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e.Handler())
+	e.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := e.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("Metrics server error: %v\n", err)
+		}
+	}()
+
+	fmt.Printf("Serving metrics on http://%s/metrics\n", listener.Addr())
+	return nil
+}
+
+// Stop shuts down the metrics server
+func (e *Exporter) Stop() {
+	if e.server != nil {
+		e.server.Close()
+	}
+}
+
+// handleMetrics writes the metrics in Prometheus or OpenMetrics format depending on the Accept header
+func (e *Exporter) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", openMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", prometheusContentType)
+	}
+
+	e.mu.Lock()
+	targets := append([]*target(nil), e.targets...)
+	e.mu.Unlock()
+
+	fmt.Fprint(w, render(targets, openMetrics))
+}
+
+// family is a metric family with its samples
+type family struct {
+	name    string
+	help    string
+	counter bool
+	samples []sample
+}
+
+// sample is a single labelled value
+type sample struct {
+	labels map[string]string
+	value  float64
+}
+
+// render renders the metrics of all targets
+func render(targets []*target, openMetrics bool) string {
+	families := []*family{
+		{name: "kernelscope_running", help: "Whether the process is currently being monitored"},
+		{name: "kernelscope_cpu_seconds", help: "CPU time used by the live process tree; drops when children exit"},
+		{name: "kernelscope_cpu_used_seconds", help: "CPU time used by every process of the tree, including ones that exited", counter: true},
+		{name: "kernelscope_memory_rss_bytes", help: "Resident memory of the process tree at the last sample"},
+		{name: "kernelscope_memory_peak_bytes", help: "Peak resident memory of the process tree"},
+		{name: "kernelscope_io_read_bytes", help: "Bytes read from storage by the process tree", counter: true},
+		{name: "kernelscope_io_write_bytes", help: "Bytes written to storage by the process tree", counter: true},
+		{name: "kernelscope_threads", help: "Threads in the process tree at the last sample"},
+		{name: "kernelscope_open_fds", help: "Open file descriptors in the process tree at the last sample"},
+		{name: "kernelscope_processes", help: "Processes in the process tree at the last sample"},
+		{name: "kernelscope_cpu_credit_remaining_seconds", help: "CPU credit left in prepaid mode"},
+		{name: "kernelscope_samples", help: "Resource usage samples taken", counter: true},
+		{name: "kernelscope_limit_hits", help: "Times a limit terminated the process, by reason", counter: true},
+		{name: "kernelscope_terminated", help: "Set to 1 with the reason the process was terminated"},
+	}
+	byName := make(map[string]*family, len(families))
+	for _, f := range families {
+		byName[f.name] = f
+	}
+	add := func(name string, labels map[string]string, value float64) {
+		byName[name].samples = append(byName[name].samples, sample{labels: labels, value: value})
+	}
+
+	for _, t := range targets {
+		stats := t.monitor.Snapshot()
+		labels := t.labels
+
+		running := 0.0
+		if stats.Running {
+			running = 1
+		}
+		add("kernelscope_running", labels, running)
+		add("kernelscope_cpu_seconds", labels, stats.CpuTimeUsed)
+		add("kernelscope_cpu_used_seconds", labels, stats.TotalCpuTime)
+		add("kernelscope_memory_peak_bytes", labels, float64(stats.MaxMemoryKB)*1024)
+		add("kernelscope_io_read_bytes", labels, float64(stats.IO.ReadBytes))
+		add("kernelscope_io_write_bytes", labels, float64(stats.IO.WriteBytes))
+		add("kernelscope_samples", labels, float64(stats.SampleCount))
+
+		if len(stats.Samples) > 0 {
+			last := stats.Samples[len(stats.Samples)-1]
+			add("kernelscope_memory_rss_bytes", labels, float64(last.MemoryKB)*1024)
+			add("kernelscope_threads", labels, float64(last.Threads))
+			add("kernelscope_open_fds", labels, float64(last.FDs))
+			add("kernelscope_processes", labels, float64(len(last.Processes)))
+		}
+
+		if t.config.PrePaidMode {
+			remaining := t.config.CpuCredit - stats.TotalCpuTime
+			if remaining < 0 {
+				remaining = 0
+			}
+			add("kernelscope_cpu_credit_remaining_seconds", labels, remaining)
+		}
+
+		reasons := make([]string, 0, len(stats.LimitHits))
+		for reason := range stats.LimitHits {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			add("kernelscope_limit_hits", withLabel(labels, "reason", reason), float64(stats.LimitHits[reason]))
+		}
+
+		if stats.TermReason != "" {
+			add("kernelscope_terminated", withLabel(labels, "reason", stats.TermReason), 1)
+		}
+	}
+
+	var b strings.Builder
+	for _, f := range families {
+		writeFamily(&b, f, openMetrics)
+	}
+	if openMetrics {
+		b.WriteString("# EOF\n")
+	}
+	return b.String()
+}
+
+// writeFamily writes the HELP, TYPE and sample lines of a metric family
+func writeFamily(b *strings.Builder, f *family, openMetrics bool) {
+	metricType, sampleName := "gauge", f.name
+	if f.counter {
+		metricType, sampleName = "counter", f.name+"_total"
+	}
+
+	// Prometheus text format names counter families after their samples
+	familyName := f.name
+	if !openMetrics {
+		familyName = sampleName
+	}
+
+	fmt.Fprintf(b, "# HELP %s %s\n", familyName, f.help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", familyName, metricType)
+	for _, s := range f.samples {
+		fmt.Fprintf(b, "%s%s %s\n", sampleName, formatLabels(s.labels), formatValue(s.value))
+	}
+}
+
+// withLabel returns a copy of labels with an extra label
+func withLabel(labels map[string]string, name, value string) map[string]string {
+	result := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		result[k] = v
+	}
+	result[name] = value
+	return result
+}
+
+// formatLabels formats labels as {a="1",b="2"} in sorted order
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", name, escapeLabelValue(labels[name])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// escapeLabelValue escapes backslashes, quotes and newlines in a label value
+func escapeLabelValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return strings.ReplaceAll(value, "\n", `\n`)
+}
+
+// formatValue formats a sample value
+func formatValue(value float64) string {
+	return fmt.Sprintf("%g", value)
+}
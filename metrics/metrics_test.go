@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"kernelscope/cli"
+	"kernelscope/monitor"
+	"kernelscope/resource"
+	"kernelscope/utils"
+	"net/http/httptest"
+	"testing"
+)
+
+// testTargets are a finished prepaid run that hit a limit and a run that has not sampled yet
+func testTargets() []*target {
+	finished := &monitor.Monitor{Stats: &monitor.Stats{
+		CpuTimeUsed:  1.5,
+		TotalCpuTime: 2.5,
+		MaxMemoryKB:  2048,
+		IO:           utils.ProcIO{ReadBytes: 100, WriteBytes: 200},
+		SampleCount:  4,
+		Samples: []monitor.Sample{
+			{MemoryKB: 1024, Threads: 3, FDs: 5, Processes: []resource.ProcessUsage{{Pid: 1}, {Pid: 2}}},
+		},
+		LimitHits:  map[string]int{"CPU quota exceeded": 1, "Memory limit exceeded": 2},
+		TermReason: "CPU quota exceeded",
+	}}
+	started := &monitor.Monitor{Stats: &monitor.Stats{Running: true}}
+
+	return []*target{
+		{labels: map[string]string{"binary": "/bin/a \"quoted\" \\ name\nline", "job": "1"}, config: &cli.Config{PrePaidMode: true, CpuCredit: 2}, monitor: finished},
+		{labels: map[string]string{"binary": "/bin/b"}, config: &cli.Config{}, monitor: started},
+	}
+}
+
+const wantPrometheus = `# HELP kernelscope_running Whether the process is currently being monitored
+# TYPE kernelscope_running gauge
+kernelscope_running{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 0
+kernelscope_running{binary="/bin/b"} 1
+# HELP kernelscope_cpu_seconds CPU time used by the live process tree; drops when children exit
+# TYPE kernelscope_cpu_seconds gauge
+kernelscope_cpu_seconds{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 1.5
+kernelscope_cpu_seconds{binary="/bin/b"} 0
+# HELP kernelscope_cpu_used_seconds_total CPU time used by every process of the tree, including ones that exited
+# TYPE kernelscope_cpu_used_seconds_total counter
+kernelscope_cpu_used_seconds_total{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 2.5
+kernelscope_cpu_used_seconds_total{binary="/bin/b"} 0
+# HELP kernelscope_memory_rss_bytes Resident memory of the process tree at the last sample
+# TYPE kernelscope_memory_rss_bytes gauge
+kernelscope_memory_rss_bytes{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 1.048576e+06
+# HELP kernelscope_memory_peak_bytes Peak resident memory of the process tree
+# TYPE kernelscope_memory_peak_bytes gauge
+kernelscope_memory_peak_bytes{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 2.097152e+06
+kernelscope_memory_peak_bytes{binary="/bin/b"} 0
+# HELP kernelscope_io_read_bytes_total Bytes read from storage by the process tree
+# TYPE kernelscope_io_read_bytes_total counter
+kernelscope_io_read_bytes_total{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 100
+kernelscope_io_read_bytes_total{binary="/bin/b"} 0
+# HELP kernelscope_io_write_bytes_total Bytes written to storage by the process tree
+# TYPE kernelscope_io_write_bytes_total counter
+kernelscope_io_write_bytes_total{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 200
+kernelscope_io_write_bytes_total{binary="/bin/b"} 0
+# HELP kernelscope_threads Threads in the process tree at the last sample
+# TYPE kernelscope_threads gauge
+kernelscope_threads{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 3
+# HELP kernelscope_open_fds Open file descriptors in the process tree at the last sample
+# TYPE kernelscope_open_fds gauge
+kernelscope_open_fds{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 5
+# HELP kernelscope_processes Processes in the process tree at the last sample
+# TYPE kernelscope_processes gauge
+kernelscope_processes{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 2
+# HELP kernelscope_cpu_credit_remaining_seconds CPU credit left in prepaid mode
+# TYPE kernelscope_cpu_credit_remaining_seconds gauge
+kernelscope_cpu_credit_remaining_seconds{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 0
+# HELP kernelscope_samples_total Resource usage samples taken
+# TYPE kernelscope_samples_total counter
+kernelscope_samples_total{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 4
+kernelscope_samples_total{binary="/bin/b"} 0
+# HELP kernelscope_limit_hits_total Times a limit terminated the process, by reason
+# TYPE kernelscope_limit_hits_total counter
+kernelscope_limit_hits_total{binary="/bin/a \"quoted\" \\ name\nline",job="1",reason="CPU quota exceeded"} 1
+kernelscope_limit_hits_total{binary="/bin/a \"quoted\" \\ name\nline",job="1",reason="Memory limit exceeded"} 2
+# HELP kernelscope_terminated Set to 1 with the reason the process was terminated
+# TYPE kernelscope_terminated gauge
+kernelscope_terminated{binary="/bin/a \"quoted\" \\ name\nline",job="1",reason="CPU quota exceeded"} 1
+`
+
+// OpenMetrics names counter families without _total and ends with # EOF
+const wantOpenMetrics = `# HELP kernelscope_running Whether the process is currently being monitored
+# TYPE kernelscope_running gauge
+kernelscope_running{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 0
+kernelscope_running{binary="/bin/b"} 1
+# HELP kernelscope_cpu_seconds CPU time used by the live process tree; drops when children exit
+# TYPE kernelscope_cpu_seconds gauge
+kernelscope_cpu_seconds{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 1.5
+kernelscope_cpu_seconds{binary="/bin/b"} 0
+# HELP kernelscope_cpu_used_seconds CPU time used by every process of the tree, including ones that exited
+# TYPE kernelscope_cpu_used_seconds counter
+kernelscope_cpu_used_seconds_total{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 2.5
+kernelscope_cpu_used_seconds_total{binary="/bin/b"} 0
+# HELP kernelscope_memory_rss_bytes Resident memory of the process tree at the last sample
+# TYPE kernelscope_memory_rss_bytes gauge
+kernelscope_memory_rss_bytes{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 1.048576e+06
+# HELP kernelscope_memory_peak_bytes Peak resident memory of the process tree
+# TYPE kernelscope_memory_peak_bytes gauge
+kernelscope_memory_peak_bytes{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 2.097152e+06
+kernelscope_memory_peak_bytes{binary="/bin/b"} 0
+# HELP kernelscope_io_read_bytes Bytes read from storage by the process tree
+# TYPE kernelscope_io_read_bytes counter
+kernelscope_io_read_bytes_total{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 100
+kernelscope_io_read_bytes_total{binary="/bin/b"} 0
+# HELP kernelscope_io_write_bytes Bytes written to storage by the process tree
+# TYPE kernelscope_io_write_bytes counter
+kernelscope_io_write_bytes_total{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 200
+kernelscope_io_write_bytes_total{binary="/bin/b"} 0
+# HELP kernelscope_threads Threads in the process tree at the last sample
+# TYPE kernelscope_threads gauge
+kernelscope_threads{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 3
+# HELP kernelscope_open_fds Open file descriptors in the process tree at the last sample
+# TYPE kernelscope_open_fds gauge
+kernelscope_open_fds{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 5
+# HELP kernelscope_processes Processes in the process tree at the last sample
+# TYPE kernelscope_processes gauge
+kernelscope_processes{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 2
+# HELP kernelscope_cpu_credit_remaining_seconds CPU credit left in prepaid mode
+# TYPE kernelscope_cpu_credit_remaining_seconds gauge
+kernelscope_cpu_credit_remaining_seconds{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 0
+# HELP kernelscope_samples Resource usage samples taken
+# TYPE kernelscope_samples counter
+kernelscope_samples_total{binary="/bin/a \"quoted\" \\ name\nline",job="1"} 4
+kernelscope_samples_total{binary="/bin/b"} 0
+# HELP kernelscope_limit_hits Times a limit terminated the process, by reason
+# TYPE kernelscope_limit_hits counter
+kernelscope_limit_hits_total{binary="/bin/a \"quoted\" \\ name\nline",job="1",reason="CPU quota exceeded"} 1
+kernelscope_limit_hits_total{binary="/bin/a \"quoted\" \\ name\nline",job="1",reason="Memory limit exceeded"} 2
+# HELP kernelscope_terminated Set to 1 with the reason the process was terminated
+# TYPE kernelscope_terminated gauge
+kernelscope_terminated{binary="/bin/a \"quoted\" \\ name\nline",job="1",reason="CPU quota exceeded"} 1
+# EOF
+`
+
+func TestHandleMetrics(t *testing.T) {
+	tests := []struct {
+		name            string
+		accept          string
+		wantContentType string
+		want            string
+	}{
+		{name: "prometheus", accept: "text/plain", wantContentType: prometheusContentType, want: wantPrometheus},
+		{name: "no accept header", wantContentType: prometheusContentType, want: wantPrometheus},
+		{name: "openmetrics", accept: "application/openmetrics-text; version=1.0.0,text/plain;q=0.5", wantContentType: openMetricsContentType, want: wantOpenMetrics},
+	}
+
+	exporter := NewExporter("")
+	for _, target := range testTargets() {
+		exporter.Register(target.labels, target.config, target.monitor)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/metrics", nil)
+			if test.accept != "" {
+				request.Header.Set("Accept", test.accept)
+			}
+			recorder := httptest.NewRecorder()
+			exporter.Handler().ServeHTTP(recorder, request)
+
+			if contentType := recorder.Header().Get("Content-Type"); contentType != test.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", contentType, test.wantContentType)
+			}
+			if got := recorder.Body.String(); got != test.want {
+				t.Errorf("body:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUnregister(t *testing.T) {
+	targets := testTargets()
+	exporter := NewExporter("")
+	for _, target := range targets {
+		exporter.Register(target.labels, target.config, target.monitor)
+	}
+	exporter.Unregister(targets[0].monitor)
+
+	want := render(targets[1:], false)
+	recorder := httptest.NewRecorder()
+	exporter.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if got := recorder.Body.String(); got != want {
+		t.Errorf("body after unregistering:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{`plain`, `plain`},
+		{`back\slash`, `back\\slash`},
+		{`"quoted"`, `\"quoted\"`},
+		{"two\nlines", `two\nlines`},
+		{"\\\"\n", `\\\"\n`},
+	}
+
+	for _, test := range tests {
+		if got := escapeLabelValue(test.value); got != test.want {
+			t.Errorf("escapeLabelValue(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
@@ -4,20 +4,90 @@ import (
 	"fmt"
 	"kernelscope/cli"
 	"kernelscope/executor"
+	"kernelscope/netaudit"
+	"kernelscope/procevents"
 	"kernelscope/resource"
+	"kernelscope/utils"
+	"kernelscope/workspace"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Stats holds process statistics
 type Stats struct {
-	StartTime    time.Time
-	EndTime      time.Time
-	CpuTimeUsed  float64
-	MaxMemoryKB  uint64
-	ExitCode     int
-	TermReason   string
-	LoopCount    int
-	SuccessCount int
+	StartTime        time.Time
+	EndTime          time.Time
+	CpuTimeUsed      float64 // CPU time of the live tree at the last sample, drops when children exit
+	TotalCpuTime     float64 // CPU time of every process seen, including ones that exited
+	MaxMemoryKB      uint64
+	PeakThreads      int          // Highest thread count across the tree
+	PeakFDs          int          // Highest open file descriptor count across the tree
+	PeakProcesses    int          // Highest number of descendant processes
+	IO               utils.ProcIO // I/O of every process seen in the tree, including ones that exited
+	ExitCode         int
+	TermReason       string
+	LimitHits        map[string]int // Number of times each limit terminated the process, by reason
+	Running          bool           // Whether the process is currently being monitored
+	LoopCount        int
+	SuccessCount     int
+	SuccessReason    string                   // Why the last iteration counted as a success or a failure
+	SampleCount      int                      // Number of resource usage samples taken
+	SamplingOverhead time.Duration            // Time KernelScope spent reading /proc while sampling
+	Samples          []Sample                 // Time series of every sample taken
+	Processes        []*ProcessInfo           // Every process seen in the monitored tree, in order of appearance
+	StateTime        map[string]time.Duration // Process-time spent in each scheduler state (R, S, D, ...)
+	WaitTime         map[string]time.Duration // Process-time spent blocked in each kernel wait channel
+	Diagnostics      []ProcessDiagnostic      // State of every process when a timeout fired
+	Identity         *executor.Identity       // User and groups the binary ran as, nil if it never started
+	Workspace        string                   // Per-run working directory, removed after the run
+	ArtifactsDir     string                   // Directory the collected workspace files were copied into
+	Artifacts        []workspace.Artifact     // Files collected from the workspace
+	FileChanges      []workspace.Change       // Files the run created, modified or deleted, when audited
+	Syscalls         *executor.SyscallSummary // System calls counted with --trace-syscalls
+	ProcessEvents    string                   // Source of fork, exec and exit events, empty when processes are only sampled
+	Network          *netaudit.Activity       // Sockets and traffic of the tree with --audit-network, nil otherwise
+	CpuRateControl   string                   // How --cpu-rate was held: a cgroup control file or SIGSTOP/SIGCONT
+	ThrottledTime    time.Duration            // Time the tree was stopped or throttled by the CPU quota to hold --cpu-rate
+	WallTime         time.Duration            // From letting the binary run until it exited
+}
+
+// ProcessDiagnostic is a snapshot of where a process was stuck when it was terminated
+type ProcessDiagnostic struct {
+	Pid   int
+	Comm  string
+	State string
+	Wchan string
+	Stack []string // Kernel stack, empty when /proc/[pid]/stack is unreadable
+}
+
+// ProcessInfo describes a single process seen in the monitored tree
+type ProcessInfo struct {
+	Pid          int
+	PPid         int
+	Comm         string
+	Cmdline      []string
+	FirstSeen    time.Time
+	LastSeen     time.Time
+	CpuTime      float64      // Last observed CPU time in seconds
+	PeakMemoryKB uint64       // Highest observed resident memory in KB
+	IO           utils.ProcIO // Last observed I/O counters
+	Exe          string       // Program the process runs, empty if unknown
+	Cwd          string       // Working directory, empty if unknown
+	Exited       bool         // Whether the exit was observed, LastSeen is then the exit time
+	ExitCode     int          // Exit status, -1 if killed by a signal
+	ExitSignal   int          // Signal that killed the process, 0 if it exited
+}
+
+// Sample is a single point in the resource usage time series
+type Sample struct {
+	Time      time.Time
+	CpuTime   float64                 // CPU time of every process seen so far in seconds, including ones that exited
+	MemoryKB  uint64                  // Total resident memory of the tree in KB
+	Threads   int                     // Total thread count of the tree
+	FDs       int                     // Total open file descriptors of the tree
+	IO        utils.ProcIO            // Cumulative I/O of the tree
+	Processes []resource.ProcessUsage // Per-process breakdown
 }
 
 // Monitor handles process monitoring
@@ -26,6 +96,24 @@ type Monitor struct {
 	ResourceMgr    *resource.ResourceManager
 	Stats          *Stats
 	stopMonitoring chan bool
+	mu             sync.Mutex           // Guards Stats while the process is being monitored
+	processIndex   map[int]*ProcessInfo // Processes in Stats.Processes by PID
+	lastOutput     atomic.Int64         // When the process last wrote output, in Unix nanoseconds
+
+	// Processes that left the tree, so their I/O is counted once
+	departed   map[*ProcessInfo]bool // Records whose I/O was accounted for when they left
+	departedIO utils.ProcIO          // I/O of departed processes no process in the tree reaped
+
+	// Network audit state, only set with --audit-network
+	connections map[uint64]*netaudit.Connection // Connections in Stats.Network by socket inode
+	traffic     *netaudit.TrafficCounter        // Counters of the run's own network namespace, nil if it shares ours
+
+	throttle *throttle // Duty cycles the tree for --cpu-rate, nil when a cgroup quota holds it
+
+	// Optional hooks for streaming live data. They are called from the
+	// monitoring goroutine and must not block or call back into the Monitor.
+	OnSample func(sample Sample)
+	OnEvent  func(message string)
 }
 
 // NewMonitor creates a new process monitor
@@ -42,21 +130,77 @@ func NewMonitor(config *cli.Config) *Monitor {
 func (m *Monitor) StartMonitoring(process *executor.Process) *Stats {
 	fmt.Printf("Starting to monitor process PID: %d\n", process.Pid)
 
-	// Set process resource limits
+	// Set process resource limits, then let the binary run within them
 	err := m.ResourceMgr.SetProcessLimits(process.Pid)
 	if err != nil {
 		fmt.Printf("Warning: Failed to set resource limits: %v\n", err)
 	}
+	process.Release()
 
 	// Initialize stats
+	m.mu.Lock()
 	m.Stats.StartTime = time.Now()
 	m.Stats.LoopCount = 0
 	m.Stats.SuccessCount = 0
 	m.Stats.CpuTimeUsed = 0
+	m.Stats.TotalCpuTime = 0
 	m.Stats.MaxMemoryKB = 0
+	m.Stats.PeakThreads = 0
+	m.Stats.PeakFDs = 0
+	m.Stats.PeakProcesses = 0
+	m.Stats.IO = utils.ProcIO{}
+	m.Stats.SampleCount = 0
+	m.Stats.SamplingOverhead = 0
+	m.Stats.Samples = nil
+	m.Stats.Processes = nil
+	m.Stats.TermReason = ""
+	m.Stats.LimitHits = make(map[string]int)
+	m.Stats.StateTime = make(map[string]time.Duration)
+	m.Stats.WaitTime = make(map[string]time.Duration)
+	m.Stats.Diagnostics = nil
+	m.Stats.Network = nil
+	m.Stats.CpuRateControl = ""
+	m.Stats.Identity = &process.Identity
+	m.Stats.Running = true
+	m.processIndex = make(map[int]*ProcessInfo)
+	m.departed = make(map[*ProcessInfo]bool)
+	m.departedIO = utils.ProcIO{}
+	m.lastOutput.Store(m.Stats.StartTime.UnixNano())
+	m.mu.Unlock()
+
+	// Follow forks, execs and exits as they happen, so processes that live
+	// between two samples are recorded too
+	events, source := process.Events, "ptrace"
+	var listener *procevents.Listener
+	if events == nil {
+		var err error
+		if listener, err = procevents.Listen(process.Pid); err != nil {
+			fmt.Printf("Process events unavailable, sampling /proc only: %v\n", err)
+			source = ""
+		} else {
+			events, source = listener.Events, "proc connector"
+		}
+	}
+	m.mu.Lock()
+	m.Stats.ProcessEvents = source
+	// The root, and anything it forked already, started before any event
+	// source, so record them up front
+	m.recordEvent(procevents.ExecEvent(process.Pid))
+	children, _ := utils.GetAllChildProcesses(process.Pid)
+	for _, child := range children {
+		m.recordEvent(procevents.ExecEvent(child))
+	}
+	m.mu.Unlock()
+
+	if m.Config.AuditNetwork {
+		m.startNetworkAudit(process.Pid)
+	}
+	if m.Config.CpuRate > 0 {
+		m.startThrottle(process.Pid)
+	}
 
 	// Start monitoring goroutine
-	go m.monitorProcess(process)
+	go m.monitorProcess(process, events, listener)
 
 	// Start timeout goroutine
 	if m.Config.Timeout > 0 {
@@ -70,52 +214,234 @@ func (m *Monitor) StartMonitoring(process *executor.Process) *Stats {
 }
 
 // monitorProcess continuously monitors a process's resource usage
-func (m *Monitor) monitorProcess(process *executor.Process) {
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
+func (m *Monitor) monitorProcess(process *executor.Process, events <-chan procevents.Event, listener *procevents.Listener) {
+	if listener != nil {
+		defer listener.Close()
+	}
+
+	interval := m.Config.SampleInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
 
 	limitExceeded := false
+	lastCpuTime := 0.0
+	lastSample := time.Now()
+
+	// Hang detection: when the tree last used CPU, and how much it had used by then
+	lastProgress := time.Now()
+	progressCpuTime := 0.0
+
+	// Seccomp violations, nil without a profile so the case never fires
+	violations := process.Violations
+
+	// Duty cycle of --cpu-rate, nil without a throttle so the case never fires
+	var throttleTimer *time.Timer
+	var throttleSteps <-chan time.Time
+	if m.throttle != nil {
+		throttleTimer = time.NewTimer(time.Duration(m.throttle.working * float64(throttlePeriod)))
+		defer throttleTimer.Stop()
+		defer m.throttle.release()
+		throttleSteps = throttleTimer.C
+	}
 
 	for {
 		select {
-		case <-ticker.C:
+		case violation, ok := <-violations:
+			if !ok {
+				violations = nil
+				continue
+			}
+			fmt.Printf("Seccomp violation: PID %d called %s (%d)\n", violation.Pid, violation.Name, violation.Number)
+			m.mu.Lock()
+			terminate := !limitExceeded
+			if terminate {
+				m.recordTermination(fmt.Sprintf("Seccomp violation: syscall %d (%s)", violation.Number, violation.Name))
+				limitExceeded = true
+			}
+			m.mu.Unlock()
+
+			// Killing the tree takes a while, readers of the stats must not wait for it
+			if terminate {
+				m.terminateProcessKeepMonitoring(process)
+			}
+
+		case <-throttleSteps:
+			next, stopped := m.throttle.step(process.Pid, time.Now())
+			m.mu.Lock()
+			m.Stats.ThrottledTime += stopped
+			m.mu.Unlock()
+			throttleTimer.Reset(next)
+
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			m.mu.Lock()
+			m.recordEvent(event)
+			m.mu.Unlock()
+
+		case <-timer.C:
 			// Get current resource usage
-			cpuTime, memoryKB, err := m.ResourceMgr.GetResourceUsage(process.Pid)
+			sampleStart := time.Now()
+			usage, err := m.ResourceMgr.GetTreeUsage(process.Pid)
+			m.mu.Lock()
+			m.Stats.SamplingOverhead += time.Since(sampleStart)
 			if err != nil {
+				m.mu.Unlock()
 				fmt.Printf("Error getting resource usage: %v\n", err)
 				// Process may have terminated, but keep monitoring until stopMonitoring signal
+				timer.Reset(interval)
 				continue
 			}
+			cpuTime, memoryKB := usage.CpuTime, usage.MemoryKB
+			m.Stats.SampleCount++
+			terminate := false // Set when a limit was exceeded, the tree is killed after unlocking
+
+			// Update the per-process records and the cumulative I/O
+			m.trackProcesses(usage.Processes, sampleStart)
+			m.Stats.TotalCpuTime = m.cumulativeCpuTime()
+			m.trackQuotaThrottling()
+			m.trackStates(usage.Processes, sampleStart.Sub(lastSample))
+			lastSample = sampleStart
+			if m.Stats.Network != nil {
+				m.trackConnections(usage.Processes, sampleStart)
+			}
+
+			// Record the sample in the time series
+			m.Stats.Samples = append(m.Stats.Samples, Sample{
+				Time:      sampleStart,
+				CpuTime:   m.Stats.TotalCpuTime,
+				MemoryKB:  memoryKB,
+				Threads:   usage.Threads,
+				FDs:       usage.FDs,
+				IO:        m.Stats.IO,
+				Processes: usage.Processes,
+			})
 
 			// Update stats
+			descendants := len(usage.Processes) - 1
 			m.Stats.CpuTimeUsed = cpuTime
 			if memoryKB > m.Stats.MaxMemoryKB {
 				m.Stats.MaxMemoryKB = memoryKB
 			}
+			if usage.Threads > m.Stats.PeakThreads {
+				m.Stats.PeakThreads = usage.Threads
+			}
+			if usage.FDs > m.Stats.PeakFDs {
+				m.Stats.PeakFDs = usage.FDs
+			}
+			if descendants > m.Stats.PeakProcesses {
+				m.Stats.PeakProcesses = descendants
+			}
 
 			// Check memory limit
 			if !limitExceeded && m.Config.MemoryLimit > 0 && memoryKB > uint64(m.Config.MemoryLimit) {
 				fmt.Printf("Memory limit exceeded: %d KB > %d KB\n", memoryKB, m.Config.MemoryLimit)
-				m.Stats.TermReason = "Memory limit exceeded"
+				m.recordTermination("Memory limit exceeded")
 				limitExceeded = true
 
-				// Terminate process but keep monitoring
-				m.terminateProcessKeepMonitoring(process)
+				terminate = true
+			}
+
+			// Check CPU quota, against the CPU of exited children too
+			if !limitExceeded && m.ResourceMgr.IsCpuQuotaExceeded(m.Stats.TotalCpuTime) {
+				fmt.Printf("CPU quota exceeded: %.2f seconds\n", m.Stats.TotalCpuTime)
+				m.recordTermination("CPU quota exceeded")
+				limitExceeded = true
+
+				terminate = true
+			}
+
+			// Check I/O limits
+			if !limitExceeded && m.ResourceMgr.IsWriteLimitExceeded(m.Stats.IO.WriteBytes) {
+				fmt.Printf("Write limit exceeded: %d bytes > %d bytes\n", m.Stats.IO.WriteBytes, m.Config.MaxWriteBytes)
+				m.recordTermination("Write limit exceeded")
+				limitExceeded = true
+
+				terminate = true
+			}
+
+			if !limitExceeded && m.ResourceMgr.IsReadLimitExceeded(m.Stats.IO.ReadBytes) {
+				fmt.Printf("Read limit exceeded: %d bytes > %d bytes\n", m.Stats.IO.ReadBytes, m.Config.MaxReadBytes)
+				m.recordTermination("Read limit exceeded")
+				limitExceeded = true
+
+				terminate = true
+			}
+
+			// Check thread, fd and process count limits
+			if !limitExceeded && m.ResourceMgr.IsThreadLimitExceeded(usage.Threads) {
+				fmt.Printf("Thread limit exceeded: %d > %d\n", usage.Threads, m.Config.MaxThreads)
+				m.recordTermination("Thread limit exceeded")
+				limitExceeded = true
+
+				terminate = true
+			}
+
+			if !limitExceeded && m.ResourceMgr.IsFDLimitExceeded(usage.FDs) {
+				fmt.Printf("File descriptor limit exceeded: %d > %d\n", usage.FDs, m.Config.MaxFDs)
+				m.recordTermination("File descriptor limit exceeded")
+				limitExceeded = true
+
+				terminate = true
+			}
+
+			if !limitExceeded && m.ResourceMgr.IsProcessLimitExceeded(descendants) {
+				fmt.Printf("Process limit exceeded: %d > %d\n", descendants, m.Config.MaxProcs)
+				m.recordTermination("Process limit exceeded")
+				limitExceeded = true
+
+				terminate = true
+			}
+
+			// Check for a hang: no CPU progress, or no output
+			if m.Stats.TotalCpuTime > progressCpuTime+cpuProgressEpsilon {
+				progressCpuTime = m.Stats.TotalCpuTime
+				lastProgress = sampleStart
+			}
+			if !limitExceeded && m.Config.IdleTimeout > 0 && sampleStart.Sub(lastProgress) >= time.Duration(m.Config.IdleTimeout)*time.Second {
+				fmt.Printf("Idle timeout: no CPU progress for %v\n", sampleStart.Sub(lastProgress).Round(time.Millisecond))
+				m.Stats.Diagnostics = captureDiagnostics(process.Pid)
+				m.recordTermination("Idle timeout")
+				limitExceeded = true
+
+				terminate = true
 			}
 
-			// Check CPU quota
-			if !limitExceeded && m.ResourceMgr.IsCpuQuotaExceeded(cpuTime) {
-				fmt.Printf("CPU quota exceeded: %.2f seconds\n", cpuTime)
-				m.Stats.TermReason = "CPU quota exceeded"
+			lastOutput := time.Unix(0, m.lastOutput.Load())
+			if !limitExceeded && m.Config.OutputTimeout > 0 && sampleStart.Sub(lastOutput) >= time.Duration(m.Config.OutputTimeout)*time.Second {
+				fmt.Printf("Output timeout: no output for %v\n", sampleStart.Sub(lastOutput).Round(time.Millisecond))
+				m.Stats.Diagnostics = captureDiagnostics(process.Pid)
+				m.recordTermination("Output timeout")
 				limitExceeded = true
 
-				// Terminate process but keep monitoring
+				terminate = true
+			}
+
+			latest := m.Stats.Samples[len(m.Stats.Samples)-1]
+			m.mu.Unlock()
+
+			// Terminate process but keep monitoring
+			if terminate {
 				m.terminateProcessKeepMonitoring(process)
 			}
 
+			if m.OnSample != nil {
+				m.OnSample(latest)
+			}
+
 			// Output current stats
 			fmt.Printf("PID: %d | CPU: %.2fs | Memory: %d KB\n", process.Pid, cpuTime, memoryKB)
 
+			// Schedule the next sample. Only this goroutine writes TotalCpuTime.
+			totalCpu := m.Stats.TotalCpuTime
+			if m.Config.AdaptiveSampling {
+				interval = m.nextSampleInterval(interval, totalCpu-lastCpuTime, totalCpu, memoryKB)
+			}
+			lastCpuTime = totalCpu
+			timer.Reset(interval)
+
 		case <-m.stopMonitoring:
 			fmt.Println("Stopping monitoring")
 			return
@@ -123,6 +449,228 @@ func (m *Monitor) monitorProcess(process *executor.Process) {
 	}
 }
 
+// cpuProgressEpsilon is the smallest CPU time increase counted as progress, one clock tick
+const cpuProgressEpsilon = 0.01
+
+// RecordOutput notes that the process wrote output. It is safe to call from any goroutine.
+func (m *Monitor) RecordOutput() {
+	m.lastOutput.Store(time.Now().UnixNano())
+}
+
+// cumulativeCpuTime returns the CPU time of every process seen, including ones that exited.
+// Unlike the live tree total it never drops. The caller must hold m.mu.
+func (m *Monitor) cumulativeCpuTime() float64 {
+	total := 0.0
+	for _, info := range m.Stats.Processes {
+		total += info.CpuTime
+	}
+	return total
+}
+
+// trackStates adds the time since the last sample to the state and wait channel
+// of every process. The caller must hold m.mu.
+func (m *Monitor) trackStates(processes []resource.ProcessUsage, elapsed time.Duration) {
+	for _, proc := range processes {
+		m.Stats.StateTime[proc.State] += elapsed
+		if proc.State != "R" && proc.Wchan != "" {
+			m.Stats.WaitTime[proc.Wchan] += elapsed
+		}
+	}
+}
+
+// captureDiagnostics records the state, wait channel and kernel stack of every
+// process in the tree, to show whether a hung run was busy or blocked
+func captureDiagnostics(pid int) []ProcessDiagnostic {
+	pids := []int{pid}
+	if children, err := utils.GetAllChildProcesses(pid); err == nil {
+		pids = append(pids, children...)
+	}
+
+	var diagnostics []ProcessDiagnostic
+	for _, p := range pids {
+		stats, err := utils.ReadProcStats(p)
+		if err != nil {
+			continue // The process exited in the meantime
+		}
+		diagnostic := ProcessDiagnostic{
+			Pid:   p,
+			Comm:  stats.Comm,
+			State: stats.State,
+			Wchan: utils.ReadWchan(p),
+		}
+		if stack, err := utils.ReadKernelStack(p); err == nil {
+			diagnostic.Stack = stack
+		}
+		diagnostics = append(diagnostics, diagnostic)
+	}
+	return diagnostics
+}
+
+// trackProcesses updates the per-process records with the latest sample
+func (m *Monitor) trackProcesses(processes []resource.ProcessUsage, now time.Time) {
+	for _, proc := range processes {
+		info, ok := m.processIndex[proc.Pid]
+		if !ok {
+			info = &ProcessInfo{
+				Pid:       proc.Pid,
+				PPid:      proc.PPid,
+				Comm:      proc.Comm,
+				FirstSeen: now,
+			}
+			// The program only needs to be read once per process, exec events update it
+			info.Cmdline, _ = utils.ReadCmdline(proc.Pid)
+			info.Exe, _ = utils.ReadExe(proc.Pid)
+			info.Cwd, _ = utils.ReadCwd(proc.Pid)
+			m.addProcess(info)
+		}
+		if info.Exited {
+			// The exit event already has the final values
+			continue
+		}
+
+		info.LastSeen = now
+		info.PPid = proc.PPid // Orphans are reparented
+		info.CpuTime = proc.CpuTime
+		info.IO = proc.IO
+		if proc.MemoryKB > info.PeakMemoryKB {
+			info.PeakMemoryKB = proc.MemoryKB
+		}
+	}
+
+	m.trackIO(processes)
+}
+
+// trackIO totals the I/O of the tree, counting each process once. The counters
+// of a process include those of the children it reaped, so a process that left
+// the tree only adds its last values when its parent is not in the tree to
+// inherit them, e.g. the root or an orphan reaped outside it. The caller must hold m.mu.
+func (m *Monitor) trackIO(processes []resource.ProcessUsage) {
+	live := make(map[int]bool, len(processes))
+	for _, proc := range processes {
+		live[proc.Pid] = true
+	}
+
+	for _, info := range m.Stats.Processes {
+		if m.departed[info] || (live[info.Pid] && m.processIndex[info.Pid] == info) {
+			continue
+		}
+		m.departed[info] = true
+		if !live[info.PPid] {
+			m.departedIO.Add(info.IO)
+		}
+	}
+
+	m.Stats.IO = m.departedIO
+	for _, proc := range processes {
+		m.Stats.IO.Add(proc.IO)
+	}
+}
+
+// recordEvent updates the per-process records with a fork, exec or exit.
+// The caller must hold m.mu.
+func (m *Monitor) recordEvent(event procevents.Event) {
+	info, ok := m.processIndex[event.Pid]
+
+	switch event.Kind {
+	case procevents.Fork:
+		if ok && !info.Exited {
+			return
+		}
+		// A forked process runs its parent's program until it execs
+		info = &ProcessInfo{Pid: event.Pid, PPid: event.PPid, FirstSeen: event.Time, LastSeen: event.Time}
+		if parent, ok := m.processIndex[event.PPid]; ok {
+			info.Comm, info.Exe, info.Cmdline, info.Cwd = parent.Comm, parent.Exe, parent.Cmdline, parent.Cwd
+		}
+		m.addProcess(info)
+
+	case procevents.Exec:
+		if !ok || info.Exited {
+			info = &ProcessInfo{Pid: event.Pid, PPid: event.PPid, FirstSeen: event.Time, LastSeen: event.Time}
+			m.addProcess(info)
+		}
+		// An empty comm means the process was gone before /proc could be read
+		if event.Comm != "" {
+			info.Comm, info.Exe, info.Cmdline, info.Cwd = event.Comm, event.Exe, event.Cmdline, event.Cwd
+		}
+
+	case procevents.Exit:
+		if !ok || info.Exited {
+			return
+		}
+		info.Exited = true
+		info.ExitCode, info.ExitSignal = event.ExitCode, event.ExitSignal
+		info.LastSeen = event.Time
+		if event.CpuTime > info.CpuTime {
+			info.CpuTime = event.CpuTime
+		}
+		if event.PeakMemoryKB > info.PeakMemoryKB {
+			info.PeakMemoryKB = event.PeakMemoryKB
+		}
+		// trackIO adds the final counters once the process leaves the samples
+		if event.IO != nil {
+			info.IO = *event.IO
+		}
+	}
+}
+
+// addProcess starts a record for a process, replacing any earlier one with the same PID.
+// The caller must hold m.mu.
+func (m *Monitor) addProcess(info *ProcessInfo) {
+	m.processIndex[info.Pid] = info
+	m.Stats.Processes = append(m.Stats.Processes, info)
+}
+
+// Bounds for adaptive sampling relative to the configured interval
+const (
+	minAdaptiveInterval = 50 * time.Millisecond
+	adaptiveSpeedup     = 4 // Near a limit, sample up to this many times faster
+	adaptiveSlowdown    = 4 // When idle, sample up to this many times slower
+)
+
+// nextSampleInterval picks the next sampling interval in adaptive mode.
+// It samples faster as CPU or memory usage approaches its limit and backs
+// off gradually while the process tree is idle.
+func (m *Monitor) nextSampleInterval(current time.Duration, cpuDelta float64, cpuTime float64, memoryKB uint64) time.Duration {
+	base := m.Config.SampleInterval
+
+	// Fraction of the closest limit already consumed
+	usage := 0.0
+	if m.Config.MemoryLimit > 0 {
+		usage = float64(memoryKB) / float64(m.Config.MemoryLimit)
+	}
+	cpuQuota := float64(m.Config.CpuLimit)
+	if m.Config.PrePaidMode {
+		cpuQuota = m.Config.CpuCredit
+	}
+	if cpuQuota > 0 && cpuTime/cpuQuota > usage {
+		usage = cpuTime / cpuQuota
+	}
+
+	switch {
+	case usage >= 0.9:
+		return maxDuration(base/adaptiveSpeedup, minAdaptiveInterval)
+	case usage >= 0.75:
+		return maxDuration(base/2, minAdaptiveInterval)
+	case cpuDelta < 0.01*current.Seconds():
+		// Less than 1% of a core since the last sample: back off
+		next := current * 2
+		if next > base*adaptiveSlowdown {
+			next = base * adaptiveSlowdown
+		}
+		return next
+	default:
+		return base
+	}
+}
+
+// maxDuration returns the larger of two durations
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 // enforceTimeout enforces the process timeout
 func (m *Monitor) enforceTimeout(process *executor.Process) {
 	timer := time.NewTimer(time.Duration(m.Config.Timeout) * time.Second)
@@ -131,7 +679,11 @@ func (m *Monitor) enforceTimeout(process *executor.Process) {
 	select {
 	case <-timer.C:
 		fmt.Printf("Process timeout after %d seconds\n", m.Config.Timeout)
-		m.Stats.TermReason = "Timeout"
+		diagnostics := captureDiagnostics(process.Pid)
+		m.mu.Lock()
+		m.Stats.Diagnostics = diagnostics
+		m.recordTermination("Timeout")
+		m.mu.Unlock()
 		// Use terminateProcess to ensure the process is killed
 		m.terminateProcess(process)
 	case <-m.stopMonitoring:
@@ -144,7 +696,7 @@ func (m *Monitor) enforceTimeout(process *executor.Process) {
 func (m *Monitor) terminateProcess(process *executor.Process) {
 	fmt.Printf("Terminating process PID: %d\n", process.Pid)
 	executor := executor.NewExecutor(m.Config)
-	err := executor.KillProcess(process)
+	err := executor.KillProcessTree(process)
 	if err != nil {
 		fmt.Printf("Error killing process: %v\n", err)
 	} else {
@@ -164,7 +716,7 @@ func (m *Monitor) terminateProcess(process *executor.Process) {
 func (m *Monitor) terminateProcessKeepMonitoring(process *executor.Process) {
 	fmt.Printf("Terminating process PID: %d (but keeping monitoring)\n", process.Pid)
 	executor := executor.NewExecutor(m.Config)
-	err := executor.KillProcess(process)
+	err := executor.KillProcessTree(process)
 	if err != nil {
 		fmt.Printf("Error killing process: %v\n", err)
 	} else {
@@ -187,10 +739,50 @@ func (m *Monitor) WaitForCompletion() *Stats {
 		fmt.Println("Stop monitoring channel is full or closed")
 	}
 
+	// Take the final throttled time before the cgroup holding it goes away
+	m.mu.Lock()
+	m.trackQuotaThrottling()
+	m.mu.Unlock()
+
+	// Remove any cgroup created for the process
+	m.ResourceMgr.ReleaseProcessLimits()
+
+	m.mu.Lock()
 	m.Stats.EndTime = time.Now()
+	m.Stats.Running = false
+	m.finishNetworkAudit()
+	m.mu.Unlock()
 	return m.Stats
 }
 
+// Snapshot returns a copy of the current statistics that is safe to read
+// while the process is still being monitored
+func (m *Monitor) Snapshot() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := *m.Stats
+	snapshot.LimitHits = make(map[string]int, len(m.Stats.LimitHits))
+	for reason, hits := range m.Stats.LimitHits {
+		snapshot.LimitHits[reason] = hits
+	}
+	return snapshot
+}
+
+// recordTermination records that a limit terminated the process.
+// The caller must hold m.mu.
+func (m *Monitor) recordTermination(reason string) {
+	m.Stats.TermReason = reason
+	if m.Stats.LimitHits == nil {
+		m.Stats.LimitHits = make(map[string]int)
+	}
+	m.Stats.LimitHits[reason]++
+
+	if m.OnEvent != nil {
+		m.OnEvent(reason)
+	}
+}
+
 // RecordLoopIteration records a loop iteration
 func (m *Monitor) RecordLoopIteration(success bool) {
 	m.Stats.LoopCount++
@@ -0,0 +1,88 @@
+package monitor
+
+import (
+	"kernelscope/procevents"
+	"kernelscope/resource"
+	"kernelscope/utils"
+	"testing"
+	"time"
+)
+
+func TestTrackIO(t *testing.T) {
+	// proc is a process in a sample that wrote the given number of bytes. The PIDs
+	// are above PID_MAX_LIMIT, so no real process is read for them.
+	proc := func(pid, ppid int, written uint64) resource.ProcessUsage {
+		return resource.ProcessUsage{Pid: 5000000 + pid, PPid: 5000000 + ppid, IO: utils.ProcIO{WriteBytes: written}}
+	}
+
+	tests := []struct {
+		name    string
+		samples [][]resource.ProcessUsage
+		exits   map[int]uint64 // Exit events after the samples, by PID, with the bytes written
+		want    uint64
+	}{
+		{
+			name: "live tree",
+			samples: [][]resource.ProcessUsage{
+				{proc(10, 1, 100), proc(11, 10, 50)},
+			},
+			want: 150,
+		},
+		{
+			// The parent's counters take over those of the child it reaped
+			name: "child reaped by its parent",
+			samples: [][]resource.ProcessUsage{
+				{proc(10, 1, 100), proc(11, 10, 50)},
+				{proc(10, 1, 150)},
+			},
+			want: 150,
+		},
+		{
+			name: "orphan reaped outside the tree",
+			samples: [][]resource.ProcessUsage{
+				{proc(10, 1, 100), proc(11, 10, 50)},
+				{proc(11, 1, 70)},
+				{},
+			},
+			want: 170,
+		},
+		{
+			name: "root exited",
+			samples: [][]resource.ProcessUsage{
+				{proc(10, 1, 100), proc(11, 10, 50)},
+				{proc(10, 1, 180)},
+				{},
+			},
+			want: 180,
+		},
+		{
+			// The child's bytes are already in its parent's counters
+			name: "exit after a reaped child",
+			samples: [][]resource.ProcessUsage{
+				{proc(10, 1, 100), proc(11, 10, 50)},
+				{proc(10, 1, 150)},
+			},
+			exits: map[int]uint64{10: 150},
+			want:  150,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &Monitor{
+				Stats:        &Stats{},
+				processIndex: make(map[int]*ProcessInfo),
+				departed:     make(map[*ProcessInfo]bool),
+			}
+			for _, sample := range test.samples {
+				m.trackProcesses(sample, time.Now())
+			}
+			for pid, written := range test.exits {
+				m.recordEvent(procevents.Event{Kind: procevents.Exit, Time: time.Now(), Pid: 5000000 + pid, IO: &utils.ProcIO{WriteBytes: written}})
+			}
+			if got := m.Stats.IO.WriteBytes; got != test.want {
+				t.Errorf("written bytes = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package monitor
+
+import (
+	"fmt"
+	"kernelscope/netaudit"
+	"kernelscope/resource"
+	"os"
+	"time"
+)
+
+// startNetworkAudit prepares the records of the tree's sockets. When the root
+// runs in its own network namespace, every byte on its interfaces is the run's.
+func (m *Monitor) startNetworkAudit(pid int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Stats.Network = &netaudit.Activity{}
+	m.connections = make(map[uint64]*netaudit.Connection)
+	m.traffic = nil
+
+	own, err := netaudit.Namespace(os.Getpid())
+	if err != nil {
+		fmt.Printf("Warning: Failed to read network namespace: %v\n", err)
+		return
+	}
+	if namespace, err := netaudit.Namespace(pid); err != nil || namespace == own {
+		return
+	}
+	if m.traffic, err = netaudit.OpenTraffic(pid); err != nil {
+		fmt.Printf("Warning: Failed to count network traffic: %v\n", err)
+	}
+}
+
+// trackConnections records the sockets the processes hold, and updates the
+// state and peer of ones already seen. The caller must hold m.mu.
+func (m *Monitor) trackConnections(processes []resource.ProcessUsage, now time.Time) {
+	// Socket tables are per network namespace, so read each one once
+	tables := make(map[string]map[uint64]netaudit.Socket)
+
+	for _, proc := range processes {
+		inodes, err := netaudit.SocketInodes(proc.Pid)
+		if err != nil || len(inodes) == 0 {
+			continue
+		}
+		namespace, _ := netaudit.Namespace(proc.Pid)
+		sockets, ok := tables[namespace]
+		if !ok {
+			sockets, _ = netaudit.ReadSockets(proc.Pid)
+			tables[namespace] = sockets
+		}
+
+		for _, inode := range inodes {
+			// Netlink and packet sockets are not in the tables
+			socket, ok := sockets[inode]
+			if !ok {
+				continue
+			}
+			if conn, ok := m.connections[inode]; ok {
+				conn.Socket = socket
+				continue
+			}
+			conn := &netaudit.Connection{Socket: socket, Pid: proc.Pid, Comm: proc.Comm, FirstSeen: now}
+			m.connections[inode] = conn
+			m.Stats.Network.Connections = append(m.Stats.Network.Connections, conn)
+		}
+	}
+
+	m.readTraffic()
+}
+
+// readTraffic updates the traffic of the run's network namespace. The caller must hold m.mu.
+func (m *Monitor) readTraffic() {
+	if m.traffic == nil {
+		return
+	}
+	if traffic, err := m.traffic.Read(); err == nil {
+		m.Stats.Network.Traffic = traffic
+	}
+}
+
+// finishNetworkAudit takes the final traffic counters. The caller must hold m.mu.
+func (m *Monitor) finishNetworkAudit() {
+	if m.traffic == nil {
+		return
+	}
+	m.readTraffic()
+	m.traffic.Close()
+	m.traffic = nil
+}
@@ -0,0 +1,125 @@
+package monitor
+
+import (
+	"kernelscope/executor"
+	"kernelscope/utils"
+	"time"
+)
+
+// throttlePeriod is the duty cycle of --cpu-rate without a cgroup quota, as in cpulimit
+const throttlePeriod = 100 * time.Millisecond
+
+// minWorkingFraction keeps the tree running for a moment in every period,
+// so a rate that was overshot once does not stop it for good
+const minWorkingFraction = 0.01
+
+// throttle caps the CPU rate of a tree by stopping it for part of every period
+// and adjusting the running part to the rate measured in the previous one
+type throttle struct {
+	rate    float64   // Target rate in cores
+	working float64   // Fraction of the period the tree runs
+	stopped []int     // Processes stopped for the rest of the period, nil while running
+	cpu     float64   // CPU time of the tree at the start of the period
+	start   time.Time // Start of the period
+
+	cpuTime func(root int) float64 // Reads the CPU time of the tree, treeCpuTime outside tests
+}
+
+// newThrottle starts a throttle, taking the CPU the tree used so far as the baseline
+func newThrottle(rate float64, root int, now time.Time) *throttle {
+	t := &throttle{rate: rate, working: min(rate, 1), cpuTime: treeCpuTime}
+	t.measure(root, now)
+	return t
+}
+
+// step stops the tree at the end of its running part, or continues it at the
+// end of the period. It returns how long until the next step, and how long
+// the tree was stopped if it was just continued.
+func (t *throttle) step(root int, now time.Time) (next time.Duration, stopped time.Duration) {
+	running := time.Duration(t.working * float64(throttlePeriod))
+
+	if t.stopped == nil && t.working < 1 {
+		t.stopped = treePids(root)
+		executor.StopProcesses(t.stopped)
+		return throttlePeriod - running, 0
+	}
+
+	if t.stopped != nil {
+		executor.ContinueProcesses(t.stopped)
+		stopped = now.Sub(t.start) - running
+		t.stopped = nil
+	}
+
+	// Scale the running part by how far the measured rate is off the target
+	if rate := t.measure(root, now); rate > 0 {
+		t.working = max(min(t.working/rate*t.rate, 1), minWorkingFraction)
+	}
+	return time.Duration(t.working * float64(throttlePeriod)), stopped
+}
+
+// measure returns the CPU rate of the tree since the last measurement, in cores
+func (t *throttle) measure(root int, now time.Time) float64 {
+	cpu := t.cpuTime(root)
+	used := max(cpu-t.cpu, 0)
+	elapsed := now.Sub(t.start).Seconds()
+	t.cpu, t.start = cpu, now
+	if elapsed <= 0 {
+		return 0
+	}
+	return used / elapsed
+}
+
+// release continues the tree if the throttle left it stopped
+func (t *throttle) release() {
+	if t.stopped != nil {
+		executor.ContinueProcesses(t.stopped)
+		t.stopped = nil
+	}
+}
+
+// treePids lists the root and its descendants
+func treePids(root int) []int {
+	children, _ := utils.GetAllChildProcesses(root)
+	return append([]int{root}, children...)
+}
+
+// treeCpuTime returns the CPU time of the root and its descendants, including
+// the children they waited for. An exiting process hands its time to the
+// parent that reaps it, so the total only drops when an orphan is reaped
+// outside the tree.
+func treeCpuTime(root int) float64 {
+	total := 0.0
+	for _, pid := range treePids(root) {
+		if stats, err := utils.ReadProcStats(pid); err == nil {
+			total += stats.CpuTime + stats.ReapedCpuTime
+		}
+	}
+	return total
+}
+
+// startThrottle records how --cpu-rate is held, and duty cycles the tree
+// when no cgroup quota holds it
+func (m *Monitor) startThrottle(pid int) {
+	m.throttle = nil
+	control := m.ResourceMgr.CpuRateControl()
+	if control == "" {
+		m.throttle = newThrottle(m.Config.CpuRate, pid, time.Now())
+		control = "SIGSTOP/SIGCONT"
+	}
+
+	m.mu.Lock()
+	m.Stats.CpuRateControl = control
+	m.Stats.ThrottledTime = 0
+	m.mu.Unlock()
+}
+
+// trackQuotaThrottling takes how long the cgroup quota has held back the tree,
+// when one holds --cpu-rate. The caller must hold m.mu.
+func (m *Monitor) trackQuotaThrottling() {
+	if m.throttle != nil || m.Stats.CpuRateControl == "" {
+		return
+	}
+	if throttled, err := m.ResourceMgr.CpuThrottledTime(); err == nil {
+		m.Stats.ThrottledTime = throttled
+	}
+}
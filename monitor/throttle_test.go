@@ -0,0 +1,67 @@
+package monitor
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestThrottleStep(t *testing.T) {
+	// The root is above PID_MAX_LIMIT, so stopping and continuing it signals no real process
+	const root = 5000000
+
+	tests := []struct {
+		name        string
+		rate        float64 // Target rate in cores
+		working     float64 // Running part of the period before the step
+		used        float64 // CPU seconds the tree used in the period
+		wantWorking float64 // Running part of the next period
+		wantStopped time.Duration
+	}{
+		{name: "on target", rate: 0.5, working: 0.5, used: 0.05, wantWorking: 0.5, wantStopped: 50 * time.Millisecond},
+		{name: "overshot", rate: 0.5, working: 0.5, used: 0.1, wantWorking: 0.25, wantStopped: 50 * time.Millisecond},
+		{name: "undershot", rate: 0.5, working: 0.5, used: 0.025, wantWorking: 1, wantStopped: 50 * time.Millisecond},
+		{name: "multithreaded", rate: 0.5, working: 0.5, used: 0.2, wantWorking: 0.125, wantStopped: 50 * time.Millisecond},
+		{name: "never below the minimum", rate: 0.01, working: 0.01, used: 0.1, wantWorking: minWorkingFraction, wantStopped: 99 * time.Millisecond},
+		{name: "idle keeps the running part", rate: 0.5, working: 0.3, used: 0, wantWorking: 0.3, wantStopped: 70 * time.Millisecond},
+		{name: "full rate is never stopped", rate: 2, working: 1, used: 0.1, wantWorking: 1},
+		{name: "reaped orphan drops the total", rate: 0.5, working: 0.5, used: -1, wantWorking: 0.5, wantStopped: 50 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start := time.Now()
+			cpu := 10.0
+			th := &throttle{rate: test.rate, working: test.working, cpu: cpu, start: start, cpuTime: func(int) float64 { return cpu }}
+			running := time.Duration(test.working * float64(throttlePeriod))
+
+			// The tree is stopped at the end of its running part, unless it runs the whole period
+			now := start.Add(running)
+			if test.working < 1 {
+				next, stopped := th.step(root, now)
+				if next != throttlePeriod-running || stopped != 0 || th.stopped == nil {
+					t.Fatalf("stopping step = %v, %v, want %v, 0", next, stopped, throttlePeriod-running)
+				}
+				now = start.Add(throttlePeriod)
+			}
+
+			cpu += test.used
+			next, stopped := th.step(root, now)
+			if th.stopped != nil {
+				t.Errorf("tree still stopped after the period")
+			}
+			if stopped != test.wantStopped {
+				t.Errorf("stopped = %v, want %v", stopped, test.wantStopped)
+			}
+			if math.Abs(th.working-test.wantWorking) > 1e-9 {
+				t.Errorf("working = %v, want %v", th.working, test.wantWorking)
+			}
+			if want := time.Duration(test.wantWorking * float64(throttlePeriod)); (next - want).Abs() > time.Microsecond {
+				t.Errorf("next step in %v, want %v", next, want)
+			}
+			if th.start != now || th.cpu != cpu {
+				t.Errorf("period restarted at %v with %v CPU seconds, want %v with %v", th.start, th.cpu, now, cpu)
+			}
+		})
+	}
+}
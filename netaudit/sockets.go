@@ -0,0 +1,211 @@
+package netaudit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Socket is an entry of one of the /proc/net socket tables
+type Socket struct {
+	Protocol string // tcp, tcp6, udp, udp6, raw, raw6 or unix
+	Local    string // Local address and port, or the socket path for unix sockets
+	Remote   string // Peer address and port, empty when not connected
+	State    string // TCP state, UNCONN or ESTABLISHED for datagram sockets, unix socket state
+	Inode    uint64
+}
+
+// Connection is a socket first seen held by a process in the monitored tree
+type Connection struct {
+	Socket
+	Pid       int    // Process the socket was first seen in
+	Comm      string // Command name of that process
+	FirstSeen time.Time
+}
+
+// Activity is the network use of a run
+type Activity struct {
+	Connections []*Connection // Sockets of the tree, in order of appearance
+	Traffic     *Traffic      // Interface counters, nil unless the run had its own network namespace
+}
+
+// inetTables are the /proc/net tables of internet sockets
+var inetTables = []string{"tcp", "tcp6", "udp", "udp6", "raw", "raw6"}
+
+// tcpStates names the states in /proc/net/tcp, from include/net/tcp_states.h
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+}
+
+// unixStates names the socket states in /proc/net/unix
+var unixStates = map[string]string{
+	"01": "UNCONN",
+	"02": "CONNECTING",
+	"03": "CONNECTED",
+	"04": "DISCONNECTING",
+}
+
+// unixAcceptConn is the __SO_ACCEPTCON flag of listening unix sockets
+const unixAcceptConn = 0x10000
+
+// SocketInodes lists the inodes of the sockets a process has open, from /proc/[pid]/fd
+func SocketInodes(pid int) ([]uint64, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid), "fd")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fd directory: %v", err)
+	}
+
+	var inodes []uint64
+	for _, entry := range entries {
+		target, err := os.Readlink(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue // The descriptor was closed meanwhile
+		}
+		value, ok := strings.CutPrefix(target, "socket:[")
+		if !ok {
+			continue
+		}
+		if inode, err := strconv.ParseUint(strings.TrimSuffix(value, "]"), 10, 64); err == nil {
+			inodes = append(inodes, inode)
+		}
+	}
+	return inodes, nil
+}
+
+// Namespace identifies the network namespace of a process, from /proc/[pid]/ns/net
+func Namespace(pid int) (string, error) {
+	return os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "ns", "net"))
+}
+
+// ReadSockets reads the socket tables of the network namespace pid is in, by inode
+func ReadSockets(pid int) (map[uint64]Socket, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid), "net")
+	sockets := make(map[uint64]Socket)
+
+	for _, protocol := range inetTables {
+		// Tables are missing when the protocol is not built in, e.g. without IPv6
+		if err := readTable(filepath.Join(dir, protocol), protocol, sockets, parseInet); err != nil && !os.IsNotExist(err) {
+			return sockets, err
+		}
+	}
+	if err := readTable(filepath.Join(dir, "unix"), "unix", sockets, parseUnix); err != nil && !os.IsNotExist(err) {
+		return sockets, err
+	}
+	return sockets, nil
+}
+
+// readTable parses the lines of a socket table after its header
+func readTable(path string, protocol string, sockets map[uint64]Socket, parse func(string, []string) (Socket, bool)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // Header
+	for scanner.Scan() {
+		if socket, ok := parse(protocol, strings.Fields(scanner.Text())); ok {
+			sockets[socket.Inode] = socket
+		}
+	}
+	return scanner.Err()
+}
+
+// parseInet parses a line of /proc/net/{tcp,udp,raw}[6]:
+// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ...
+func parseInet(protocol string, fields []string) (Socket, bool) {
+	if len(fields) < 10 {
+		return Socket{}, false
+	}
+	inode, err := strconv.ParseUint(fields[9], 10, 64)
+	if err != nil || inode == 0 {
+		return Socket{}, false
+	}
+	local, ok := parseAddress(fields[1])
+	if !ok {
+		return Socket{}, false
+	}
+	remote, ok := parseAddress(fields[2])
+	if !ok {
+		return Socket{}, false
+	}
+
+	// Unconnected sockets have the peer 0.0.0.0:0 or [::]:0, while a connected
+	// UDP socket may have a peer on port 0
+	socket := Socket{Protocol: protocol, Local: local, Inode: inode}
+	if strings.Trim(fields[2], "0:") != "" {
+		socket.Remote = remote
+	}
+	switch {
+	case strings.HasPrefix(protocol, "tcp"):
+		socket.State = tcpStates[fields[3]]
+	case fields[3] == "01":
+		socket.State = "ESTABLISHED"
+	default:
+		socket.State = "UNCONN"
+	}
+	return socket, true
+}
+
+// parseAddress decodes an address:port pair from a socket table. The address is
+// printed as 32-bit words in host byte order, the port as a number.
+func parseAddress(value string) (string, bool) {
+	address, port, ok := strings.Cut(value, ":")
+	if !ok {
+		return "", false
+	}
+	raw, err := hex.DecodeString(address)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", false
+	}
+	number, err := strconv.ParseUint(port, 16, 16)
+	if err != nil {
+		return "", false
+	}
+
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		binary.NativeEndian.PutUint32(ip[i:], binary.BigEndian.Uint32(raw[i:]))
+	}
+	return net.JoinHostPort(ip.String(), strconv.FormatUint(number, 10)), true
+}
+
+// parseUnix parses a line of /proc/net/unix:
+// Num RefCount Protocol Flags Type St Inode Path
+func parseUnix(protocol string, fields []string) (Socket, bool) {
+	if len(fields) < 7 {
+		return Socket{}, false
+	}
+	inode, err := strconv.ParseUint(fields[6], 10, 64)
+	if err != nil {
+		return Socket{}, false
+	}
+
+	socket := Socket{Protocol: protocol, State: unixStates[fields[5]], Inode: inode}
+	if flags, err := strconv.ParseUint(fields[3], 16, 32); err == nil && flags&unixAcceptConn != 0 {
+		socket.State = "LISTEN"
+	}
+	if len(fields) > 7 {
+		socket.Local = fields[7]
+	}
+	return socket, true
+}
@@ -0,0 +1,181 @@
+package netaudit
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// procAddress formats an address and port the way the kernel prints them in /proc/net
+func procAddress(ip string, port uint16) string {
+	raw := net.ParseIP(ip)
+	if v4 := raw.To4(); v4 != nil {
+		raw = v4
+	}
+	var address strings.Builder
+	for i := 0; i < len(raw); i += 4 {
+		fmt.Fprintf(&address, "%08X", binary.NativeEndian.Uint32(raw[i:]))
+	}
+	return fmt.Sprintf("%s:%04X", address.String(), port)
+}
+
+// inetLine is a line of /proc/net/{tcp,udp}[6] for a socket
+func inetLine(local, remote, state string, inode int) string {
+	return fmt.Sprintf("   0: %s %s %s 00000000:00000000 00:00000000 00000000  1000        0 %d 1 0000000000000000 100 0 0 10 0",
+		local, remote, state, inode)
+}
+
+func TestParseInet(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+		line     string
+		want     Socket
+		ok       bool
+	}{
+		{
+			name:     "tcp listener",
+			protocol: "tcp",
+			line:     inetLine(procAddress("127.0.0.1", 8080), procAddress("0.0.0.0", 0), "0A", 1234),
+			want:     Socket{Protocol: "tcp", Local: "127.0.0.1:8080", State: "LISTEN", Inode: 1234},
+			ok:       true,
+		},
+		{
+			name:     "tcp connection",
+			protocol: "tcp",
+			line:     inetLine(procAddress("10.0.0.2", 45138), procAddress("93.184.216.34", 443), "01", 1235),
+			want:     Socket{Protocol: "tcp", Local: "10.0.0.2:45138", Remote: "93.184.216.34:443", State: "ESTABLISHED", Inode: 1235},
+			ok:       true,
+		},
+		{
+			name:     "tcp6 connection",
+			protocol: "tcp6",
+			line:     inetLine(procAddress("::1", 40000), procAddress("2001:db8::1", 22), "06", 1236),
+			want:     Socket{Protocol: "tcp6", Local: "[::1]:40000", Remote: "[2001:db8::1]:22", State: "TIME_WAIT", Inode: 1236},
+			ok:       true,
+		},
+		{
+			name:     "unconnected udp",
+			protocol: "udp",
+			line:     inetLine(procAddress("0.0.0.0", 53), procAddress("0.0.0.0", 0), "07", 1237),
+			want:     Socket{Protocol: "udp", Local: "0.0.0.0:53", State: "UNCONN", Inode: 1237},
+			ok:       true,
+		},
+		{
+			name:     "unconnected udp6",
+			protocol: "udp6",
+			line:     inetLine(procAddress("::", 5353), procAddress("::", 0), "07", 1238),
+			want:     Socket{Protocol: "udp6", Local: "[::]:5353", State: "UNCONN", Inode: 1238},
+			ok:       true,
+		},
+		{
+			name:     "udp connected to port 0",
+			protocol: "udp",
+			line:     inetLine(procAddress("10.0.0.2", 39000), procAddress("10.0.0.1", 0), "01", 1239),
+			want:     Socket{Protocol: "udp", Local: "10.0.0.2:39000", Remote: "10.0.0.1:0", State: "ESTABLISHED", Inode: 1239},
+			ok:       true,
+		},
+		{
+			name:     "udp connected to the unspecified address",
+			protocol: "udp",
+			line:     inetLine(procAddress("127.0.0.1", 39001), procAddress("0.0.0.0", 7), "01", 1240),
+			want:     Socket{Protocol: "udp", Local: "127.0.0.1:39001", Remote: "0.0.0.0:7", State: "ESTABLISHED", Inode: 1240},
+			ok:       true,
+		},
+		{
+			name:     "no inode",
+			protocol: "tcp",
+			line:     inetLine(procAddress("127.0.0.1", 8080), procAddress("127.0.0.1", 45138), "06", 0),
+		},
+		{
+			name:     "bad address",
+			protocol: "tcp",
+			line:     inetLine("0100007G:1F90", procAddress("0.0.0.0", 0), "0A", 1241),
+		},
+		{
+			name:     "short line",
+			protocol: "tcp",
+			line:     "   0: 0100007F:1F90 00000000:0000 0A",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := parseInet(test.protocol, strings.Fields(test.line))
+			if ok != test.ok || got != test.want {
+				t.Errorf("parseInet = %+v, %v, want %+v, %v", got, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+func TestParseUnix(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want Socket
+		ok   bool
+	}{
+		{
+			name: "listening",
+			line: "0000000000000000: 00000002 00000000 00010000 0001 01 20001 /run/app.sock",
+			want: Socket{Protocol: "unix", Local: "/run/app.sock", State: "LISTEN", Inode: 20001},
+			ok:   true,
+		},
+		{
+			name: "connected and unnamed",
+			line: "0000000000000000: 00000003 00000000 00000000 0001 03 20002",
+			want: Socket{Protocol: "unix", State: "CONNECTED", Inode: 20002},
+			ok:   true,
+		},
+		{
+			name: "abstract datagram",
+			line: "0000000000000000: 00000002 00000000 00000000 0002 01 20003 @journal",
+			want: Socket{Protocol: "unix", Local: "@journal", State: "UNCONN", Inode: 20003},
+			ok:   true,
+		},
+		{
+			name: "short line",
+			line: "0000000000000000: 00000002 00000000 00000000 0001 01",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := parseUnix("unix", strings.Fields(test.line))
+			if ok != test.ok || got != test.want {
+				t.Errorf("parseUnix = %+v, %v, want %+v, %v", got, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+func TestReadTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tcp")
+	table := strings.Join([]string{
+		"  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode",
+		inetLine(procAddress("127.0.0.1", 8080), procAddress("0.0.0.0", 0), "0A", 1234),
+		inetLine(procAddress("127.0.0.1", 8080), procAddress("127.0.0.1", 45138), "06", 0),
+		inetLine(procAddress("127.0.0.1", 45138), procAddress("127.0.0.1", 8080), "01", 1235),
+	}, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(table), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sockets := make(map[uint64]Socket)
+	if err := readTable(path, "tcp", sockets, parseInet); err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint64]Socket{
+		1234: {Protocol: "tcp", Local: "127.0.0.1:8080", State: "LISTEN", Inode: 1234},
+		1235: {Protocol: "tcp", Local: "127.0.0.1:45138", Remote: "127.0.0.1:8080", State: "ESTABLISHED", Inode: 1235},
+	}
+	if !reflect.DeepEqual(sockets, want) {
+		t.Errorf("sockets = %+v, want %+v", sockets, want)
+	}
+}
@@ -0,0 +1,73 @@
+package netaudit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Traffic is the data moved through the interfaces of a network namespace
+type Traffic struct {
+	RxBytes   uint64
+	RxPackets uint64
+	TxBytes   uint64
+	TxPackets uint64
+}
+
+// TrafficCounter reads the interface counters of one network namespace
+type TrafficCounter struct {
+	file *os.File
+}
+
+// OpenTraffic opens the interface counters of the network namespace pid is in.
+// The open file keeps the namespace readable after every process in it exited.
+func OpenTraffic(pid int) (*TrafficCounter, error) {
+	file, err := os.Open(filepath.Join("/proc", strconv.Itoa(pid), "net", "dev"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open interface counters: %v", err)
+	}
+	return &TrafficCounter{file: file}, nil
+}
+
+// Read sums the counters of every interface. Loopback traffic is counted
+// both as received and as sent.
+func (c *TrafficCounter) Read() (*Traffic, error) {
+	if _, err := c.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read interface counters: %v", err)
+	}
+	data, err := io.ReadAll(c.file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read interface counters: %v", err)
+	}
+
+	// Inter-|   Receive                                    |  Transmit
+	//  face |bytes packets errs drop fifo frame compressed multicast|bytes packets ...
+	traffic := &Traffic{}
+	for _, line := range strings.Split(string(data), "\n") {
+		_, counters, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(counters)
+		if len(fields) < 10 {
+			continue
+		}
+		values := make([]uint64, 10)
+		for i := range values {
+			values[i], _ = strconv.ParseUint(fields[i], 10, 64)
+		}
+		traffic.RxBytes += values[0]
+		traffic.RxPackets += values[1]
+		traffic.TxBytes += values[8]
+		traffic.TxPackets += values[9]
+	}
+	return traffic, nil
+}
+
+// Close releases the namespace
+func (c *TrafficCounter) Close() {
+	c.file.Close()
+}
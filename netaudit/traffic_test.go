@@ -0,0 +1,65 @@
+package netaudit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrafficRead(t *testing.T) {
+	tests := []struct {
+		name string
+		dev  string
+		want Traffic
+	}{
+		{
+			name: "loopback only",
+			dev: `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    5432       8    0    0    0     0          0         0     5432       8    0    0    0     0       0          0
+`,
+			want: Traffic{RxBytes: 5432, RxPackets: 8, TxBytes: 5432, TxPackets: 8},
+		},
+		{
+			name: "every interface",
+			dev: `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:     100       2    0    0    0     0          0         0      100       2    0    0    0     0       0          0
+  eth0:1000000     700    0    0    0     0          0         0    20000     300    0    0    0     0       0          0
+`,
+			want: Traffic{RxBytes: 1000100, RxPackets: 702, TxBytes: 20100, TxPackets: 302},
+		},
+		{
+			name: "no interfaces",
+			dev: `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dev")
+			if err := os.WriteFile(path, []byte(test.dev), 0644); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			counter := &TrafficCounter{file: file}
+			defer counter.Close()
+
+			// Reading again starts over rather than adding up
+			for range 2 {
+				got, err := counter.Read()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if *got != test.want {
+					t.Errorf("traffic = %+v, want %+v", *got, test.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package otlp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span status codes from the OTLP specification
+const (
+	StatusUnset = 0
+	StatusOk    = 1
+	StatusError = 2
+)
+
+// spanKindInternal marks spans that do not cross a process boundary
+const spanKindInternal = 1
+
+// Exporter sends spans and metrics to an OTLP/HTTP endpoint using the JSON encoding
+type Exporter struct {
+	Endpoint    string // Base URL of the collector, e.g. http://localhost:4318
+	ServiceName string
+	client      *http.Client
+	mu          sync.Mutex
+	spans       []*Span
+}
+
+// NewExporter creates an exporter for the given collector endpoint
+func NewExporter(endpoint string, serviceName string) *Exporter {
+	return &Exporter{
+		Endpoint:    strings.TrimRight(endpoint, "/"),
+		ServiceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Span is a single timed operation
+type Span struct {
+	Name          string
+	TraceID       string
+	SpanID        string
+	ParentSpanID  string
+	Start         time.Time
+	End           time.Time
+	Attributes    map[string]interface{}
+	StatusCode    int
+	StatusMessage string
+	exporter      *Exporter
+}
+
+// StartSpan starts a span. When parent is nil the span starts a new trace.
+func (e *Exporter) StartSpan(name string, parent *Span) *Span {
+	span := &Span{
+		Name:       name,
+		SpanID:     randomHex(8),
+		Start:      time.Now(),
+		Attributes: make(map[string]interface{}),
+		exporter:   e,
+	}
+	if parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentSpanID = parent.SpanID
+	} else {
+		span.TraceID = randomHex(16)
+	}
+	return span
+}
+
+// SetAttribute sets a string, bool, integer or float attribute on the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.Attributes[key] = value
+}
+
+// SetStatus sets the span status
+func (s *Span) SetStatus(code int, message string) {
+	s.StatusCode = code
+	s.StatusMessage = message
+}
+
+// Finish ends the span and queues it for export
+func (s *Span) Finish() {
+	s.End = time.Now()
+	s.exporter.mu.Lock()
+	s.exporter.spans = append(s.exporter.spans, s)
+	s.exporter.mu.Unlock()
+}
+
+// ExportSpans sends every finished span to the collector
+func (e *Exporter) ExportSpans() error {
+	e.mu.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	jsonSpans := make([]map[string]interface{}, 0, len(spans))
+	for _, span := range spans {
+		js := map[string]interface{}{
+			"traceId":           span.TraceID,
+			"spanId":            span.SpanID,
+			"name":              span.Name,
+			"kind":              spanKindInternal,
+			"startTimeUnixNano": unixNano(span.Start),
+			"endTimeUnixNano":   unixNano(span.End),
+			"attributes":        encodeAttributes(span.Attributes),
+			"status": map[string]interface{}{
+				"code":    span.StatusCode,
+				"message": span.StatusMessage,
+			},
+		}
+		if span.ParentSpanID != "" {
+			js["parentSpanId"] = span.ParentSpanID
+		}
+		jsonSpans = append(jsonSpans, js)
+	}
+
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": e.resource(),
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": e.scope(),
+						"spans": jsonSpans,
+					},
+				},
+			},
+		},
+	}
+	return e.post("/v1/traces", payload)
+}
+
+// DataPoint is a single gauge value at a point in time
+type DataPoint struct {
+	Time       time.Time
+	Value      float64
+	Attributes map[string]interface{}
+}
+
+// Gauge is a named series of data points
+type Gauge struct {
+	Name        string
+	Description string
+	Unit        string
+	Integer     bool // Encode values as integers rather than doubles
+	Points      []DataPoint
+}
+
+// ExportMetrics sends gauges to the collector
+func (e *Exporter) ExportMetrics(gauges []*Gauge) error {
+	metrics := make([]interface{}, 0, len(gauges))
+	for _, gauge := range gauges {
+		if len(gauge.Points) == 0 {
+			continue
+		}
+
+		points := make([]interface{}, 0, len(gauge.Points))
+		for _, point := range gauge.Points {
+			jp := map[string]interface{}{
+				"timeUnixNano": unixNano(point.Time),
+				"attributes":   encodeAttributes(point.Attributes),
+			}
+			if gauge.Integer {
+				jp["asInt"] = strconv.FormatInt(int64(point.Value), 10)
+			} else {
+				jp["asDouble"] = point.Value
+			}
+			points = append(points, jp)
+		}
+
+		metrics = append(metrics, map[string]interface{}{
+			"name":        gauge.Name,
+			"description": gauge.Description,
+			"unit":        gauge.Unit,
+			"gauge":       map[string]interface{}{"dataPoints": points},
+		})
+	}
+
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	payload := map[string]interface{}{
+		"resourceMetrics": []interface{}{
+			map[string]interface{}{
+				"resource": e.resource(),
+				"scopeMetrics": []interface{}{
+					map[string]interface{}{
+						"scope":   e.scope(),
+						"metrics": metrics,
+					},
+				},
+			},
+		},
+	}
+	return e.post("/v1/metrics", payload)
+}
+
+// resource describes the process producing the telemetry
+func (e *Exporter) resource() map[string]interface{} {
+	return map[string]interface{}{
+		"attributes": encodeAttributes(map[string]interface{}{
+			"service.name": e.ServiceName,
+		}),
+	}
+}
+
+// scope describes the instrumentation library
+func (e *Exporter) scope() map[string]interface{} {
+	return map[string]interface{}{"name": "kernelscope"}
+}
+
+// post sends a JSON payload to the given OTLP path
+func (e *Exporter) post(path string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode OTLP payload: %v", err)
+	}
+
+	resp, err := e.client.Post(e.Endpoint+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to send OTLP payload: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector rejected %s with status %s", path, resp.Status)
+	}
+	return nil
+}
+
+// encodeAttributes converts attributes to OTLP key/value pairs
+func encodeAttributes(attributes map[string]interface{}) []interface{} {
+	encoded := make([]interface{}, 0, len(attributes))
+	for key, value := range attributes {
+		var v map[string]interface{}
+		switch typed := value.(type) {
+		case string:
+			v = map[string]interface{}{"stringValue": typed}
+		case bool:
+			v = map[string]interface{}{"boolValue": typed}
+		case int:
+			v = map[string]interface{}{"intValue": strconv.FormatInt(int64(typed), 10)}
+		case int64:
+			v = map[string]interface{}{"intValue": strconv.FormatInt(typed, 10)}
+		case uint64:
+			v = map[string]interface{}{"intValue": strconv.FormatUint(typed, 10)}
+		case float64:
+			v = map[string]interface{}{"doubleValue": typed}
+		default:
+			v = map[string]interface{}{"stringValue": fmt.Sprint(typed)}
+		}
+		encoded = append(encoded, map[string]interface{}{"key": key, "value": v})
+	}
+	return encoded
+}
+
+// unixNano formats a time as the decimal string OTLP/JSON uses for 64-bit integers
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
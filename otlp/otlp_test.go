@@ -0,0 +1,277 @@
+package otlp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector records the payloads posted to it, by path
+type collector struct {
+	mu       sync.Mutex
+	payloads map[string][]byte
+	types    map[string]string
+	status   int
+}
+
+func newCollector(t *testing.T, status int) (*collector, *httptest.Server) {
+	c := &collector{payloads: make(map[string][]byte), types: make(map[string]string), status: status}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read request: %v", err)
+		}
+		c.mu.Lock()
+		c.payloads[r.URL.Path] = body
+		c.types[r.URL.Path] = r.Header.Get("Content-Type")
+		c.mu.Unlock()
+		w.WriteHeader(c.status)
+	}))
+	t.Cleanup(server.Close)
+	return c, server
+}
+
+// payload decodes what was posted to path
+func (c *collector) payload(t *testing.T, path string, into interface{}) {
+	t.Helper()
+	c.mu.Lock()
+	body, ok := c.payloads[path]
+	contentType := c.types[path]
+	c.mu.Unlock()
+	if !ok {
+		t.Fatalf("nothing was posted to %s", path)
+	}
+	if contentType != "application/json" {
+		t.Errorf("%s Content-Type = %q, want application/json", path, contentType)
+	}
+	if err := json.Unmarshal(body, into); err != nil {
+		t.Fatalf("invalid payload posted to %s: %v\n%s", path, err, body)
+	}
+}
+
+// keyValue is an OTLP/JSON attribute
+type keyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue *string  `json:"stringValue"`
+		BoolValue   *bool    `json:"boolValue"`
+		IntValue    *string  `json:"intValue"`
+		DoubleValue *float64 `json:"doubleValue"`
+	} `json:"value"`
+}
+
+// attributes indexes OTLP/JSON attributes by key
+func attributes(list []keyValue) map[string]keyValue {
+	byKey := make(map[string]keyValue, len(list))
+	for _, kv := range list {
+		byKey[kv.Key] = kv
+	}
+	return byKey
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type tracesPayload struct {
+	ResourceSpans []struct {
+		Resource   resource `json:"resource"`
+		ScopeSpans []struct {
+			Scope scope `json:"scope"`
+			Spans []struct {
+				TraceID           string     `json:"traceId"`
+				SpanID            string     `json:"spanId"`
+				ParentSpanID      string     `json:"parentSpanId"`
+				Name              string     `json:"name"`
+				Kind              int        `json:"kind"`
+				StartTimeUnixNano string     `json:"startTimeUnixNano"`
+				EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+				Attributes        []keyValue `json:"attributes"`
+				Status            struct {
+					Code    int    `json:"code"`
+					Message string `json:"message"`
+				} `json:"status"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+type metricsPayload struct {
+	ResourceMetrics []struct {
+		Resource     resource `json:"resource"`
+		ScopeMetrics []struct {
+			Scope   scope `json:"scope"`
+			Metrics []struct {
+				Name        string `json:"name"`
+				Description string `json:"description"`
+				Unit        string `json:"unit"`
+				Gauge       struct {
+					DataPoints []struct {
+						TimeUnixNano string     `json:"timeUnixNano"`
+						AsInt        *string    `json:"asInt"`
+						AsDouble     *float64   `json:"asDouble"`
+						Attributes   []keyValue `json:"attributes"`
+					} `json:"dataPoints"`
+				} `json:"gauge"`
+			} `json:"metrics"`
+		} `json:"scopeMetrics"`
+	} `json:"resourceMetrics"`
+}
+
+func TestExportSpans(t *testing.T) {
+	c, server := newCollector(t, http.StatusOK)
+	exporter := NewExporter(server.URL+"/", "test-service")
+
+	run := exporter.StartSpan("kernelscope.run", nil)
+	iteration := exporter.StartSpan("kernelscope.iteration", run)
+	iteration.SetAttribute("process.exit_code", 3)
+	iteration.SetAttribute("kernelscope.cpu_seconds", 1.5)
+	iteration.SetAttribute("kernelscope.peak_memory_kb", uint64(2048))
+	iteration.SetAttribute("kernelscope.binary", "/bin/true")
+	iteration.SetAttribute("kernelscope.cached", true)
+	iteration.SetStatus(StatusError, "exit code 3")
+	iteration.Finish()
+	run.SetStatus(StatusOk, "")
+	run.Finish()
+
+	if err := exporter.ExportSpans(); err != nil {
+		t.Fatalf("ExportSpans: %v", err)
+	}
+
+	var payload tracesPayload
+	c.payload(t, "/v1/traces", &payload)
+	if len(payload.ResourceSpans) != 1 || len(payload.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("want one resource and one scope, got %+v", payload)
+	}
+	resourceSpans := payload.ResourceSpans[0]
+	if name := attributes(resourceSpans.Resource.Attributes)["service.name"].Value.StringValue; name == nil || *name != "test-service" {
+		t.Errorf("service.name = %v, want test-service", name)
+	}
+	if got := resourceSpans.ScopeSpans[0].Scope.Name; got != "kernelscope" {
+		t.Errorf("scope name = %q, want kernelscope", got)
+	}
+
+	spans := resourceSpans.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	child, parent := spans[0], spans[1]
+	if child.Name != "kernelscope.iteration" || parent.Name != "kernelscope.run" {
+		t.Fatalf("spans = %q, %q; want the iteration, then the run", child.Name, parent.Name)
+	}
+	if len(parent.TraceID) != 32 || len(parent.SpanID) != 16 {
+		t.Errorf("trace ID %q and span ID %q must be 16 and 8 bytes of hex", parent.TraceID, parent.SpanID)
+	}
+	if child.TraceID != parent.TraceID || child.ParentSpanID != parent.SpanID || parent.ParentSpanID != "" {
+		t.Errorf("iteration must be a child of the run in the same trace: %+v / %+v", child, parent)
+	}
+	if child.Kind != spanKindInternal {
+		t.Errorf("kind = %d, want %d", child.Kind, spanKindInternal)
+	}
+	if child.StartTimeUnixNano == "" || child.EndTimeUnixNano < child.StartTimeUnixNano {
+		t.Errorf("bad span times %q - %q", child.StartTimeUnixNano, child.EndTimeUnixNano)
+	}
+	if child.Status.Code != StatusError || child.Status.Message != "exit code 3" || parent.Status.Code != StatusOk {
+		t.Errorf("statuses = %+v and %+v", child.Status, parent.Status)
+	}
+
+	attrs := attributes(child.Attributes)
+	if v := attrs["process.exit_code"].Value.IntValue; v == nil || *v != "3" {
+		t.Errorf("process.exit_code = %v, want intValue \"3\"", v)
+	}
+	if v := attrs["kernelscope.peak_memory_kb"].Value.IntValue; v == nil || *v != "2048" {
+		t.Errorf("kernelscope.peak_memory_kb = %v, want intValue \"2048\"", v)
+	}
+	if v := attrs["kernelscope.cpu_seconds"].Value.DoubleValue; v == nil || *v != 1.5 {
+		t.Errorf("kernelscope.cpu_seconds = %v, want doubleValue 1.5", v)
+	}
+	if v := attrs["kernelscope.binary"].Value.StringValue; v == nil || *v != "/bin/true" {
+		t.Errorf("kernelscope.binary = %v, want stringValue /bin/true", v)
+	}
+	if v := attrs["kernelscope.cached"].Value.BoolValue; v == nil || !*v {
+		t.Errorf("kernelscope.cached = %v, want boolValue true", v)
+	}
+
+	// Exported spans are not sent again
+	c.mu.Lock()
+	delete(c.payloads, "/v1/traces")
+	c.mu.Unlock()
+	if err := exporter.ExportSpans(); err != nil {
+		t.Fatalf("second ExportSpans: %v", err)
+	}
+	if _, ok := c.payloads["/v1/traces"]; ok {
+		t.Errorf("spans were exported twice")
+	}
+}
+
+func TestExportMetrics(t *testing.T) {
+	c, server := newCollector(t, http.StatusOK)
+	exporter := NewExporter(server.URL, "test-service")
+
+	at := time.Unix(1700000000, 5)
+	labels := map[string]interface{}{"kernelscope.binary": "/bin/true"}
+	gauges := []*Gauge{
+		{Name: "kernelscope.cpu.time", Unit: "s", Points: []DataPoint{{Time: at, Value: 0.25, Attributes: labels}}},
+		{Name: "kernelscope.memory.rss", Unit: "By", Integer: true, Points: []DataPoint{{Time: at, Value: 4096, Attributes: labels}}},
+		{Name: "kernelscope.threads", Unit: "{thread}", Integer: true}, // No points, left out
+	}
+	if err := exporter.ExportMetrics(gauges); err != nil {
+		t.Fatalf("ExportMetrics: %v", err)
+	}
+
+	var payload metricsPayload
+	c.payload(t, "/v1/metrics", &payload)
+	if len(payload.ResourceMetrics) != 1 || len(payload.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatalf("want one resource and one scope, got %+v", payload)
+	}
+	if name := attributes(payload.ResourceMetrics[0].Resource.Attributes)["service.name"].Value.StringValue; name == nil || *name != "test-service" {
+		t.Errorf("service.name = %v, want test-service", name)
+	}
+
+	metrics := payload.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	if len(metrics) != 2 {
+		t.Fatalf("got %d metrics, want 2 as empty gauges are left out", len(metrics))
+	}
+
+	cpu, rss := metrics[0], metrics[1]
+	if cpu.Name != "kernelscope.cpu.time" || cpu.Unit != "s" || len(cpu.Gauge.DataPoints) != 1 {
+		t.Fatalf("unexpected CPU gauge %+v", cpu)
+	}
+	point := cpu.Gauge.DataPoints[0]
+	if point.AsDouble == nil || *point.AsDouble != 0.25 || point.AsInt != nil {
+		t.Errorf("CPU point = %+v, want asDouble 0.25", point)
+	}
+	if point.TimeUnixNano != "1700000000000000005" {
+		t.Errorf("timeUnixNano = %q, want 1700000000000000005", point.TimeUnixNano)
+	}
+	if v := attributes(point.Attributes)["kernelscope.binary"].Value.StringValue; v == nil || *v != "/bin/true" {
+		t.Errorf("point attribute kernelscope.binary = %v", v)
+	}
+
+	if rss.Name != "kernelscope.memory.rss" || len(rss.Gauge.DataPoints) != 1 {
+		t.Fatalf("unexpected RSS gauge %+v", rss)
+	}
+	if point := rss.Gauge.DataPoints[0]; point.AsInt == nil || *point.AsInt != "4096" || point.AsDouble != nil {
+		t.Errorf("RSS point = %+v, want asInt \"4096\"", point)
+	}
+}
+
+func TestExportRejected(t *testing.T) {
+	_, server := newCollector(t, http.StatusBadRequest)
+	exporter := NewExporter(server.URL, "test-service")
+
+	exporter.StartSpan("kernelscope.run", nil).Finish()
+	err := exporter.ExportSpans()
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("ExportSpans error = %v, want the collector's 400", err)
+	}
+}
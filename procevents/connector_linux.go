@@ -0,0 +1,154 @@
+package procevents
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"kernelscope/utils"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Proc connector constants from linux/connector.h and linux/cn_proc.h
+const (
+	cnIdxProc          = 1
+	cnValProc          = 1
+	procCnMcastListen  = 1
+	procEventFork      = 0x00000001
+	procEventExec      = 0x00000002
+	procEventExit      = 0x80000000
+	netlinkHeaderSize  = 16
+	connectorHeaderLen = 20
+	procEventHeaderLen = 16 // what, cpu and timestamp_ns, ahead of the event data
+)
+
+// Listener follows a process tree through the kernel's proc connector
+type Listener struct {
+	Events <-chan Event
+
+	file    *os.File
+	members map[int]bool // Processes in the tree that are still running, only used by the reader
+}
+
+// Listen subscribes to the proc connector and reports the forks, execs and
+// exits of root and its descendants. It needs CAP_NET_ADMIN.
+func Listen(root int) (*Listener, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, unix.NETLINK_CONNECTOR)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open proc connector: %v", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: cnIdxProc}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind proc connector: %v", err)
+	}
+
+	// Subscribe with a PROC_CN_MCAST_LISTEN message
+	message := make([]byte, netlinkHeaderSize+connectorHeaderLen+4)
+	binary.NativeEndian.PutUint32(message[0:], uint32(len(message)))
+	binary.NativeEndian.PutUint16(message[4:], unix.NLMSG_DONE)
+	binary.NativeEndian.PutUint32(message[12:], uint32(os.Getpid()))
+	binary.NativeEndian.PutUint32(message[16:], cnIdxProc)
+	binary.NativeEndian.PutUint32(message[20:], cnValProc)
+	binary.NativeEndian.PutUint16(message[32:], 4)
+	binary.NativeEndian.PutUint32(message[36:], procCnMcastListen)
+	if err := unix.Sendto(fd, message, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to subscribe to proc connector: %v", err)
+	}
+
+	// Processes the tree started before the subscription produce no fork
+	// events, so they are members from the start
+	members := map[int]bool{root: true}
+	children, _ := utils.GetAllChildProcesses(root)
+	for _, child := range children {
+		members[child] = true
+	}
+
+	events := NewChannel()
+	listener := &Listener{
+		Events:  events,
+		file:    os.NewFile(uintptr(fd), "proc-connector"),
+		members: members,
+	}
+	go listener.read(events)
+	return listener, nil
+}
+
+// Close unsubscribes from the proc connector and closes the event channel
+func (l *Listener) Close() {
+	l.file.Close()
+}
+
+// read parses proc connector messages until the listener is closed
+func (l *Listener) read(events chan<- Event) {
+	defer close(events)
+
+	buffer := make([]byte, 8192)
+	for {
+		n, err := l.file.Read(buffer)
+		if errors.Is(err, unix.ENOBUFS) {
+			// Events were lost, the monitor's sampling fills the gaps
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		for data := buffer[:n]; len(data) >= netlinkHeaderSize; {
+			length := int(binary.NativeEndian.Uint32(data[0:]))
+			if length < netlinkHeaderSize || length > len(data) {
+				break
+			}
+			l.parse(data[netlinkHeaderSize:length], events)
+			data = data[(length+unix.NLMSG_ALIGNTO-1)&^(unix.NLMSG_ALIGNTO-1):]
+		}
+	}
+}
+
+// parse handles one proc event, reporting it if it concerns the tree
+func (l *Listener) parse(message []byte, events chan<- Event) {
+	if len(message) < connectorHeaderLen+procEventHeaderLen+24 {
+		return
+	}
+	event := message[connectorHeaderLen:]
+	what := binary.NativeEndian.Uint32(event[0:])
+	data := event[procEventHeaderLen:]
+	field := func(i int) int { return int(binary.NativeEndian.Uint32(data[4*i:])) }
+
+	switch what {
+	case procEventFork:
+		parent, child, childTgid := field(1), field(2), field(3)
+		// Threads belong to a process that is already followed
+		if child != childTgid || !l.members[parent] {
+			return
+		}
+		l.members[child] = true
+		Send(events, Event{Kind: Fork, Time: time.Now(), Pid: child, PPid: parent})
+
+	case procEventExec:
+		pid := field(1)
+		if l.members[pid] {
+			Send(events, ExecEvent(pid))
+		}
+
+	case procEventExit:
+		pid, tgid, status := field(0), field(1), field(2)
+		if pid != tgid || !l.members[pid] {
+			return
+		}
+		delete(l.members, pid)
+
+		exitCode, killedBy := -1, 0
+		if wait := unix.WaitStatus(status); wait.Exited() {
+			exitCode = wait.ExitStatus()
+		} else if wait.Signaled() {
+			killedBy = int(wait.Signal())
+		}
+		// The PID may already be reaped or reused by the time the monitor reads
+		// /proc, so only the payload's status is reported and the usage is left
+		// to the last sample
+		Send(events, Event{Kind: Exit, Time: time.Now(), Pid: pid, ExitCode: exitCode, ExitSignal: killedBy})
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package procevents
+
+import "fmt"
+
+// Listener follows a process tree through the kernel's proc connector
+type Listener struct {
+	Events <-chan Event
+}
+
+// Listen is unavailable because the proc connector is Linux-specific
+func Listen(root int) (*Listener, error) {
+	return nil, fmt.Errorf("the proc connector requires Linux")
+}
+
+// Close does nothing outside Linux
+func (l *Listener) Close() {}
@@ -0,0 +1,77 @@
+package procevents
+
+import (
+	"kernelscope/utils"
+	"time"
+)
+
+// Kinds of process event
+const (
+	Fork = "fork"
+	Exec = "exec"
+	Exit = "exit"
+)
+
+// eventBuffer is how many events may wait for the monitor before new ones are dropped
+const eventBuffer = 4096
+
+// Event is a process in the monitored tree forking, executing a program or exiting
+type Event struct {
+	Kind string
+	Time time.Time
+	Pid  int
+	PPid int // Parent process, for forks and execs
+
+	// Set for exec
+	Comm    string
+	Exe     string
+	Cmdline []string
+	Cwd     string
+
+	// Set for exit
+	ExitCode     int           // Exit status, -1 if killed by a signal
+	ExitSignal   int           // Signal that killed the process, 0 if it exited
+	CpuTime      float64       // Final CPU time in seconds, 0 if unknown
+	PeakMemoryKB uint64        // Peak resident memory in KB, 0 if unknown
+	IO           *utils.ProcIO // Final I/O counters, nil if unknown
+}
+
+// ExecEvent describes the program pid has just executed, from /proc
+func ExecEvent(pid int) Event {
+	event := Event{Kind: Exec, Time: time.Now(), Pid: pid}
+	if stats, err := utils.ReadProcStats(pid); err == nil {
+		event.Comm = stats.Comm
+		event.PPid = stats.PPid
+	}
+	event.Exe, _ = utils.ReadExe(pid)
+	event.Cmdline, _ = utils.ReadCmdline(pid)
+	event.Cwd, _ = utils.ReadCwd(pid)
+	return event
+}
+
+// ExitEvent describes pid exiting, with as much of its final usage as /proc still has.
+// The process must not have been reaped yet, e.g. stopped at PTRACE_EVENT_EXIT.
+func ExitEvent(pid int, exitCode int, signal int) Event {
+	event := Event{Kind: Exit, Time: time.Now(), Pid: pid, ExitCode: exitCode, ExitSignal: signal}
+	if stats, err := utils.ReadProcStats(pid); err == nil {
+		event.CpuTime = stats.CpuTime
+	}
+	event.PeakMemoryKB, _ = utils.ReadPeakMemoryKB(pid)
+	if io, err := utils.ReadProcIO(pid); err == nil {
+		event.IO = io
+	}
+	return event
+}
+
+// Send queues an event without blocking, dropping it if the monitor has fallen behind
+func Send(events chan<- Event, event Event) {
+	select {
+	case events <- event:
+	default:
+	}
+}
+
+// NewChannel makes a channel for a source of events
+func NewChannel() chan Event {
+	return make(chan Event, eventBuffer)
+}
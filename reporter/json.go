@@ -0,0 +1,331 @@
+package reporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"kernelscope/cli"
+	"kernelscope/executor"
+	"kernelscope/monitor"
+	"kernelscope/netaudit"
+	"kernelscope/timeseries"
+	"kernelscope/utils"
+	"os"
+	"time"
+)
+
+// JSONReport is the machine-readable form of the execution report
+type JSONReport struct {
+	Binary              string             `json:"binary"`
+	Args                []string           `json:"args,omitempty"`
+	Account             string             `json:"account,omitempty"`
+	StartTime           time.Time          `json:"start_time"`
+	EndTime             time.Time          `json:"end_time"`
+	DurationSeconds     float64            `json:"duration_seconds"`
+	CpuSeconds          float64            `json:"cpu_seconds"`
+	PeakMemoryKB        uint64             `json:"peak_memory_kb"`
+	PeakThreads         int                `json:"peak_threads"`
+	PeakFDs             int                `json:"peak_fds"`
+	PeakProcesses       int                `json:"peak_processes"`
+	IO                  JSONIO             `json:"io"`
+	ExitCode            int                `json:"exit_code"`
+	TermReason          string             `json:"termination_reason,omitempty"`
+	LoopCount           int                `json:"loop_iterations"`
+	SuccessCount        int                `json:"successful_iterations"`
+	SuccessReason       string             `json:"success_reason,omitempty"` // Why the run counted as a success or a failure
+	SampleCount         int                `json:"samples"`
+	SamplingOverheadSec float64            `json:"sampling_overhead_seconds"`
+	TimeSeries          timeseries.Summary `json:"timeseries"`
+	ProcessTree         []*JSONProcess     `json:"process_tree"`
+	ProcessEvents       string             `json:"process_events,omitempty"`      // Source of fork, exec and exit events, absent when processes were only sampled
+	StateSeconds        map[string]float64 `json:"state_seconds"`                 // Process-time in each scheduler state, by state name
+	WaitSeconds         map[string]float64 `json:"wait_channel_seconds"`          // Process-time blocked in each kernel wait channel
+	Diagnostics         []JSONDiagnostic   `json:"timeout_diagnostics,omitempty"` // Process states when a timeout fired
+	Identity            *executor.Identity `json:"identity,omitempty"`            // User and groups the binary ran as
+	Workspace           string             `json:"workspace,omitempty"`           // Per-run working directory, removed after the run
+	ArtifactsDir        string             `json:"artifacts_dir,omitempty"`       // Where the collected files were copied
+	Artifacts           []JSONArtifact     `json:"artifacts,omitempty"`           // Files collected from the workspace
+	FileChanges         []JSONFileChange   `json:"file_changes,omitempty"`        // Files the run created, modified or deleted
+	Syscalls            *JSONSyscalls      `json:"syscalls,omitempty"`            // System calls counted with --trace-syscalls
+	Network             *JSONNetwork       `json:"network,omitempty"`             // Sockets and traffic with --audit-network
+	CpuRateControl      string             `json:"cpu_rate_control,omitempty"`    // How --cpu-rate was held
+	ThrottledSeconds    float64            `json:"throttled_seconds,omitempty"`   // Time the tree was stopped or throttled by the CPU quota to hold --cpu-rate
+}
+
+// JSONNetwork is the network activity of an audited run
+type JSONNetwork struct {
+	Connections []JSONConnection `json:"connections"`
+	Sampled     bool             `json:"sampled"`           // Connections come from samples, so they are a lower bound
+	Traffic     *JSONTraffic     `json:"traffic,omitempty"` // Absent unless the run had its own network namespace
+}
+
+// JSONConnection is a socket held by a process in the tree
+type JSONConnection struct {
+	Protocol  string    `json:"protocol"`
+	Local     string    `json:"local"`
+	Remote    string    `json:"remote,omitempty"`
+	State     string    `json:"state"`
+	Inode     uint64    `json:"inode"`
+	Pid       int       `json:"pid"`
+	Comm      string    `json:"comm"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+// JSONTraffic is the traffic of the run's network namespace, with loopback
+// packets counted both as received and as sent
+type JSONTraffic struct {
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+}
+
+// JSONSyscalls is the system call summary of a traced run
+type JSONSyscalls struct {
+	Calls     int                  `json:"calls"`
+	Errors    int                  `json:"errors"`
+	Seconds   float64              `json:"seconds"` // Wall-clock time inside system calls
+	Syscalls  []JSONSyscall        `json:"syscalls"`
+	Processes []JSONProcessSyscall `json:"processes"`
+}
+
+// JSONSyscall counts the calls of one system call
+type JSONSyscall struct {
+	Name    string  `json:"name"`
+	Number  int     `json:"number"`
+	Calls   int     `json:"calls"`
+	Errors  int     `json:"errors"`
+	Seconds float64 `json:"seconds"`
+}
+
+// JSONProcessSyscall is the system calls of one traced process
+type JSONProcessSyscall struct {
+	Pid      int           `json:"pid"`
+	Comm     string        `json:"comm"`
+	Syscalls []JSONSyscall `json:"syscalls"`
+}
+
+// JSONFileChange is a file the run created, modified or deleted
+type JSONFileChange struct {
+	Path           string `json:"path"`   // Relative to the workspace, or beneath a watch path
+	Change         string `json:"change"` // created, modified or deleted
+	Size           int64  `json:"size"`
+	SHA256         string `json:"sha256,omitempty"` // "unreadable" if KernelScope could not read the file
+	PreviousSize   int64  `json:"previous_size"`
+	PreviousSHA256 string `json:"previous_sha256,omitempty"`
+}
+
+// JSONArtifact is a file collected from the workspace
+type JSONArtifact struct {
+	Path string `json:"path"` // Relative to the workspace and to artifacts_dir
+	Size int64  `json:"size"`
+}
+
+// JSONDiagnostic is the state of a process when a timeout fired
+type JSONDiagnostic struct {
+	Pid   int      `json:"pid"`
+	Comm  string   `json:"comm"`
+	State string   `json:"state"`
+	Wchan string   `json:"wchan,omitempty"`
+	Stack []string `json:"stack,omitempty"`
+}
+
+// JSONProcess is a process in the JSON process tree
+type JSONProcess struct {
+	Pid          int            `json:"pid"`
+	PPid         int            `json:"ppid"`
+	Comm         string         `json:"comm"`
+	Cmdline      []string       `json:"cmdline,omitempty"`
+	Exe          string         `json:"exe,omitempty"`
+	Cwd          string         `json:"cwd,omitempty"`
+	ExitCode     *int           `json:"exit_code,omitempty"`   // Absent if the exit was not observed, -1 if killed by a signal
+	ExitSignal   int            `json:"exit_signal,omitempty"` // Signal that killed the process
+	FirstSeen    time.Time      `json:"first_seen"`
+	LastSeen     time.Time      `json:"last_seen"`
+	CpuSeconds   float64        `json:"cpu_seconds"`
+	PeakMemoryKB uint64         `json:"peak_memory_kb"`
+	IO           JSONIO         `json:"io"`
+	Children     []*JSONProcess `json:"children,omitempty"`
+}
+
+// JSONIO holds I/O counters in the JSON report
+type JSONIO struct {
+	ReadBytes     uint64 `json:"read_bytes"`
+	WriteBytes    uint64 `json:"write_bytes"`
+	ReadSyscalls  uint64 `json:"read_syscalls"`
+	WriteSyscalls uint64 `json:"write_syscalls"`
+}
+
+// toJSONIO converts I/O counters into their JSON form
+func toJSONIO(io utils.ProcIO) JSONIO {
+	return JSONIO{
+		ReadBytes:     io.ReadBytes,
+		WriteBytes:    io.WriteBytes,
+		ReadSyscalls:  io.ReadSyscalls,
+		WriteSyscalls: io.WriteSyscalls,
+	}
+}
+
+// BuildJSONReport converts the final statistics into a JSON report
+func BuildJSONReport(config *cli.Config, finalStats *monitor.Stats) *JSONReport {
+	report := &JSONReport{
+		Binary:              config.BinaryPath,
+		Args:                config.Args,
+		Account:             config.Account,
+		StartTime:           finalStats.StartTime,
+		EndTime:             finalStats.EndTime,
+		DurationSeconds:     finalStats.EndTime.Sub(finalStats.StartTime).Seconds(),
+		CpuSeconds:          finalStats.CpuTimeUsed,
+		PeakMemoryKB:        finalStats.MaxMemoryKB,
+		PeakThreads:         finalStats.PeakThreads,
+		PeakFDs:             finalStats.PeakFDs,
+		PeakProcesses:       finalStats.PeakProcesses,
+		IO:                  toJSONIO(finalStats.IO),
+		ExitCode:            finalStats.ExitCode,
+		TermReason:          finalStats.TermReason,
+		LoopCount:           finalStats.LoopCount,
+		SuccessCount:        finalStats.SuccessCount,
+		SuccessReason:       finalStats.SuccessReason,
+		SampleCount:         finalStats.SampleCount,
+		SamplingOverheadSec: finalStats.SamplingOverhead.Seconds(),
+		TimeSeries:          timeseries.Summarize(finalStats.Samples),
+		ProcessTree:         []*JSONProcess{},
+		ProcessEvents:       finalStats.ProcessEvents,
+		CpuRateControl:      finalStats.CpuRateControl,
+		ThrottledSeconds:    finalStats.ThrottledTime.Seconds(),
+		Identity:            finalStats.Identity,
+		Workspace:           finalStats.Workspace,
+		ArtifactsDir:        finalStats.ArtifactsDir,
+	}
+	for _, artifact := range finalStats.Artifacts {
+		report.Artifacts = append(report.Artifacts, JSONArtifact{Path: artifact.Path, Size: artifact.Size})
+	}
+	if finalStats.Syscalls != nil {
+		report.Syscalls = toJSONSyscalls(finalStats.Syscalls)
+	}
+	if finalStats.Network != nil {
+		report.Network = toJSONNetwork(finalStats.Network)
+	}
+	for _, change := range finalStats.FileChanges {
+		report.FileChanges = append(report.FileChanges, JSONFileChange{
+			Path:           change.Path,
+			Change:         change.Kind,
+			Size:           change.Size,
+			SHA256:         change.Hash,
+			PreviousSize:   change.OldSize,
+			PreviousSHA256: change.OldHash,
+		})
+	}
+
+	for _, root := range BuildProcessTree(finalStats.Processes) {
+		report.ProcessTree = append(report.ProcessTree, toJSONProcess(root))
+	}
+
+	report.StateSeconds = make(map[string]float64, len(finalStats.StateTime))
+	for state, d := range finalStats.StateTime {
+		report.StateSeconds[utils.StateName(state)] += d.Seconds()
+	}
+	report.WaitSeconds = make(map[string]float64, len(finalStats.WaitTime))
+	for wchan, d := range finalStats.WaitTime {
+		report.WaitSeconds[wchan] = d.Seconds()
+	}
+	for _, d := range finalStats.Diagnostics {
+		report.Diagnostics = append(report.Diagnostics, JSONDiagnostic{
+			Pid:   d.Pid,
+			Comm:  d.Comm,
+			State: utils.StateName(d.State),
+			Wchan: d.Wchan,
+			Stack: d.Stack,
+		})
+	}
+
+	return report
+}
+
+// toJSONSyscalls converts a system call summary into its JSON form
+func toJSONSyscalls(summary *executor.SyscallSummary) *JSONSyscalls {
+	calls, errors, elapsed := executor.Totals(summary.Syscalls)
+	syscalls := &JSONSyscalls{
+		Calls:     calls,
+		Errors:    errors,
+		Seconds:   elapsed.Seconds(),
+		Syscalls:  toJSONSyscallList(summary.Syscalls),
+		Processes: []JSONProcessSyscall{},
+	}
+	for _, process := range summary.Processes {
+		syscalls.Processes = append(syscalls.Processes, JSONProcessSyscall{
+			Pid:      process.Pid,
+			Comm:     process.Comm,
+			Syscalls: toJSONSyscallList(process.Syscalls),
+		})
+	}
+	return syscalls
+}
+
+// toJSONNetwork converts the network activity into its JSON form
+func toJSONNetwork(activity *netaudit.Activity) *JSONNetwork {
+	network := &JSONNetwork{Connections: []JSONConnection{}, Sampled: true}
+	for _, conn := range activity.Connections {
+		network.Connections = append(network.Connections, JSONConnection{
+			Protocol:  conn.Protocol,
+			Local:     conn.Local,
+			Remote:    conn.Remote,
+			State:     conn.State,
+			Inode:     conn.Inode,
+			Pid:       conn.Pid,
+			Comm:      conn.Comm,
+			FirstSeen: conn.FirstSeen,
+		})
+	}
+	if traffic := activity.Traffic; traffic != nil {
+		network.Traffic = &JSONTraffic{RxBytes: traffic.RxBytes, RxPackets: traffic.RxPackets, TxBytes: traffic.TxBytes, TxPackets: traffic.TxPackets}
+	}
+	return network
+}
+
+// toJSONSyscallList converts system call counts into their JSON form
+func toJSONSyscallList(stats []executor.SyscallStat) []JSONSyscall {
+	list := []JSONSyscall{}
+	for _, stat := range stats {
+		list = append(list, JSONSyscall{Name: stat.Name, Number: stat.Number, Calls: stat.Calls, Errors: stat.Errors, Seconds: stat.Time.Seconds()})
+	}
+	return list
+}
+
+// toJSONProcess converts a process tree node into its JSON form
+func toJSONProcess(node *ProcessNode) *JSONProcess {
+	info := node.Info
+	jp := &JSONProcess{
+		Pid:          info.Pid,
+		PPid:         info.PPid,
+		Comm:         info.Comm,
+		Cmdline:      info.Cmdline,
+		FirstSeen:    info.FirstSeen,
+		LastSeen:     info.LastSeen,
+		CpuSeconds:   info.CpuTime,
+		PeakMemoryKB: info.PeakMemoryKB,
+		IO:           toJSONIO(info.IO),
+		Exe:          info.Exe,
+		Cwd:          info.Cwd,
+		ExitSignal:   info.ExitSignal,
+	}
+	if info.Exited {
+		exitCode := info.ExitCode
+		jp.ExitCode = &exitCode
+	}
+	for _, child := range node.Children {
+		jp.Children = append(jp.Children, toJSONProcess(child))
+	}
+	return jp
+}
+
+// WriteJSONReport writes the execution report as JSON to path
+func WriteJSONReport(path string, config *cli.Config, finalStats *monitor.Stats) error {
+	data, err := json.MarshalIndent(BuildJSONReport(config, finalStats), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write report file: %v", err)
+	}
+	return nil
+}
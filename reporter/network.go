@@ -0,0 +1,64 @@
+package reporter
+
+import (
+	"fmt"
+	"kernelscope/netaudit"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxConnections is how many sockets the report lists
+const maxConnections = 20
+
+// printNetwork prints the sockets the tree held and the traffic of its network namespace
+func printNetwork(activity *netaudit.Activity, start time.Time) {
+	// Sockets are only seen when a sample finds them open
+	if len(activity.Connections) == 0 {
+		fmt.Println("Network: no sockets seen in samples, short-lived ones may be missed")
+	} else {
+		fmt.Printf("Network: at least %d sockets seen in samples (%s)\n", len(activity.Connections), countProtocols(activity.Connections))
+	}
+	if traffic := activity.Traffic; traffic != nil {
+		fmt.Printf("Network Traffic: %d bytes received (%d packets), %d bytes sent (%d packets)\n",
+			traffic.RxBytes, traffic.RxPackets, traffic.TxBytes, traffic.TxPackets)
+		fmt.Println("  Counted on the run's own network namespace only; loopback packets count as both received and sent")
+	} else {
+		fmt.Println("Network Traffic: not counted, the run shares the host's network namespace")
+	}
+
+	for i, conn := range activity.Connections {
+		if i == maxConnections {
+			fmt.Printf("  ... %d more\n", len(activity.Connections)-maxConnections)
+			break
+		}
+		address := conn.Local
+		if address == "" {
+			address = "(unnamed)"
+		}
+		if conn.Remote != "" {
+			address += " -> " + conn.Remote
+		}
+		fmt.Printf("  %-5s %-11s %s [PID %d (%s) at %.1fs]\n", conn.Protocol, conn.State, address,
+			conn.Pid, conn.Comm, conn.FirstSeen.Sub(start).Seconds())
+	}
+}
+
+// countProtocols summarises connections by protocol, e.g. "2 tcp, 1 unix"
+func countProtocols(connections []*netaudit.Connection) string {
+	counts := make(map[string]int)
+	for _, conn := range connections {
+		counts[conn.Protocol]++
+	}
+	protocols := make([]string, 0, len(counts))
+	for protocol := range counts {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+
+	parts := make([]string, len(protocols))
+	for i, protocol := range protocols {
+		parts[i] = fmt.Sprintf("%d %s", counts[protocol], protocol)
+	}
+	return strings.Join(parts, ", ")
+}
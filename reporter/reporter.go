@@ -3,6 +3,8 @@ package reporter
 import (
 	"fmt"
 	"kernelscope/monitor"
+	"kernelscope/timeseries"
+	"kernelscope/workspace"
 	"time"
 )
 
@@ -13,7 +15,37 @@ func GenerateReport(stats *monitor.Stats, finalStats *monitor.Stats) {
 	fmt.Println("\n=========== KernelScope Execution Report ===========")
 	fmt.Printf("Execution Duration: %v\n", duration.Round(time.Millisecond))
 	fmt.Printf("CPU Time Used: %.2f seconds\n", finalStats.CpuTimeUsed)
+	if finalStats.CpuRateControl == "SIGSTOP/SIGCONT" {
+		fmt.Printf("CPU Rate Limit: held with SIGSTOP/SIGCONT, tree stopped for %v\n", finalStats.ThrottledTime.Round(time.Millisecond))
+	} else if finalStats.CpuRateControl != "" {
+		fmt.Printf("CPU Rate Limit: held with %s, tree throttled for %v\n", finalStats.CpuRateControl, finalStats.ThrottledTime.Round(time.Millisecond))
+	}
 	fmt.Printf("Peak Memory Usage: %d KB\n", finalStats.MaxMemoryKB)
+	fmt.Printf("Peak Threads: %d | Peak Open FDs: %d | Peak Descendant Processes: %d\n",
+		finalStats.PeakThreads, finalStats.PeakFDs, finalStats.PeakProcesses)
+	fmt.Printf("Storage I/O: %d bytes read (%d syscalls), %d bytes written (%d syscalls)\n",
+		finalStats.IO.ReadBytes, finalStats.IO.ReadSyscalls, finalStats.IO.WriteBytes, finalStats.IO.WriteSyscalls)
+	if finalStats.Syscalls != nil {
+		printSyscalls(finalStats.Syscalls)
+	}
+	if finalStats.Identity != nil {
+		fmt.Printf("Ran As: %s\n", finalStats.Identity)
+	}
+	if finalStats.Workspace != "" {
+		fmt.Printf("Workspace: %s\n", finalStats.Workspace)
+	}
+	if len(finalStats.Artifacts) > 0 {
+		fmt.Printf("Artifacts: %d files in %s\n", len(finalStats.Artifacts), finalStats.ArtifactsDir)
+		for _, artifact := range finalStats.Artifacts {
+			fmt.Printf("  %s (%d bytes)\n", artifact.Path, artifact.Size)
+		}
+	}
+	if len(finalStats.FileChanges) > 0 {
+		reportFileChanges(finalStats.FileChanges)
+	}
+	if finalStats.Network != nil {
+		printNetwork(finalStats.Network, finalStats.StartTime)
+	}
 
 	if finalStats.TermReason != "" {
 		fmt.Printf("Termination Reason: %s\n", finalStats.TermReason)
@@ -25,6 +57,9 @@ func GenerateReport(stats *monitor.Stats, finalStats *monitor.Stats) {
 
 	fmt.Printf("Loop Iterations: %d\n", finalStats.LoopCount)
 	fmt.Printf("Successful Iterations: %d\n", finalStats.SuccessCount)
+	if finalStats.SuccessReason != "" {
+		fmt.Printf("Success Criteria: %s\n", finalStats.SuccessReason)
+	}
 
 	// Calculate efficiency
 	if finalStats.LoopCount > 0 {
@@ -38,6 +73,31 @@ func GenerateReport(stats *monitor.Stats, finalStats *monitor.Stats) {
 		fmt.Printf("CPU Efficiency: %.1f%%\n", cpuEfficiency)
 	}
 
+	// Show which processes consumed the resources
+	printProcessTree(finalStats.Processes, finalStats.StartTime, finalStats.ProcessEvents)
+	printTopCommands(finalStats.Processes)
+
+	// Report sampling cost
+	if finalStats.SampleCount > 0 {
+		fmt.Printf("Samples Taken: %d\n", finalStats.SampleCount)
+		fmt.Printf("Sampling Overhead: %v (%v per sample)\n",
+			finalStats.SamplingOverhead.Round(time.Microsecond),
+			(finalStats.SamplingOverhead / time.Duration(finalStats.SampleCount)).Round(time.Microsecond))
+	}
+
+	// Report time series statistics
+	if len(finalStats.Samples) > 1 {
+		summary := timeseries.Summarize(finalStats.Samples)
+		fmt.Printf("Average CPU Utilisation: %.1f%% (peak %.1f%%)\n", summary.AvgCpuPercent, summary.PeakCpuPercent)
+		fmt.Printf("Average Memory Usage: %.0f KB\n", summary.AvgMemoryKB)
+		fmt.Printf("Memory Growth Rate: %.1f KB/s\n", summary.MemoryGrowthKBps)
+		fmt.Printf("Threads: %.1f average, %d peak\n", summary.AvgThreads, summary.PeakThreads)
+	}
+
+	// Report whether the processes were busy or blocked
+	printStates(finalStats)
+	printDiagnostics(finalStats.Diagnostics)
+
 	fmt.Println("===================================================")
 }
 
@@ -50,3 +110,33 @@ func ReportProgress(stats *monitor.Stats) {
 	fmt.Printf("\r[Running for %v] CPU: %.2fs | Memory: %d KB | Iterations: %d",
 		duration, stats.CpuTimeUsed, stats.MaxMemoryKB, stats.LoopCount)
 }
+
+// reportFileChanges prints the files the run created, modified and deleted
+func reportFileChanges(changes []workspace.Change) {
+	counts := make(map[string]int)
+	for _, change := range changes {
+		counts[change.Kind]++
+	}
+	fmt.Printf("Filesystem Changes: %d created, %d modified, %d deleted\n",
+		counts[workspace.Created], counts[workspace.Modified], counts[workspace.Deleted])
+
+	for _, change := range changes {
+		switch change.Kind {
+		case workspace.Created:
+			fmt.Printf("  created  %s (%d bytes, sha256 %s)\n", change.Path, change.Size, shortHash(change.Hash))
+		case workspace.Modified:
+			fmt.Printf("  modified %s (%d -> %d bytes, sha256 %s -> %s)\n", change.Path,
+				change.OldSize, change.Size, shortHash(change.OldHash), shortHash(change.Hash))
+		case workspace.Deleted:
+			fmt.Printf("  deleted  %s (%d bytes, sha256 %s)\n", change.Path, change.OldSize, shortHash(change.OldHash))
+		}
+	}
+}
+
+// shortHash abbreviates a hash for the text report, the JSON report has it in full
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
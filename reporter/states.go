@@ -0,0 +1,74 @@
+package reporter
+
+import (
+	"fmt"
+	"kernelscope/monitor"
+	"kernelscope/utils"
+	"sort"
+	"time"
+)
+
+// maxWaitChannels is how many wait channels the report lists
+const maxWaitChannels = 5
+
+// printStates prints how long the processes spent in each scheduler state and where they blocked
+func printStates(stats *monitor.Stats) {
+	var total time.Duration
+	for _, d := range stats.StateTime {
+		total += d
+	}
+	if total <= 0 {
+		return
+	}
+
+	fmt.Println("Process States (process-time):")
+	for _, state := range sortedByDuration(stats.StateTime) {
+		d := stats.StateTime[state]
+		fmt.Printf("  %-13s %10v  %5.1f%%\n", utils.StateName(state), d.Round(time.Millisecond), float64(d)/float64(total)*100)
+	}
+
+	channels := sortedByDuration(stats.WaitTime)
+	if len(channels) > maxWaitChannels {
+		channels = channels[:maxWaitChannels]
+	}
+	if len(channels) > 0 {
+		fmt.Println("Top Wait Channels:")
+		for _, wchan := range channels {
+			fmt.Printf("  %-30s %10v\n", wchan, stats.WaitTime[wchan].Round(time.Millisecond))
+		}
+	}
+}
+
+// printDiagnostics prints the state of every process captured when a timeout fired
+func printDiagnostics(diagnostics []monitor.ProcessDiagnostic) {
+	if len(diagnostics) == 0 {
+		return
+	}
+
+	fmt.Println("Process States At Timeout:")
+	for _, d := range diagnostics {
+		wchan := d.Wchan
+		if wchan == "" {
+			wchan = "-"
+		}
+		fmt.Printf("  PID %d (%s): %s, waiting in %s\n", d.Pid, d.Comm, utils.StateName(d.State), wchan)
+		for _, frame := range d.Stack {
+			fmt.Printf("      %s\n", frame)
+		}
+	}
+}
+
+// sortedByDuration returns the keys of a duration map, longest first
+func sortedByDuration(durations map[string]time.Duration) []string {
+	keys := make([]string, 0, len(durations))
+	for key := range durations {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if durations[keys[i]] != durations[keys[j]] {
+			return durations[keys[i]] > durations[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
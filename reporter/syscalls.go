@@ -0,0 +1,43 @@
+package reporter
+
+import (
+	"fmt"
+	"kernelscope/executor"
+	"time"
+)
+
+// maxSyscalls is how many system calls the report lists, overall and per process
+const maxSyscalls = 10
+
+// printSyscalls prints the system calls counted with --trace-syscalls, like strace -c
+func printSyscalls(summary *executor.SyscallSummary) {
+	calls, errors, elapsed := executor.Totals(summary.Syscalls)
+	fmt.Printf("System Calls: %d calls, %d errors, %v inside calls, across %d processes\n",
+		calls, errors, elapsed.Round(time.Microsecond), len(summary.Processes))
+	if calls == 0 {
+		return
+	}
+
+	fmt.Printf("  %-20s %10s %8s %12s %6s\n", "syscall", "calls", "errors", "time", "%time")
+	for i, stat := range summary.Syscalls {
+		if i == maxSyscalls {
+			fmt.Printf("  ... %d more\n", len(summary.Syscalls)-maxSyscalls)
+			break
+		}
+		share := 0.0
+		if elapsed > 0 {
+			share = float64(stat.Time) / float64(elapsed) * 100
+		}
+		fmt.Printf("  %-20s %10d %8d %12v %5.1f%%\n", stat.Name, stat.Calls, stat.Errors, stat.Time.Round(time.Microsecond), share)
+	}
+
+	if len(summary.Processes) < 2 {
+		return
+	}
+	fmt.Println("System Calls By Process:")
+	for _, process := range summary.Processes {
+		calls, errors, elapsed := executor.Totals(process.Syscalls)
+		fmt.Printf("  PID %d (%s): %d calls, %d errors, %v, mostly %s\n", process.Pid, process.Comm,
+			calls, errors, elapsed.Round(time.Microsecond), process.Syscalls[0].Name)
+	}
+}
@@ -0,0 +1,119 @@
+package reporter
+
+import (
+	"fmt"
+	"kernelscope/monitor"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProcessNode is a process in the monitored tree together with its children
+type ProcessNode struct {
+	Info     *monitor.ProcessInfo
+	Children []*ProcessNode
+}
+
+// BuildProcessTree arranges the processes seen during monitoring into a forest.
+// Processes whose parent was never observed become roots. When a PID was
+// reused, children attach to the most recent process with that PID.
+func BuildProcessTree(processes []*monitor.ProcessInfo) []*ProcessNode {
+	nodes := make([]*ProcessNode, len(processes))
+	byPid := make(map[int]*ProcessNode, len(processes))
+	for i, info := range processes {
+		nodes[i] = &ProcessNode{Info: info}
+		byPid[info.Pid] = nodes[i]
+	}
+
+	var roots []*ProcessNode
+	for i, info := range processes {
+		node := nodes[i]
+		if parent, ok := byPid[info.PPid]; ok && parent != node {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// maxTopCommands is how many commands the report ranks by CPU time
+const maxTopCommands = 5
+
+// printProcessTree prints the process tree with per-process usage
+func printProcessTree(processes []*monitor.ProcessInfo, start time.Time, source string) {
+	roots := BuildProcessTree(processes)
+	if len(roots) == 0 {
+		return
+	}
+
+	if source != "" {
+		fmt.Printf("Process Tree (%d processes, events from %s):\n", len(processes), source)
+	} else {
+		fmt.Printf("Process Tree (%d processes, sampled):\n", len(processes))
+	}
+	for i, root := range roots {
+		printProcessNode(root, "", i == len(roots)-1, start)
+	}
+}
+
+// printProcessNode prints a node and recurses into its children
+func printProcessNode(node *ProcessNode, prefix string, last bool, start time.Time) {
+	branch, indent := "├─ ", "│  "
+	if last {
+		branch, indent = "└─ ", "   "
+	}
+
+	info := node.Info
+	fmt.Printf("%s%s%d %s [CPU: %.2fs | Peak: %d KB | Read: %d B | Written: %d B | Seen: %.1fs-%.1fs]",
+		prefix, branch, info.Pid, info.Comm, info.CpuTime, info.PeakMemoryKB,
+		info.IO.ReadBytes, info.IO.WriteBytes, info.FirstSeen.Sub(start).Seconds(), info.LastSeen.Sub(start).Seconds())
+	if info.Exited {
+		if info.ExitSignal != 0 {
+			fmt.Printf(" [Exit: signal %d]", info.ExitSignal)
+		} else {
+			fmt.Printf(" [Exit: %d]", info.ExitCode)
+		}
+	}
+	if len(info.Cmdline) > 0 {
+		fmt.Printf(" %s", strings.Join(info.Cmdline, " "))
+	}
+	fmt.Println()
+
+	for i, child := range node.Children {
+		printProcessNode(child, prefix+indent, i == len(node.Children)-1, start)
+	}
+}
+
+// printTopCommands ranks the processes by CPU time, showing which commands used the quota
+func printTopCommands(processes []*monitor.ProcessInfo) {
+	total := 0.0
+	for _, info := range processes {
+		total += info.CpuTime
+	}
+	if len(processes) < 2 || total <= 0 {
+		return
+	}
+	ranked := append([]*monitor.ProcessInfo(nil), processes...)
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].CpuTime > ranked[j].CpuTime })
+
+	fmt.Println("Top Commands By CPU:")
+	for i, info := range ranked {
+		if i == maxTopCommands || info.CpuTime <= 0 {
+			break
+		}
+		fmt.Printf("  %5.1f%% %.2fs PID %d %s\n", info.CpuTime/total*100, info.CpuTime, info.Pid, commandName(info))
+	}
+}
+
+// commandName describes what a process ran, preferring its full command line
+func commandName(info *monitor.ProcessInfo) string {
+	switch {
+	case len(info.Cmdline) > 0:
+		return strings.Join(info.Cmdline, " ")
+	case info.Exe != "":
+		return info.Exe
+	default:
+		return info.Comm
+	}
+}
@@ -0,0 +1,239 @@
+package resource
+
+import (
+	"fmt"
+	"kernelscope/utils"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// applyKernelLimits applies the thread, fd and process limits the kernel can enforce
+// directly. Anything that cannot be applied is left to the polling fallback in the monitor.
+// The process is held before exec meanwhile, so the binary starts within them.
+func (rm *ResourceManager) applyKernelLimits(pid int) {
+	// RLIMIT_NOFILE is per process: the tree as a whole is only held to
+	// --max-fds by the monitor's samples
+	if rm.Config.MaxFDs > 0 {
+		limit := &unix.Rlimit{Cur: uint64(rm.Config.MaxFDs), Max: uint64(rm.Config.MaxFDs)}
+		if err := unix.Prlimit(pid, unix.RLIMIT_NOFILE, limit, nil); err != nil {
+			fmt.Printf("Warning: Failed to set RLIMIT_NOFILE: %v\n", err)
+		} else {
+			fmt.Printf("Set RLIMIT_NOFILE to %d for each process of PID %d's tree\n", rm.Config.MaxFDs, pid)
+		}
+	}
+
+	// The pids controller counts every task, so pids.max caps threads and processes together
+	if limit := pidsLimit(rm.Config.MaxThreads, rm.Config.MaxProcs); limit > 0 {
+		path, err := rm.createPidsCgroup(pid, limit)
+		if err != nil {
+			fmt.Printf("Warning: pids.max not available, polling thread and process counts instead: %v\n", err)
+			rm.applyProcessRlimit(pid)
+		} else {
+			fmt.Printf("Set pids.max to %d for PID %d (%s)\n", limit, pid, path)
+		}
+	}
+
+	// A CPU quota caps the rate in the kernel; without one the monitor stops
+	// and continues the tree instead
+	if rm.Config.CpuRate > 0 {
+		path, err := rm.createCpuCgroup(pid, rm.Config.CpuRate)
+		if err != nil {
+			fmt.Printf("Warning: cpu.max not available, duty cycling the tree with SIGSTOP/SIGCONT instead: %v\n", err)
+		} else {
+			fmt.Printf("Set %s to %.2f cores for PID %d (%s)\n", rm.cpuRateControl, rm.Config.CpuRate, pid, path)
+		}
+	}
+}
+
+// pidsLimit returns the pids.max enforcing --max-threads and --max-procs, 0 for none.
+// The root counts towards pids.max but not towards --max-procs.
+func pidsLimit(maxThreads, maxProcs int) int {
+	limit := maxThreads
+	if maxProcs > 0 && (limit == 0 || maxProcs+1 < limit) {
+		limit = maxProcs + 1
+	}
+	return limit
+}
+
+// applyProcessRlimit falls back to RLIMIT_NPROC for --max-procs. It counts every
+// process of the real uid, so it is only meaningful when the child runs under a
+// different uid than KernelScope itself.
+func (rm *ResourceManager) applyProcessRlimit(pid int) {
+	if rm.Config.MaxProcs == 0 {
+		return
+	}
+	uid, err := processUid(pid)
+	if err != nil || uid == 0 || uid == os.Getuid() {
+		return
+	}
+	limit := &unix.Rlimit{Cur: uint64(rm.Config.MaxProcs + 1), Max: uint64(rm.Config.MaxProcs + 1)}
+	if err := unix.Prlimit(pid, unix.RLIMIT_NPROC, limit, nil); err != nil {
+		fmt.Printf("Warning: Failed to set RLIMIT_NPROC: %v\n", err)
+	} else {
+		fmt.Printf("Set RLIMIT_NPROC to %d for PID %d\n", rm.Config.MaxProcs+1, pid)
+	}
+}
+
+// releaseKernelLimits removes anything created by applyKernelLimits
+func (rm *ResourceManager) releaseKernelLimits() {
+	for _, path := range rm.cgroupPaths {
+		if err := os.Remove(path); err != nil {
+			fmt.Printf("Warning: Failed to remove cgroup %s: %v\n", path, err)
+		}
+	}
+	rm.cgroupPaths = nil
+	rm.cpuRateControl = ""
+	rm.cpuCgroup = ""
+}
+
+// controllerRoot finds where a cgroup controller is mounted, supporting both
+// the unified (v2) hierarchy and a separate v1 hierarchy
+func controllerRoot(controller string) (root string, unified bool, err error) {
+	controllers, err := os.ReadFile("/sys/fs/cgroup/cgroup.controllers")
+	if err == nil && strings.Contains(" "+strings.TrimSpace(string(controllers))+" ", " "+controller+" ") {
+		return "/sys/fs/cgroup", true, nil
+	}
+	if _, err := os.Stat(filepath.Join("/sys/fs/cgroup", controller)); err == nil {
+		return filepath.Join("/sys/fs/cgroup", controller), false, nil
+	}
+	return "", false, fmt.Errorf("%s cgroup controller not found", controller)
+}
+
+// createPidsCgroup creates a cgroup with the given pids.max and moves pid into it
+func (rm *ResourceManager) createPidsCgroup(pid int, max int) (string, error) {
+	root, _, err := controllerRoot("pids")
+	if err != nil {
+		return "", err
+	}
+	return rm.createCgroup(root, pid, cgroupSetting{"pids.max", strconv.Itoa(max)})
+}
+
+// cpuPeriodUs is the CFS period of the CPU quota, the kernel default
+const cpuPeriodUs = 100000
+
+// createCpuCgroup creates a cgroup whose CPU quota is rate cores and moves pid into it
+func (rm *ResourceManager) createCpuCgroup(pid int, rate float64) (string, error) {
+	root, unified, err := controllerRoot("cpu")
+	if err != nil {
+		return "", err
+	}
+
+	quota := cpuQuota(rate)
+	if unified {
+		path, err := rm.createCgroup(root, pid, cgroupSetting{"cpu.max", fmt.Sprintf("%d %d", quota, cpuPeriodUs)})
+		if err == nil {
+			rm.cpuRateControl, rm.cpuCgroup = "cpu.max", path
+		}
+		return path, err
+	}
+	path, err := rm.createCgroup(root, pid,
+		cgroupSetting{"cpu.cfs_period_us", strconv.Itoa(cpuPeriodUs)},
+		cgroupSetting{"cpu.cfs_quota_us", strconv.Itoa(quota)})
+	if err == nil {
+		rm.cpuRateControl, rm.cpuCgroup = "cpu.cfs_quota_us", path
+	}
+	return path, err
+}
+
+// CpuThrottledTime returns how long the CPU quota has held back the tree,
+// 0 when no quota holds it
+func (rm *ResourceManager) CpuThrottledTime() (time.Duration, error) {
+	if rm.cpuCgroup == "" {
+		return 0, nil
+	}
+	data, err := os.ReadFile(filepath.Join(rm.cpuCgroup, "cpu.stat"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cpu.stat: %v", err)
+	}
+	return throttledTime(string(data))
+}
+
+// throttledTime parses the throttled time out of cpu.stat, which is
+// throttled_usec in the unified hierarchy and throttled_time, in
+// nanoseconds, in the v1 cpu controller
+func throttledTime(stat string) (time.Duration, error) {
+	for _, line := range strings.Split(stat, "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok || (key != "throttled_usec" && key != "throttled_time") {
+			continue
+		}
+		number, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse %s: %v", key, err)
+		}
+		if key == "throttled_usec" {
+			return time.Duration(number) * time.Microsecond, nil
+		}
+		return time.Duration(number), nil
+	}
+	return 0, fmt.Errorf("no throttled time in cpu.stat")
+}
+
+// cpuQuota returns the CFS quota in microseconds per cpuPeriodUs holding the tree
+// to rate cores. The kernel rejects quotas below 1ms.
+func cpuQuota(rate float64) int {
+	return max(int(rate*cpuPeriodUs), 1000)
+}
+
+// cgroupSetting is a control file written when a cgroup is created
+type cgroupSetting struct {
+	file  string
+	value string
+}
+
+// createCgroup creates a cgroup for pid under root, applies the settings and
+// moves pid into it. In the unified hierarchy every controller shares one cgroup,
+// so one created earlier is reused.
+func (rm *ResourceManager) createCgroup(root string, pid int, settings ...cgroupSetting) (string, error) {
+	path := filepath.Join(root, fmt.Sprintf("kernelscope-%d", pid))
+	created := !slices.Contains(rm.cgroupPaths, path)
+	if created {
+		if err := os.Mkdir(path, 0755); err != nil {
+			return "", fmt.Errorf("failed to create cgroup: %v", err)
+		}
+	}
+	abandon := func() {
+		if created {
+			os.Remove(path)
+		}
+	}
+
+	for _, setting := range settings {
+		if err := os.WriteFile(filepath.Join(path, setting.file), []byte(setting.value), 0644); err != nil {
+			abandon()
+			return "", fmt.Errorf("failed to write %s: %v", setting.file, err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(path, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		abandon()
+		return "", fmt.Errorf("failed to move process into cgroup: %v", err)
+	}
+
+	// The tree may have forked before the limits were set; those children
+	// would otherwise stay outside the cgroup
+	children, _ := utils.GetAllChildProcesses(pid)
+	for _, child := range children {
+		os.WriteFile(filepath.Join(path, "cgroup.procs"), []byte(strconv.Itoa(child)), 0644)
+	}
+
+	if created {
+		rm.cgroupPaths = append(rm.cgroupPaths, path)
+	}
+	return path, nil
+}
+
+// processUid returns the real uid of a process
+func processUid(pid int) (int, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(filepath.Join("/proc", strconv.Itoa(pid)), &st); err != nil {
+		return 0, err
+	}
+	return int(st.Uid), nil
+}
@@ -0,0 +1,84 @@
+package resource
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPidsLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxThreads int
+		maxProcs   int
+		want       int
+	}{
+		{"no limits", 0, 0, 0},
+		{"threads only", 16, 0, 16},
+		{"processes only, plus the root", 0, 4, 5},
+		{"processes tighter", 16, 4, 5},
+		{"threads tighter", 3, 4, 3},
+		{"equal", 5, 4, 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := pidsLimit(test.maxThreads, test.maxProcs); got != test.want {
+				t.Errorf("pidsLimit(%d, %d) = %d, want %d", test.maxThreads, test.maxProcs, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCpuQuota(t *testing.T) {
+	tests := []struct {
+		rate float64
+		want int
+	}{
+		{1, 100000},
+		{0.5, 50000},
+		{2.5, 250000},
+		{0.001, 1000}, // The kernel minimum
+		{0.0001, 1000},
+	}
+
+	for _, test := range tests {
+		if got := cpuQuota(test.rate); got != test.want {
+			t.Errorf("cpuQuota(%v) = %d, want %d", test.rate, got, test.want)
+		}
+	}
+}
+
+func TestThrottledTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		stat    string
+		want    time.Duration
+		wantErr bool
+	}{
+		{
+			name: "unified hierarchy",
+			stat: "usage_usec 812345\nuser_usec 800000\nsystem_usec 12345\nnr_periods 20\nnr_throttled 18\nthrottled_usec 1500000\nnr_bursts 0\nburst_usec 0\n",
+			want: 1500 * time.Millisecond,
+		},
+		{
+			name: "v1 controller",
+			stat: "nr_periods 20\nnr_throttled 18\nthrottled_time 1500000000\nnr_bursts 0\nburst_time 0\n",
+			want: 1500 * time.Millisecond,
+		},
+		{
+			name: "never throttled",
+			stat: "nr_periods 0\nnr_throttled 0\nthrottled_time 0\n",
+		},
+		{name: "missing", stat: "usage_usec 812345\n", wantErr: true},
+		{name: "malformed", stat: "throttled_usec many\n", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := throttledTime(test.stat)
+			if (err != nil) != test.wantErr || got != test.want {
+				t.Errorf("throttledTime = %v, %v, want %v (error %v)", got, err, test.want, test.wantErr)
+			}
+		})
+	}
+}
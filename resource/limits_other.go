@@ -0,0 +1,16 @@
+//go:build !linux
+
+package resource
+
+import "time"
+
+// applyKernelLimits is a no-op outside Linux; the monitor polls instead
+func (rm *ResourceManager) applyKernelLimits(pid int) {}
+
+// releaseKernelLimits is a no-op outside Linux
+func (rm *ResourceManager) releaseKernelLimits() {}
+
+// CpuThrottledTime is always 0 outside Linux, where there is no CPU quota
+func (rm *ResourceManager) CpuThrottledTime() (time.Duration, error) {
+	return 0, nil
+}
@@ -9,7 +9,10 @@ import (
 
 // ResourceManager handles resource limitations
 type ResourceManager struct {
-	Config *cli.Config
+	Config         *cli.Config
+	cgroupPaths    []string // cgroups created to enforce pids.max and the CPU rate
+	cpuRateControl string   // Control file enforcing --cpu-rate, empty when the monitor has to throttle
+	cpuCgroup      string   // cgroup holding the CPU quota, empty without one
 }
 
 // NewResourceManager creates a new resource manager
@@ -39,9 +42,38 @@ func (rm *ResourceManager) SetProcessLimits(pid int) error {
 	// cmd := exec.Command("prlimit", "--pid", fmt.Sprintf("%d", pid), "--cpu="+fmt.Sprintf("%d", rm.Config.CpuLimit))
 	// return cmd.Run()
 
+	// Thread, fd and process limits are applied where the kernel supports them
+	rm.applyKernelLimits(pid)
+
 	return nil
 }
 
+// ReleaseProcessLimits cleans up anything created by SetProcessLimits
+func (rm *ResourceManager) ReleaseProcessLimits() {
+	rm.releaseKernelLimits()
+}
+
+// CpuRateControl returns the cgroup control file that caps the CPU rate,
+// or "" if the kernel does not enforce --cpu-rate
+func (rm *ResourceManager) CpuRateControl() string {
+	return rm.cpuRateControl
+}
+
+// IsThreadLimitExceeded checks if the tree runs more threads than allowed
+func (rm *ResourceManager) IsThreadLimitExceeded(threads int) bool {
+	return rm.Config.MaxThreads > 0 && threads > rm.Config.MaxThreads
+}
+
+// IsFDLimitExceeded checks if the tree holds more open file descriptors than allowed
+func (rm *ResourceManager) IsFDLimitExceeded(fds int) bool {
+	return rm.Config.MaxFDs > 0 && fds > rm.Config.MaxFDs
+}
+
+// IsProcessLimitExceeded checks if the tree has more descendant processes than allowed
+func (rm *ResourceManager) IsProcessLimitExceeded(descendants int) bool {
+	return rm.Config.MaxProcs > 0 && descendants > rm.Config.MaxProcs
+}
+
 // IsCpuQuotaExceeded checks if the CPU quota has been exceeded
 func (rm *ResourceManager) IsCpuQuotaExceeded(usedCpu float64) bool {
 	if rm.Config.PrePaidMode {
@@ -53,21 +85,64 @@ func (rm *ResourceManager) IsCpuQuotaExceeded(usedCpu float64) bool {
 	}
 }
 
+// ProcessUsage holds the resource usage of a single process in the tree
+type ProcessUsage struct {
+	Pid      int
+	PPid     int     // Parent process ID
+	Comm     string  // Command name
+	State    string  // Scheduler state letter from /proc/[pid]/stat
+	Wchan    string  // Kernel function the process is waiting in, "" when running
+	CpuTime  float64 // CPU time in seconds
+	MemoryKB uint64  // Resident memory in KB
+	Threads  int     // Number of threads
+	FDs      int     // Number of open file descriptors
+	IO       utils.ProcIO
+}
+
+// TreeUsage holds the resource usage of a process and all its descendants
+type TreeUsage struct {
+	CpuTime   float64        // Total CPU time in seconds
+	MemoryKB  uint64         // Total resident memory in KB
+	Threads   int            // Total number of threads
+	FDs       int            // Total number of open file descriptors
+	IO        utils.ProcIO   // I/O counters of the processes currently alive
+	Processes []ProcessUsage // Per-process breakdown, root first
+}
+
+// IsWriteLimitExceeded checks if the tree has written more than allowed
+func (rm *ResourceManager) IsWriteLimitExceeded(writeBytes uint64) bool {
+	return rm.Config.MaxWriteBytes > 0 && writeBytes > uint64(rm.Config.MaxWriteBytes)
+}
+
+// IsReadLimitExceeded checks if the tree has read more than allowed
+func (rm *ResourceManager) IsReadLimitExceeded(readBytes uint64) bool {
+	return rm.Config.MaxReadBytes > 0 && readBytes > uint64(rm.Config.MaxReadBytes)
+}
+
 // GetResourceUsage gets current resource usage information for a process and its children
 func (rm *ResourceManager) GetResourceUsage(pid int) (float64, uint64, error) {
+	usage, err := rm.GetTreeUsage(pid)
+	if err != nil {
+		return 0.0, 0, err
+	}
+	return usage.CpuTime, usage.MemoryKB, nil
+}
+
+// GetTreeUsage gets the resource usage of a process and its children, broken down per process
+func (rm *ResourceManager) GetTreeUsage(pid int) (*TreeUsage, error) {
+	usage := &TreeUsage{}
+
 	// If not on Linux, return placeholder values
 	if runtime.GOOS != "linux" {
-		return 0.0, 0, nil
+		return usage, nil
 	}
 
 	// Get stats for the main process
 	stats, err := utils.ReadProcStats(pid)
 	if err != nil {
-		return 0.0, 0, err
+		return nil, err
 	}
-
-	totalCpuTime := stats.CpuTime
-	totalMemoryKB := stats.MemoryKB
+	usage.add(pid, stats)
 
 	// Get all child processes
 	childPids, err := utils.GetAllChildProcesses(pid)
@@ -76,11 +151,40 @@ func (rm *ResourceManager) GetResourceUsage(pid int) (float64, uint64, error) {
 		for _, childPid := range childPids {
 			childStats, err := utils.ReadProcStats(childPid)
 			if err == nil {
-				totalCpuTime += childStats.CpuTime
-				totalMemoryKB += childStats.MemoryKB
+				usage.add(childPid, childStats)
 			}
 		}
 	}
 
-	return totalCpuTime, totalMemoryKB, nil
+	return usage, nil
+}
+
+// add accumulates a single process's stats into the tree usage
+func (u *TreeUsage) add(pid int, stats *utils.ProcStats) {
+	// I/O accounting may be unavailable, in which case it counts as zero
+	var io utils.ProcIO
+	if procIO, err := utils.ReadProcIO(pid); err == nil {
+		io = *procIO
+	}
+
+	// The fd directory is unreadable for processes we do not own
+	fds, _ := utils.CountOpenFDs(pid)
+
+	u.CpuTime += stats.CpuTime
+	u.MemoryKB += stats.MemoryKB
+	u.Threads += stats.Threads
+	u.FDs += fds
+	u.IO.Add(io)
+	u.Processes = append(u.Processes, ProcessUsage{
+		Pid:      pid,
+		PPid:     stats.PPid,
+		Comm:     stats.Comm,
+		State:    stats.State,
+		Wchan:    utils.ReadWchan(pid),
+		CpuTime:  stats.CpuTime,
+		MemoryKB: stats.MemoryKB,
+		Threads:  stats.Threads,
+		FDs:      fds,
+		IO:       io,
+	})
 }
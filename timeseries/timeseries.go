@@ -0,0 +1,221 @@
+package timeseries
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"kernelscope/monitor"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Summary holds aggregate statistics computed over a time series
+type Summary struct {
+	Samples          int     `json:"samples"`
+	DurationSeconds  float64 `json:"duration_seconds"`
+	AvgCpuPercent    float64 `json:"avg_cpu_percent"`  // Average CPU utilisation, 100% = one core
+	PeakCpuPercent   float64 `json:"peak_cpu_percent"` // Highest utilisation between two samples
+	AvgMemoryKB      float64 `json:"avg_memory_kb"`
+	PeakMemoryKB     uint64  `json:"peak_memory_kb"`
+	MemoryGrowthKBps float64 `json:"memory_growth_kb_per_second"` // Least-squares slope of RSS over time
+	AvgThreads       float64 `json:"avg_threads"`
+	PeakThreads      int     `json:"peak_threads"`
+}
+
+// Summarize computes summary statistics for a time series
+func Summarize(samples []monitor.Sample) Summary {
+	summary := Summary{Samples: len(samples)}
+	if len(samples) == 0 {
+		return summary
+	}
+
+	first := samples[0]
+	var sumMem, sumThreads, usedCpu float64
+	var sumT, sumTT, sumTM float64
+
+	for i, sample := range samples {
+		t := sample.Time.Sub(first.Time).Seconds()
+		mem := float64(sample.MemoryKB)
+
+		sumMem += mem
+		sumThreads += float64(sample.Threads)
+		sumT += t
+		sumTT += t * t
+		sumTM += t * mem
+
+		if sample.MemoryKB > summary.PeakMemoryKB {
+			summary.PeakMemoryKB = sample.MemoryKB
+		}
+		if sample.Threads > summary.PeakThreads {
+			summary.PeakThreads = sample.Threads
+		}
+
+		// Utilisation between consecutive samples. The CPU time only drops
+		// when a new loop iteration starts counting again from zero.
+		if i > 0 {
+			used := sample.CpuTime - samples[i-1].CpuTime
+			if used < 0 {
+				used = sample.CpuTime
+			}
+			usedCpu += used
+			wall := sample.Time.Sub(samples[i-1].Time).Seconds()
+			if wall > 0 && used/wall*100 > summary.PeakCpuPercent {
+				summary.PeakCpuPercent = used / wall * 100
+			}
+		}
+	}
+
+	n := float64(len(samples))
+	last := samples[len(samples)-1]
+	summary.DurationSeconds = last.Time.Sub(first.Time).Seconds()
+	summary.AvgMemoryKB = sumMem / n
+	summary.AvgThreads = sumThreads / n
+
+	if summary.DurationSeconds > 0 {
+		summary.AvgCpuPercent = usedCpu / summary.DurationSeconds * 100
+	}
+
+	// Slope of the least-squares fit of memory over time
+	denominator := n*sumTT - sumT*sumT
+	if denominator > 0 {
+		summary.MemoryGrowthKBps = (n*sumTM - sumT*sumMem) / denominator
+	}
+
+	return summary
+}
+
+// Write writes the time series to path, choosing CSV or JSON from the file extension
+func Write(path string, samples []monitor.Sample) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return writeCSV(path, samples)
+	case ".json":
+		return writeJSON(path, samples)
+	default:
+		return fmt.Errorf("unsupported time series format %q (use .csv or .json)", filepath.Ext(path))
+	}
+}
+
+// writeCSV writes one row for the whole tree followed by one row per process for every sample
+func writeCSV(path string, samples []monitor.Sample) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create time series file: %v", err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"timestamp", "elapsed_seconds", "scope", "pid", "cpu_seconds", "rss_kb", "threads", "fds", "read_bytes", "write_bytes", "state", "wchan"})
+
+	for _, sample := range samples {
+		timestamp := sample.Time.Format(time.RFC3339Nano)
+		elapsed := formatFloat(sample.Time.Sub(samples[0].Time).Seconds())
+
+		w.Write([]string{timestamp, elapsed, "tree", "",
+			formatFloat(sample.CpuTime),
+			strconv.FormatUint(sample.MemoryKB, 10),
+			strconv.Itoa(sample.Threads),
+			strconv.Itoa(sample.FDs),
+			strconv.FormatUint(sample.IO.ReadBytes, 10),
+			strconv.FormatUint(sample.IO.WriteBytes, 10), "", ""})
+
+		for _, proc := range sample.Processes {
+			w.Write([]string{timestamp, elapsed, "process", strconv.Itoa(proc.Pid),
+				formatFloat(proc.CpuTime),
+				strconv.FormatUint(proc.MemoryKB, 10),
+				strconv.Itoa(proc.Threads),
+				strconv.Itoa(proc.FDs),
+				strconv.FormatUint(proc.IO.ReadBytes, 10),
+				strconv.FormatUint(proc.IO.WriteBytes, 10),
+				proc.State,
+				proc.Wchan})
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write time series: %v", err)
+	}
+	return nil
+}
+
+// jsonProcess is the JSON form of a per-process sample
+type jsonProcess struct {
+	Pid        int     `json:"pid"`
+	CpuSeconds float64 `json:"cpu_seconds"`
+	RssKB      uint64  `json:"rss_kb"`
+	Threads    int     `json:"threads"`
+	FDs        int     `json:"fds"`
+	ReadBytes  uint64  `json:"read_bytes"`
+	WriteBytes uint64  `json:"write_bytes"`
+	State      string  `json:"state"`
+	Wchan      string  `json:"wchan,omitempty"`
+}
+
+// jsonSample is the JSON form of a sample
+type jsonSample struct {
+	Timestamp      time.Time     `json:"timestamp"`
+	ElapsedSeconds float64       `json:"elapsed_seconds"`
+	CpuSeconds     float64       `json:"cpu_seconds"`
+	RssKB          uint64        `json:"rss_kb"`
+	Threads        int           `json:"threads"`
+	FDs            int           `json:"fds"`
+	ReadBytes      uint64        `json:"read_bytes"`
+	WriteBytes     uint64        `json:"write_bytes"`
+	Processes      []jsonProcess `json:"processes"`
+}
+
+// writeJSON writes the samples together with their summary as a single JSON document
+func writeJSON(path string, samples []monitor.Sample) error {
+	doc := struct {
+		Summary Summary      `json:"summary"`
+		Samples []jsonSample `json:"samples"`
+	}{
+		Summary: Summarize(samples),
+		Samples: make([]jsonSample, 0, len(samples)),
+	}
+
+	for _, sample := range samples {
+		js := jsonSample{
+			Timestamp:      sample.Time,
+			ElapsedSeconds: sample.Time.Sub(samples[0].Time).Seconds(),
+			CpuSeconds:     sample.CpuTime,
+			RssKB:          sample.MemoryKB,
+			Threads:        sample.Threads,
+			FDs:            sample.FDs,
+			ReadBytes:      sample.IO.ReadBytes,
+			WriteBytes:     sample.IO.WriteBytes,
+		}
+		for _, proc := range sample.Processes {
+			js.Processes = append(js.Processes, jsonProcess{
+				Pid:        proc.Pid,
+				CpuSeconds: proc.CpuTime,
+				RssKB:      proc.MemoryKB,
+				Threads:    proc.Threads,
+				FDs:        proc.FDs,
+				ReadBytes:  proc.IO.ReadBytes,
+				WriteBytes: proc.IO.WriteBytes,
+				State:      proc.State,
+				Wchan:      proc.Wchan,
+			})
+		}
+		doc.Samples = append(doc.Samples, js)
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode time series: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write time series file: %v", err)
+	}
+	return nil
+}
+
+// formatFloat formats a float without trailing zeros
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
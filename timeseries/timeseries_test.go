@@ -0,0 +1,154 @@
+package timeseries
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"kernelscope/monitor"
+	"kernelscope/resource"
+	"kernelscope/utils"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// series builds samples one second apart with the given CPU and memory readings
+func series(cpu []float64, memoryKB []uint64) []monitor.Sample {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	samples := make([]monitor.Sample, len(cpu))
+	for i := range cpu {
+		samples[i] = monitor.Sample{Time: start.Add(time.Duration(i) * time.Second), CpuTime: cpu[i], MemoryKB: memoryKB[i], Threads: i + 1}
+	}
+	return samples
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []monitor.Sample
+		want    Summary
+	}{
+		{name: "no samples", want: Summary{}},
+		{
+			name:    "single sample",
+			samples: series([]float64{0.5}, []uint64{100}),
+			want:    Summary{Samples: 1, AvgMemoryKB: 100, PeakMemoryKB: 100, AvgThreads: 1, PeakThreads: 1},
+		},
+		{
+			name:    "steady use",
+			samples: series([]float64{0, 0.5, 1, 1.5}, []uint64{100, 100, 100, 100}),
+			want:    Summary{Samples: 4, DurationSeconds: 3, AvgCpuPercent: 50, PeakCpuPercent: 50, AvgMemoryKB: 100, PeakMemoryKB: 100, AvgThreads: 2.5, PeakThreads: 4},
+		},
+		{
+			name:    "burst and growing memory",
+			samples: series([]float64{0, 0.2, 1.8, 2}, []uint64{100, 200, 300, 400}),
+			want:    Summary{Samples: 4, DurationSeconds: 3, AvgCpuPercent: 200.0 / 3, PeakCpuPercent: 160, AvgMemoryKB: 250, PeakMemoryKB: 400, MemoryGrowthKBps: 100, AvgThreads: 2.5, PeakThreads: 4},
+		},
+		{
+			// The CPU time of a loop's next iteration counts from zero again
+			name:    "loop iterations",
+			samples: series([]float64{0, 1, 0.5, 1.5}, []uint64{100, 100, 100, 100}),
+			want:    Summary{Samples: 4, DurationSeconds: 3, AvgCpuPercent: 250.0 / 3, PeakCpuPercent: 100, AvgMemoryKB: 100, PeakMemoryKB: 100, AvgThreads: 2.5, PeakThreads: 4},
+		},
+	}
+
+	// Floats are compared to a tolerance, everything else exactly
+	round := func(summary Summary) Summary {
+		for _, value := range []*float64{&summary.DurationSeconds, &summary.AvgCpuPercent, &summary.PeakCpuPercent, &summary.AvgMemoryKB, &summary.MemoryGrowthKBps, &summary.AvgThreads} {
+			*value = math.Round(*value*1e6) / 1e6
+		}
+		return summary
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Summarize(test.samples); round(got) != round(test.want) {
+				t.Errorf("Summarize = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// writerSamples are two samples of a tree with one process
+func writerSamples() []monitor.Sample {
+	samples := series([]float64{0.25, 1.5}, []uint64{1024, 2048})
+	for i := range samples {
+		samples[i].FDs = 3
+		samples[i].IO = utils.ProcIO{ReadBytes: uint64(10 * (i + 1)), WriteBytes: uint64(20 * (i + 1))}
+		samples[i].Processes = []resource.ProcessUsage{
+			{Pid: 42, CpuTime: samples[i].CpuTime, MemoryKB: samples[i].MemoryKB, Threads: 1, FDs: 3, IO: samples[i].IO, State: "R", Wchan: "0"},
+		}
+	}
+	return samples
+}
+
+func TestWriteCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "series.csv")
+	if err := Write(path, writerSamples()); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"timestamp", "elapsed_seconds", "scope", "pid", "cpu_seconds", "rss_kb", "threads", "fds", "read_bytes", "write_bytes", "state", "wchan"},
+		{"2024-01-01T12:00:00Z", "0", "tree", "", "0.25", "1024", "1", "3", "10", "20", "", ""},
+		{"2024-01-01T12:00:00Z", "0", "process", "42", "0.25", "1024", "1", "3", "10", "20", "R", "0"},
+		{"2024-01-01T12:00:01Z", "1", "tree", "", "1.5", "2048", "2", "3", "20", "40", "", ""},
+		{"2024-01-01T12:00:01Z", "1", "process", "42", "1.5", "2048", "1", "3", "20", "40", "R", "0"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	samples := writerSamples()
+	path := filepath.Join(t.TempDir(), "series.json")
+	if err := Write(path, samples); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Summary Summary      `json:"summary"`
+		Samples []jsonSample `json:"samples"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if want := Summarize(samples); doc.Summary != want {
+		t.Errorf("summary = %+v, want %+v", doc.Summary, want)
+	}
+	want := []jsonSample{
+		{Timestamp: samples[0].Time, ElapsedSeconds: 0, CpuSeconds: 0.25, RssKB: 1024, Threads: 1, FDs: 3, ReadBytes: 10, WriteBytes: 20,
+			Processes: []jsonProcess{{Pid: 42, CpuSeconds: 0.25, RssKB: 1024, Threads: 1, FDs: 3, ReadBytes: 10, WriteBytes: 20, State: "R", Wchan: "0"}}},
+		{Timestamp: samples[1].Time, ElapsedSeconds: 1, CpuSeconds: 1.5, RssKB: 2048, Threads: 2, FDs: 3, ReadBytes: 20, WriteBytes: 40,
+			Processes: []jsonProcess{{Pid: 42, CpuSeconds: 1.5, RssKB: 2048, Threads: 1, FDs: 3, ReadBytes: 20, WriteBytes: 40, State: "R", Wchan: "0"}}},
+	}
+	if !reflect.DeepEqual(doc.Samples, want) {
+		t.Errorf("samples = %+v, want %+v", doc.Samples, want)
+	}
+}
+
+func TestWriteUnsupported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "series.txt")
+	if err := Write(path, writerSamples()); err == nil {
+		t.Errorf("wrote a time series as %s", path)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("created %s", path)
+	}
+}
@@ -1,126 +1,344 @@
-package utils
-
-import (
-	"bufio"
-	"fmt"
-	"os"
-	"path/filepath"
-	"strconv"
-	"strings"
-)
-
-// ProcStats holds stats read from /proc filesystem
-type ProcStats struct {
-	CpuTime   float64 // CPU time in seconds
-	MemoryKB  uint64  // Memory usage in KB
-	Children  []int   // Child process IDs
-}
-
-// ReadProcStats reads stats for a process from /proc filesystem
-func ReadProcStats(pid int) (*ProcStats, error) {
-	stats := &ProcStats{}
-	
-	// Check if process exists
-	procPath := filepath.Join("/proc", strconv.Itoa(pid))
-	_, err := os.Stat(procPath)
-	if err != nil {
-		return nil, fmt.Errorf("process %d does not exist: %v", pid, err)
-	}
-	
-	// Read CPU stats from /proc/[pid]/stat
-	statFile := filepath.Join(procPath, "stat")
-	statBytes, err := os.ReadFile(statFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read stat file: %v", err)
-	}
-	
-	statFields := strings.Fields(string(statBytes))
-	if len(statFields) < 17 {
-		return nil, fmt.Errorf("invalid stat file format")
-	}
-	
-	// Extract CPU time (user + system time)
-	// Fields 14 and 15 are utime and stime in clock ticks
-	utime, err := strconv.ParseUint(statFields[13], 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse utime: %v", err)
-	}
-	
-	stime, err := strconv.ParseUint(statFields[14], 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse stime: %v", err)
-	}
-	
-	// Convert from clock ticks to seconds
-	// In Linux, the number of clock ticks per second is typically defined by sysconf(_SC_CLK_TCK)
-	// Most common value is 100, but we should read it from the system in a real implementation
-	const clockTicksPerSecond = 100
-	stats.CpuTime = float64(utime+stime) / float64(clockTicksPerSecond)
-	
-	// Read memory stats from /proc/[pid]/status
-	statusFile := filepath.Join(procPath, "status")
-	file, err := os.Open(statusFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read status file: %v", err)
-	}
-	defer file.Close()
-	
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		
-		// Look for VmRSS line which gives the physical memory usage
-		if strings.HasPrefix(line, "VmRSS:") {
-			fields := strings.Fields(line)
-			if len(fields) >= 2 {
-				memKB, err := strconv.ParseUint(fields[1], 10, 64)
-				if err == nil {
-					stats.MemoryKB = memKB
-				}
-			}
-		}
-	}
-	
-	// Read child processes from /proc/[pid]/task/[pid]/children
-	childrenFile := filepath.Join(procPath, "task", strconv.Itoa(pid), "children")
-	childrenBytes, err := os.ReadFile(childrenFile)
-	if err == nil { // It's okay if this fails, not all systems support it
-		childrenStr := strings.TrimSpace(string(childrenBytes))
-		if childrenStr != "" {
-			childrenFields := strings.Fields(childrenStr)
-			for _, child := range childrenFields {
-				childPid, err := strconv.Atoi(child)
-				if err == nil {
-					stats.Children = append(stats.Children, childPid)
-				}
-			}
-		}
-	}
-	
-	return stats, nil
-}
-
-// GetAllChildProcesses recursively gets all child processes
-func GetAllChildProcesses(pid int) ([]int, error) {
-	var allChildren []int
-	
-	// First get direct children
-	stats, err := ReadProcStats(pid)
-	if err != nil {
-		return nil, err
-	}
-	
-	// Add direct children to the list
-	allChildren = append(allChildren, stats.Children...)
-	
-	// Recursively get children of children
-	for _, childPid := range stats.Children {
-		grandchildren, err := GetAllChildProcesses(childPid)
-		if err != nil {
-			continue // It's ok if a child process disappeared
-		}
-		allChildren = append(allChildren, grandchildren...)
-	}
-	
-	return allChildren, nil
-} 
\ No newline at end of file
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ProcStats holds stats read from /proc filesystem
+type ProcStats struct {
+	Comm     string  // Command name from /proc/[pid]/stat
+	State    string  // Scheduler state: R, S, D, Z, T, ...
+	PPid     int     // Parent process ID
+	CpuTime  float64 // CPU time in seconds
+	MemoryKB uint64  // Memory usage in KB
+	Threads  int     // Number of threads
+	Children []int   // Child process IDs
+
+	ReapedCpuTime float64 // CPU time in seconds of the children the process waited for
+}
+
+// ReadProcStats reads stats for a process from /proc filesystem
+func ReadProcStats(pid int) (*ProcStats, error) {
+	stats := &ProcStats{}
+
+	// Check if process exists
+	procPath := filepath.Join("/proc", strconv.Itoa(pid))
+	_, err := os.Stat(procPath)
+	if err != nil {
+		return nil, fmt.Errorf("process %d does not exist: %v", pid, err)
+	}
+
+	// Read CPU stats from /proc/[pid]/stat
+	statFile := filepath.Join(procPath, "stat")
+	statBytes, err := os.ReadFile(statFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stat file: %v", err)
+	}
+
+	// The command name is wrapped in parentheses and may itself contain
+	// spaces or parentheses, so split the remaining fields after the last ')'
+	statStr := string(statBytes)
+	commStart := strings.IndexByte(statStr, '(')
+	commEnd := strings.LastIndexByte(statStr, ')')
+	if commStart < 0 || commEnd < commStart {
+		return nil, fmt.Errorf("invalid stat file format")
+	}
+	stats.Comm = statStr[commStart+1 : commEnd]
+
+	// statFields[0] is field 3 (state), so field N is at index N-3
+	statFields := strings.Fields(statStr[commEnd+1:])
+	if len(statFields) < 15 {
+		return nil, fmt.Errorf("invalid stat file format")
+	}
+
+	stats.State = statFields[0]
+
+	stats.PPid, err = strconv.Atoi(statFields[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ppid: %v", err)
+	}
+
+	// Extract CPU time (user + system time)
+	// Fields 14 and 15 are utime and stime in clock ticks
+	utime, err := strconv.ParseUint(statFields[11], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse utime: %v", err)
+	}
+
+	stime, err := strconv.ParseUint(statFields[12], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse stime: %v", err)
+	}
+
+	// Convert from clock ticks to seconds
+	// In Linux, the number of clock ticks per second is typically defined by sysconf(_SC_CLK_TCK)
+	// Most common value is 100, but we should read it from the system in a real implementation
+	const clockTicksPerSecond = 100
+	stats.CpuTime = float64(utime+stime) / float64(clockTicksPerSecond)
+
+	// Fields 16 and 17 are cutime and cstime, which include the children
+	// those children waited for in turn
+	cutime, err := strconv.ParseUint(statFields[13], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cutime: %v", err)
+	}
+
+	cstime, err := strconv.ParseUint(statFields[14], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cstime: %v", err)
+	}
+	stats.ReapedCpuTime = float64(cutime+cstime) / float64(clockTicksPerSecond)
+
+	// Read memory stats from /proc/[pid]/status
+	statusFile := filepath.Join(procPath, "status")
+	file, err := os.Open(statusFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Look for VmRSS line which gives the physical memory usage
+		if strings.HasPrefix(line, "VmRSS:") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 {
+				memKB, err := strconv.ParseUint(fields[1], 10, 64)
+				if err == nil {
+					stats.MemoryKB = memKB
+				}
+			}
+		}
+
+		// Look for Threads line which gives the thread count
+		if strings.HasPrefix(line, "Threads:") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 {
+				threads, err := strconv.Atoi(fields[1])
+				if err == nil {
+					stats.Threads = threads
+				}
+			}
+		}
+	}
+
+	// Read child processes from /proc/[pid]/task/[pid]/children
+	childrenFile := filepath.Join(procPath, "task", strconv.Itoa(pid), "children")
+	childrenBytes, err := os.ReadFile(childrenFile)
+	if err == nil { // It's okay if this fails, not all systems support it
+		childrenStr := strings.TrimSpace(string(childrenBytes))
+		if childrenStr != "" {
+			childrenFields := strings.Fields(childrenStr)
+			for _, child := range childrenFields {
+				childPid, err := strconv.Atoi(child)
+				if err == nil {
+					stats.Children = append(stats.Children, childPid)
+				}
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// GetAllChildProcesses recursively gets all child processes
+func GetAllChildProcesses(pid int) ([]int, error) {
+	var allChildren []int
+
+	// First get direct children
+	stats, err := ReadProcStats(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add direct children to the list
+	allChildren = append(allChildren, stats.Children...)
+
+	// Recursively get children of children
+	for _, childPid := range stats.Children {
+		grandchildren, err := GetAllChildProcesses(childPid)
+		if err != nil {
+			continue // It's ok if a child process disappeared
+		}
+		allChildren = append(allChildren, grandchildren...)
+	}
+
+	return allChildren, nil
+}
+
+// ReadExe reads the path of the program a process runs from /proc/[pid]/exe
+func ReadExe(pid int) (string, error) {
+	return os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "exe"))
+}
+
+// ReadCwd reads the working directory of a process from /proc/[pid]/cwd
+func ReadCwd(pid int) (string, error) {
+	return os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "cwd"))
+}
+
+// ReadPeakMemoryKB reads the peak resident memory of a process (VmHWM) from /proc/[pid]/status
+func ReadPeakMemoryKB(pid int) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "status"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read status file: %v", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "VmHWM:"); ok {
+			return strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+		}
+	}
+	// Zombies and kernel threads have no memory
+	return 0, fmt.Errorf("no VmHWM in status file")
+}
+
+// ReadTgid reads the thread group ID, i.e. the process ID, of a thread from /proc/[tid]/status
+func ReadTgid(tid int) (int, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(tid), "status"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read status file: %v", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "Tgid:"); ok {
+			return strconv.Atoi(strings.TrimSpace(value))
+		}
+	}
+	return 0, fmt.Errorf("no Tgid in status file")
+}
+
+// ReadCmdline reads the command line of a process from /proc/[pid]/cmdline
+func ReadCmdline(pid int) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cmdline file: %v", err)
+	}
+
+	// Arguments are NUL-separated with a trailing NUL
+	data = bytes.TrimRight(data, "\x00")
+	if len(data) == 0 {
+		// Kernel threads and zombies have an empty command line
+		return nil, nil
+	}
+	return strings.Split(string(data), "\x00"), nil
+}
+
+// stateNames maps the scheduler state letters of /proc/[pid]/stat to readable names
+var stateNames = map[string]string{
+	"R": "running",
+	"S": "sleeping",
+	"D": "disk wait",
+	"Z": "zombie",
+	"T": "stopped",
+	"t": "tracing stop",
+	"X": "dead",
+	"I": "idle",
+	"P": "parked",
+	"W": "paging",
+}
+
+// StateName returns a readable name for a scheduler state letter
+func StateName(state string) string {
+	if name, ok := stateNames[state]; ok {
+		return name
+	}
+	return state
+}
+
+// ReadWchan reads the kernel function a process is waiting in from /proc/[pid]/wchan.
+// It returns "" when the process is running or the file is unreadable.
+func ReadWchan(pid int) string {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "wchan"))
+	if err != nil {
+		return ""
+	}
+	wchan := strings.TrimSpace(string(data))
+	if wchan == "0" {
+		return ""
+	}
+	return wchan
+}
+
+// ReadKernelStack reads the kernel stack of a process from /proc/[pid]/stack, which usually requires root
+func ReadKernelStack(pid int) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stack"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stack file: %v", err)
+	}
+
+	var frames []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			frames = append(frames, line)
+		}
+	}
+	return frames, nil
+}
+
+// ProcIO holds I/O counters read from /proc/[pid]/io
+type ProcIO struct {
+	ReadBytes     uint64 // Bytes fetched from the storage layer
+	WriteBytes    uint64 // Bytes sent to the storage layer
+	ReadSyscalls  uint64 // Number of read-like syscalls
+	WriteSyscalls uint64 // Number of write-like syscalls
+}
+
+// Add accumulates another set of I/O counters
+func (io *ProcIO) Add(other ProcIO) {
+	io.ReadBytes += other.ReadBytes
+	io.WriteBytes += other.WriteBytes
+	io.ReadSyscalls += other.ReadSyscalls
+	io.WriteSyscalls += other.WriteSyscalls
+}
+
+// ReadProcIO reads the I/O counters of a process from /proc/[pid]/io
+func ReadProcIO(pid int) (*ProcIO, error) {
+	file, err := os.Open(filepath.Join("/proc", strconv.Itoa(pid), "io"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read io file: %v", err)
+	}
+	defer file.Close()
+
+	io := &ProcIO{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch fields[0] {
+		case "read_bytes:":
+			io.ReadBytes = value
+		case "write_bytes:":
+			io.WriteBytes = value
+		case "syscr:":
+			io.ReadSyscalls = value
+		case "syscw:":
+			io.WriteSyscalls = value
+		}
+	}
+
+	return io, nil
+}
+
+// CountOpenFDs counts the open file descriptors of a process from /proc/[pid]/fd
+func CountOpenFDs(pid int) (int, error) {
+	entries, err := os.ReadDir(filepath.Join("/proc", strconv.Itoa(pid), "fd"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read fd directory: %v", err)
+	}
+	return len(entries), nil
+}
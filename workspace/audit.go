@@ -0,0 +1,156 @@
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Kinds of filesystem change
+const (
+	Created  = "created"
+	Modified = "modified"
+	Deleted  = "deleted"
+)
+
+// Unreadable stands in for the hash of a file or directory the snapshot could not read
+const Unreadable = "unreadable"
+
+// FileState is what a snapshot records about a file
+type FileState struct {
+	Size int64
+	Hash string // SHA-256 of the contents, or of the target for a symlink, or Unreadable
+}
+
+// Snapshot records every file beneath a directory
+type Snapshot struct {
+	Root  string
+	Files map[string]FileState // By path relative to Root
+}
+
+// Change is a file created, modified or deleted between two snapshots
+type Change struct {
+	Path    string
+	Kind    string
+	Size    int64  // Size after the run, 0 if deleted
+	Hash    string // Hash after the run, empty if deleted
+	OldSize int64  // Size before the run, 0 if created
+	OldHash string // Hash before the run, empty if created
+}
+
+// TakeSnapshot hashes every regular file and symlink beneath root, which may
+// also be a single file. A missing or empty root gives an empty snapshot. Files
+// and directories beneath root that cannot be read are recorded as Unreadable.
+func TakeSnapshot(root string) (*Snapshot, error) {
+	snapshot := &Snapshot{Root: root, Files: make(map[string]FileState)}
+	if root == "" {
+		return snapshot, nil
+	}
+	info, err := os.Stat(root)
+	if os.IsNotExist(err) {
+		return snapshot, nil
+	}
+
+	// The trailing separator resolves roots that are themselves symlinks,
+	// such as the /proc/self/fd path of a sandbox tmpfs
+	start := root
+	if err == nil && info.IsDir() {
+		start = root + string(filepath.Separator)
+	}
+
+	err = filepath.WalkDir(start, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil && path == start {
+			return walkErr
+		}
+		rel, err := filepath.Rel(start, path)
+		if err != nil {
+			return err
+		}
+		if walkErr != nil {
+			snapshot.Files[rel] = FileState{Hash: Unreadable}
+			if entry != nil && entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		var state FileState
+		switch {
+		case entry.Type()&fs.ModeSymlink != 0:
+			if link, err := os.Readlink(path); err != nil {
+				state = FileState{Hash: Unreadable}
+			} else {
+				state = FileState{Size: int64(len(link)), Hash: hashBytes([]byte(link))}
+			}
+		case entry.Type().IsRegular():
+			if state, err = hashFile(path); err != nil {
+				state = FileState{Hash: Unreadable}
+				if info, err := entry.Info(); err == nil {
+					state.Size = info.Size()
+				}
+			}
+		default:
+			// Devices, sockets and pipes are skipped
+			return nil
+		}
+		snapshot.Files[rel] = state
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot %s: %v", root, err)
+	}
+	return snapshot, nil
+}
+
+// Diff lists the files that differ in after, sorted by path
+func (s *Snapshot) Diff(after *Snapshot) []Change {
+	var changes []Change
+	for path, now := range after.Files {
+		before, existed := s.Files[path]
+		switch {
+		case !existed:
+			changes = append(changes, Change{Path: path, Kind: Created, Size: now.Size, Hash: now.Hash})
+		case before != now:
+			changes = append(changes, Change{Path: path, Kind: Modified, Size: now.Size, Hash: now.Hash,
+				OldSize: before.Size, OldHash: before.Hash})
+		}
+	}
+	for path, before := range s.Files {
+		if _, exists := after.Files[path]; !exists {
+			changes = append(changes, Change{Path: path, Kind: Deleted, OldSize: before.Size, OldHash: before.Hash})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// hashFile reads a regular file and returns its size and SHA-256
+func hashFile(path string) (FileState, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FileState{}, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return FileState{}, err
+	}
+	return FileState{Size: size, Hash: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// hashBytes returns the SHA-256 of data
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
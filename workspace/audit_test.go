@@ -0,0 +1,163 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFiles creates files, by path relative to dir, with the given contents
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSnapshotDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		before map[string]string
+		change func(t *testing.T, dir string)
+		want   []string // Kind and path of each change
+	}{
+		{
+			name:   "unchanged",
+			before: map[string]string{"a": "1", "sub/b": "2"},
+			change: func(t *testing.T, dir string) {},
+		},
+		{
+			name:   "created, modified and deleted",
+			before: map[string]string{"keep": "k", "edit": "old", "gone": "g"},
+			change: func(t *testing.T, dir string) {
+				writeFiles(t, dir, map[string]string{"edit": "new", "out/new": "n"})
+				if err := os.Remove(filepath.Join(dir, "gone")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"modified edit", "deleted gone", "created out/new"},
+		},
+		{
+			name:   "same size, different contents",
+			before: map[string]string{"a": "abc"},
+			change: func(t *testing.T, dir string) { writeFiles(t, dir, map[string]string{"a": "xyz"}) },
+			want:   []string{"modified a"},
+		},
+		{
+			name:   "retargeted symlink",
+			before: map[string]string{"a": "1", "b": "2"},
+			change: func(t *testing.T, dir string) {
+				if err := os.Symlink("a", filepath.Join(dir, "link")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"created link"},
+		},
+		{
+			name:   "file replaced by a directory",
+			before: map[string]string{"x": "1"},
+			change: func(t *testing.T, dir string) {
+				if err := os.Remove(filepath.Join(dir, "x")); err != nil {
+					t.Fatal(err)
+				}
+				writeFiles(t, dir, map[string]string{"x/inner": "2"})
+			},
+			want: []string{"deleted x", "created x/inner"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, test.before)
+			before, err := TakeSnapshot(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.change(t, dir)
+			after, err := TakeSnapshot(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, change := range before.Diff(after) {
+				got = append(got, change.Kind+" "+filepath.ToSlash(change.Path))
+				if change.Kind != Deleted && change.Hash == "" || change.Kind != Created && change.OldHash == "" {
+					t.Errorf("%s %s is missing a hash: %+v", change.Kind, change.Path, change)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("changes = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTakeSnapshotRoots(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"file": "data"})
+
+	tests := []struct {
+		name string
+		root string
+		want []string
+	}{
+		{name: "no root", root: ""},
+		{name: "missing root", root: filepath.Join(dir, "missing")},
+		{name: "single file", root: filepath.Join(dir, "file"), want: []string{"."}},
+		{name: "directory", root: dir, want: []string{"file"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			snapshot, err := TakeSnapshot(test.root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for path := range snapshot.Files {
+				got = append(got, path)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("files = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTakeSnapshotUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read every file")
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"readable": "ok", "secret": "no", "locked/inner": "no"})
+	for _, name := range []string{"secret", "locked"} {
+		if err := os.Chmod(filepath.Join(dir, name), 0); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(filepath.Join(dir, name), 0755)
+	}
+
+	snapshot, err := TakeSnapshot(dir)
+	if err != nil {
+		t.Fatalf("TakeSnapshot: %v", err)
+	}
+	if state := snapshot.Files["secret"]; state.Hash != Unreadable || state.Size != 2 {
+		t.Errorf("secret = %+v, want unreadable with its size", state)
+	}
+	if state := snapshot.Files["locked"]; state.Hash != Unreadable {
+		t.Errorf("locked = %+v, want unreadable", state)
+	}
+	if state := snapshot.Files["readable"]; state.Hash == Unreadable || state.Size != 2 {
+		t.Errorf("readable = %+v", state)
+	}
+}
@@ -0,0 +1,219 @@
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Workspace is a fresh working directory for a single run
+type Workspace struct {
+	Dir  string // Host directory the run starts in
+	Root string // Where the files can be read after the run, Dir unless they live in a sandbox tmpfs
+
+	handle *os.File // Open directory keeping a sandbox tmpfs reachable
+}
+
+// Artifact is a file copied out of the workspace after a run
+type Artifact struct {
+	Path string // Path relative to the workspace
+	Size int64
+}
+
+// Create makes an empty workspace directory, seeded from template unless template is empty
+func Create(template string) (*Workspace, error) {
+	dir, err := os.MkdirTemp("", "kernelscope-run-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %v", err)
+	}
+
+	if template != "" {
+		if err := CopyTree(template, dir); err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("failed to seed workspace from %s: %v", template, err)
+		}
+	}
+	return &Workspace{Dir: dir, Root: dir}, nil
+}
+
+// Name identifies the workspace, e.g. to keep the artifacts of different runs apart
+func (w *Workspace) Name() string {
+	return filepath.Base(w.Dir)
+}
+
+// Chown hands the workspace and everything in it to the given user and group
+func (w *Workspace) Chown(uid, gid int) error {
+	return filepath.Walk(w.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
+
+// Collect copies the files matching any of the glob patterns, relative to the
+// workspace, into dest. Matching directories are copied whole. Files are opened
+// beneath the workspace, so symlinks swapped in by whatever is still running
+// cannot make KernelScope copy files from outside it.
+func (w *Workspace) Collect(patterns []string, dest string) ([]Artifact, error) {
+	root, err := os.OpenRoot(w.Root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open workspace: %v", err)
+	}
+	defer root.Close()
+	files := root.FS()
+
+	var artifacts []Artifact
+	seen := make(map[string]bool)
+
+	for _, pattern := range patterns {
+		// Patterns cannot climb out of the workspace
+		clean := strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+pattern)), "/")
+		if clean == "" {
+			clean = "."
+		}
+		matches, err := fs.Glob(files, clean)
+		if err != nil {
+			return artifacts, fmt.Errorf("invalid collect pattern %q: %v", pattern, err)
+		}
+		sort.Strings(matches)
+
+		for _, match := range matches {
+			// A symlink that matches is not followed, like those met while walking
+			if info, err := root.Lstat(match); err != nil || info.Mode()&os.ModeSymlink != 0 {
+				continue
+			}
+			err := fs.WalkDir(files, match, func(name string, entry fs.DirEntry, err error) error {
+				if err != nil || !entry.Type().IsRegular() {
+					return err
+				}
+				rel := filepath.FromSlash(name)
+				if seen[rel] {
+					return nil
+				}
+				seen[rel] = true
+
+				size, err := copyFromRoot(root, name, filepath.Join(dest, rel))
+				if err != nil {
+					return err
+				}
+				artifacts = append(artifacts, Artifact{Path: rel, Size: size})
+				return nil
+			})
+			if err != nil {
+				return artifacts, fmt.Errorf("failed to collect %s: %v", match, err)
+			}
+		}
+	}
+	return artifacts, nil
+}
+
+// Attach makes the files reachable through an open directory, such as a
+// tmpfs mounted inside a sandbox that outlives the sandbox itself
+func (w *Workspace) Attach(dir *os.File) {
+	w.handle = dir
+	w.Root = fmt.Sprintf("/proc/self/fd/%d", dir.Fd())
+}
+
+// Close lets go of a sandbox tmpfs workspace, leaving the host directory in place
+func (w *Workspace) Close() {
+	if w.handle != nil {
+		w.handle.Close()
+		w.handle = nil
+	}
+}
+
+// Remove deletes the workspace
+func (w *Workspace) Remove() error {
+	w.Close()
+	return os.RemoveAll(w.Dir)
+}
+
+// CopyTree copies the contents of directory src into dst, keeping modes and symlinks
+func CopyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		// Devices, sockets and pipes are skipped
+		return nil
+	})
+}
+
+// copyFile copies a regular file, creating the parent directories of dst
+func copyFile(src, dst string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyFromRoot copies a regular file beneath root to dst, refusing anything that
+// stopped being one after it was listed. It returns the number of bytes copied.
+func copyFromRoot(root *os.Root, name, dst string) (int64, error) {
+	// Non-blocking, so a FIFO swapped in cannot stall the open
+	in, err := root.OpenFile(name, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if !info.Mode().IsRegular() {
+		return 0, fmt.Errorf("%s is no longer a regular file", name)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return 0, err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return n, err
+	}
+	return n, out.Close()
+}
@@ -0,0 +1,91 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCollect(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(outside, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+		wantErr  bool
+	}{
+		{name: "glob", patterns: []string{"*.log"}, want: []string{"a.log", "b.log"}},
+		{name: "directory", patterns: []string{"out"}, want: []string{"out/result.txt", "out/sub/deep.txt"}},
+		{name: "duplicates", patterns: []string{"a.log", "*.log"}, want: []string{"a.log", "b.log"}},
+		{name: "symlinks are not followed", patterns: []string{"escape", "inner"}},
+		{name: "patterns stay inside", patterns: []string{"../../etc/passwd", "/out/result.txt"}, want: []string{"out/result.txt"}},
+		{name: "invalid pattern", patterns: []string{"["}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, data := range map[string]string{"a.log": "a", "b.log": "bb", "out/result.txt": "ok", "out/sub/deep.txt": "deep"} {
+				if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Symlink(outside, filepath.Join(dir, "escape")); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink("a.log", filepath.Join(dir, "inner")); err != nil {
+				t.Fatal(err)
+			}
+
+			dest := t.TempDir()
+			ws := &Workspace{Dir: dir, Root: dir}
+			artifacts, err := ws.Collect(test.patterns, dest)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("Collect = %v, want an error", artifacts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Collect: %v", err)
+			}
+
+			var got []string
+			for _, artifact := range artifacts {
+				got = append(got, filepath.ToSlash(artifact.Path))
+				data, err := os.ReadFile(filepath.Join(dest, artifact.Path))
+				if err != nil || int64(len(data)) != artifact.Size {
+					t.Errorf("%s copied as %q (%v), size %d", artifact.Path, data, err, artifact.Size)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("collected %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCopyFromRoot(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Symlink("/etc/passwd", filepath.Join(dir, "swapped")); err != nil {
+		t.Fatal(err)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	// A file replaced by a symlink out of the workspace after it was listed
+	if _, err := copyFromRoot(root, "swapped", filepath.Join(t.TempDir(), "copy")); err == nil {
+		t.Errorf("copied a file from outside the workspace")
+	}
+}